@@ -185,14 +185,20 @@ func (p *ECCPoller) processItem(ctx context.Context, item servicenow.ECCQueueIte
 		result, processErr = p.handleCreateSession(ctx, payload)
 	case "send_command":
 		result, processErr = p.handleSendCommand(ctx, payload)
+	case "send_signal":
+		result, processErr = p.handleSendSignal(ctx, payload)
 	case "get_output":
 		result, processErr = p.handleGetOutput(ctx, payload)
 	case "get_status":
 		result, processErr = p.handleGetStatus(ctx, payload)
+	case "get_screen":
+		result, processErr = p.handleGetScreen(ctx, payload)
 	case "terminate_session":
 		result, processErr = p.handleTerminateSession(ctx, payload)
 	case "resize_terminal":
 		result, processErr = p.handleResizeTerminal(ctx, payload)
+	case "resume_session":
+		result, processErr = p.handleResumeSession(ctx, payload)
 	default:
 		processErr = fmt.Errorf("unknown action: %s", action)
 	}
@@ -237,6 +243,12 @@ func (p *ECCPoller) handleCreateSession(ctx context.Context, payload map[string]
 		return nil, fmt.Errorf("missing or invalid 'userId' in payload")
 	}
 	workspaceType, _ := payload["workspaceType"].(string)
+	workspaceName, _ := payload["workspaceName"].(string)
+	template, _ := payload["template"].(string)
+	gitRepo, _ := payload["gitRepo"].(string)
+	gitRef, _ := payload["gitRef"].(string)
+	profile, _ := payload["profile"].(string)
+	label, _ := payload["label"].(string)
 
 	credMap, ok := payload["credentials"].(map[string]interface{})
 	if !ok {
@@ -248,7 +260,18 @@ func (p *ECCPoller) handleCreateSession(ctx context.Context, payload map[string]
 	}
 	githubToken, _ := credMap["githubToken"].(string)
 
-	return p.nodeClient.CreateSession(ctx, userID, apiKey, githubToken, workspaceType)
+	return p.nodeClient.CreateSession(ctx, servicenow.CreateSessionParams{
+		UserID:        userID,
+		APIKey:        apiKey,
+		GitHubToken:   githubToken,
+		WorkspaceType: workspaceType,
+		WorkspaceName: workspaceName,
+		Template:      template,
+		GitRepo:       gitRepo,
+		GitRef:        gitRef,
+		Profile:       profile,
+		Label:         label,
+	})
 }
 
 func (p *ECCPoller) handleSendCommand(ctx context.Context, payload map[string]interface{}) (interface{}, error) {
@@ -264,14 +287,65 @@ func (p *ECCPoller) handleSendCommand(ctx context.Context, payload map[string]in
 	return p.nodeClient.SendCommand(ctx, sessionID, command)
 }
 
+func (p *ECCPoller) handleSendSignal(ctx context.Context, payload map[string]interface{}) (interface{}, error) {
+	sessionID, ok := payload["sessionId"].(string)
+	if !ok || sessionID == "" {
+		return nil, fmt.Errorf("missing or invalid 'sessionId' in payload")
+	}
+	signal, ok := payload["signal"].(string)
+	if !ok || signal == "" {
+		return nil, fmt.Errorf("missing or invalid 'signal' in payload")
+	}
+
+	return p.nodeClient.SendSignal(ctx, sessionID, signal)
+}
+
 func (p *ECCPoller) handleGetOutput(ctx context.Context, payload map[string]interface{}) (interface{}, error) {
 	sessionID, ok := payload["sessionId"].(string)
 	if !ok || sessionID == "" {
 		return nil, fmt.Errorf("missing or invalid 'sessionId' in payload")
 	}
-	clear, _ := payload["clear"].(bool)
+	opts := servicenow.OutputOptions{}
+	opts.Clear, _ = payload["clear"].(bool)
+
+	// Cursor reads are non-destructive, so the poller and the widget can
+	// consume the same session without stealing each other's output.
+	if raw, present := payload["after"]; present {
+		after, ok := raw.(float64)
+		if !ok || after < 0 {
+			return nil, fmt.Errorf("invalid 'after' in payload")
+		}
+		cursor := uint64(after)
+		opts.After = &cursor
+	}
+
+	// Optional long-poll, e.g. "wait": "20s". The server caps the wait below
+	// the per-item timeout.
+	if raw, present := payload["wait"]; present {
+		waitStr, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid 'wait' in payload")
+		}
+		wait, err := time.ParseDuration(waitStr)
+		if err != nil || wait < 0 {
+			return nil, fmt.Errorf("invalid 'wait' in payload: %q", waitStr)
+		}
+		if opts.After == nil {
+			return nil, fmt.Errorf("'wait' requires 'after' in payload")
+		}
+		opts.Wait = wait
+	}
+
+	// Optional rendering for journal fields and emails: "text" or "html".
+	if raw, present := payload["format"]; present {
+		format, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid 'format' in payload")
+		}
+		opts.Format = format
+	}
 
-	return p.nodeClient.GetOutput(ctx, sessionID, clear)
+	return p.nodeClient.GetOutput(ctx, sessionID, opts)
 }
 
 func (p *ECCPoller) handleGetStatus(ctx context.Context, payload map[string]interface{}) (interface{}, error) {
@@ -283,6 +357,25 @@ func (p *ECCPoller) handleGetStatus(ctx context.Context, payload map[string]inte
 	return p.nodeClient.GetStatus(ctx, sessionID)
 }
 
+func (p *ECCPoller) handleGetScreen(ctx context.Context, payload map[string]interface{}) (interface{}, error) {
+	sessionID, ok := payload["sessionId"].(string)
+	if !ok || sessionID == "" {
+		return nil, fmt.Errorf("missing or invalid 'sessionId' in payload")
+	}
+	opts := servicenow.ScreenOptions{}
+	opts.Attributes, _ = payload["attributes"].(bool)
+
+	if raw, present := payload["scrollback"]; present {
+		scrollback, ok := raw.(float64)
+		if !ok || scrollback < 0 {
+			return nil, fmt.Errorf("invalid 'scrollback' in payload")
+		}
+		opts.Scrollback = int(scrollback)
+	}
+
+	return p.nodeClient.GetScreen(ctx, sessionID, opts)
+}
+
 func (p *ECCPoller) handleTerminateSession(ctx context.Context, payload map[string]interface{}) (interface{}, error) {
 	sessionID, ok := payload["sessionId"].(string)
 	if !ok || sessionID == "" {
@@ -308,3 +401,12 @@ func (p *ECCPoller) handleResizeTerminal(ctx context.Context, payload map[string
 
 	return p.nodeClient.ResizeTerminal(ctx, sessionID, int(cols), int(rows))
 }
+
+func (p *ECCPoller) handleResumeSession(ctx context.Context, payload map[string]interface{}) (interface{}, error) {
+	sessionID, ok := payload["sessionId"].(string)
+	if !ok || sessionID == "" {
+		return nil, fmt.Errorf("missing or invalid 'sessionId' in payload")
+	}
+
+	return p.nodeClient.ResumeSession(ctx, sessionID)
+}
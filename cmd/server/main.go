@@ -37,6 +37,11 @@ func main() {
 	// H7: Deduplicated shared logging setup
 	logging.Setup(cfg)
 
+	// "migrate" manages the PostgreSQL schema instead of starting the server.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, os.Args[2:]))
+	}
+
 	// C1: Validate auth token configuration
 	if cfg.Security.APIAuthToken == "" {
 		if cfg.Server.Mode == "release" {
@@ -50,26 +55,41 @@ func main() {
 	log.Infof("ServiceNow Instance: %s", cfg.ServiceNow.Instance)
 	log.Infof("Workspace Base: %s", cfg.Workspace.BasePath)
 
-	// Initialize PostgreSQL store (optional).
-	var pgStore *store.PostgresStore
+	// Initialize the session store (optional).
+	var sessionStore store.Store
 	if cfg.Database.Enabled() {
-		log.Infof("Connecting to PostgreSQL at %s:%d/%s", cfg.Database.Host, cfg.Database.Port, cfg.Database.DBName)
+		switch cfg.Database.Driver {
+		case store.DriverPostgres:
+			log.Infof("Connecting to PostgreSQL at %s:%d/%s", cfg.Database.Host, cfg.Database.Port, cfg.Database.DBName)
+		case store.DriverSQLite:
+			log.Infof("Opening SQLite store at %s", cfg.Database.SQLitePath)
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		var err error
-		pgStore, err = store.NewPostgresStore(ctx, cfg.Database)
+		sessionStore, err = store.Open(ctx, cfg.Database)
 		cancel()
 		if err != nil {
-			log.WithError(err).Warn("Failed to initialize PostgreSQL store; falling back to in-memory sessions")
-			pgStore = nil
+			log.WithError(err).Warnf("Failed to initialize %s store; falling back to in-memory sessions", cfg.Database.Driver)
+			sessionStore = nil
+		}
+		// C6: output and recordings are encrypted at rest under per-session
+		// and per-recording keys.
+		if sessionStore != nil && cfg.Database.EncryptOutput {
+			encrypted, err := store.NewEncryptedStore(sessionStore, cfg.Security.EncryptionKey)
+			if err != nil {
+				log.Fatalf("Failed to enable output encryption: %v", err)
+			}
+			sessionStore = encrypted
+			log.Info("Session output and recordings are encrypted at rest")
 		}
 	} else {
-		log.Info("DB_HOST not set; running with in-memory session storage only")
+		log.Info("STORE_DRIVER and DB_HOST not set; running with in-memory session storage only")
 	}
 
 	// Initialize session manager
-	sessionManager := session.NewManager(cfg, pgStore)
+	sessionManager := session.NewManager(cfg, sessionStore)
 
-	// Recover stale sessions from previous run.
+	// Reattach sessions left running by the previous run.
 	{
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		sessionManager.RecoverSessions(ctx)
@@ -79,6 +99,16 @@ func main() {
 	// Start session timeout checker
 	go sessionManager.StartTimeoutChecker(context.Background())
 
+	// Purge expired sessions and recordings and compact stored output in the
+	// background.
+	janitorCtx, stopJanitor := context.WithCancel(context.Background())
+	if sessionStore != nil {
+		go store.StartJanitor(janitorCtx, sessionStore, cfg.Retention)
+	}
+	if cfg.Retention.RecordingDays > 0 {
+		go sessionManager.StartRecordingJanitor(janitorCtx)
+	}
+
 	// Initialize HTTP server
 	router := setupRouter(cfg)
 	srv := server.New(cfg, sessionManager, router)
@@ -125,9 +155,10 @@ func main() {
 	log.Info("Cleaning up sessions...")
 	sessionManager.CleanupAll()
 
-	// Close PostgreSQL store.
-	if pgStore != nil {
-		pgStore.Close()
+	// Close the session store.
+	stopJanitor()
+	if sessionStore != nil {
+		sessionStore.Close()
 	}
 
 	// Shutdown HTTP server with timeout
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+)
+
+const migrateUsage = "usage: claude-terminal-service migrate [up|status|down]"
+
+// runMigrate implements the migrate subcommand and returns the exit code:
+//
+//	migrate [up]   apply pending migrations
+//	migrate status list migrations and when they were applied
+//	migrate down   roll back the most recent migration
+func runMigrate(cfg *config.Config, args []string) int {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if len(args) > 1 || (action != "up" && action != "status" && action != "down") {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	if cfg.Database.Driver != store.DriverPostgres {
+		fmt.Fprintln(os.Stderr, "migrate requires the PostgreSQL store: set STORE_DRIVER=postgres or DB_HOST")
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	db, err := store.ConnectPostgres(ctx, cfg.Database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch action {
+	case "up":
+		applied, err := db.Migrate(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+
+	case "down":
+		m, err := db.Rollback(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			return 1
+		}
+		if m == nil {
+			fmt.Println("no migrations applied")
+		} else {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+
+	case "status":
+		status, err := db.MigrationStatus(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, st := range status {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", st.Version, st.Name, applied)
+		}
+		w.Flush()
+	}
+	return 0
+}
@@ -1,10 +1,14 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/redact"
 )
 
 // Config holds all configuration for the service
@@ -16,16 +20,75 @@ type Config struct {
 	Logging    LoggingConfig
 	Security   SecurityConfig
 	Database   DatabaseConfig
+	Sandbox    SandboxConfig
+	Recording  RecordingConfig
+	Redaction  RedactionConfig
+	Retention  RetentionConfig
+}
+
+// RetentionConfig controls how long persisted sessions, their output and
+// their recordings are kept, and how large a session's stored output may grow.
+type RetentionConfig struct {
+	Days                   int // keep ended sessions this long; 0 deletes them when they terminate
+	MaxOutputBytes         int // per session; 0 means no cap
+	CompactAfterMinutes    int // merge output chunks once they are this old
+	CompactBlobBytes       int // largest merged chunk; 0 disables merging
+	RecordingDays          int // delete recordings this long after they started, once the session ended; 0 keeps them
+	JanitorIntervalMinutes int
+}
+
+// RedactionConfig controls masking of secrets in session output before it is
+// buffered, recorded or persisted.
+type RedactionConfig struct {
+	Enabled     bool
+	Patterns    []redact.Pattern // built-in patterns merged with REDACTION_PATTERNS_FILE
+	WindowBytes int              // longest secret caught when split across PTY reads
+}
+
+// RecordingConfig controls asciicast v2 recordings of session I/O.
+type RecordingConfig struct {
+	Enabled bool
+	Path    string // directory for <session-id>.cast files; keep outside WORKSPACE_BASE_PATH
+}
+
+// SandboxConfig controls optional Linux namespace and cgroup v2 isolation of
+// session processes. Zero limits mean "no limit".
+type SandboxConfig struct {
+	Enabled        bool
+	IsolateNetwork bool   // new network namespace with loopback only
+	CgroupRoot     string // delegated cgroup v2 directory; empty disables resource limits
+	CPUMillicores  int    // e.g. 1500 = 1.5 CPUs
+	MemoryMaxMB    int
+	PidsMax        int
+
+	// A root service runs the agent as this host user and group, which own
+	// the workspace while it runs; other services run it as themselves.
+	UID int
+	GID int
+	// ReadOnlyPaths are bound read-only into the sandbox's otherwise empty
+	// root, next to the workspace, a minimal /dev, /tmp and /proc.
+	ReadOnlyPaths []string
 }
 
-// DatabaseConfig holds PostgreSQL connection configuration.
+// DatabaseConfig selects the session store and holds its connection
+// configuration.
 type DatabaseConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	DBName   string
-	SSLMode  string
+	Driver     string // "postgres", "sqlite", "memory"; empty for no persistence
+	SQLitePath string
+	Host       string
+	Port       int
+	User       string
+	Password   string
+	DBName     string
+	SSLMode    string
+
+	// Session output is written in batches by a per-session writer.
+	OutputBatchSize   int // most chunks per insert
+	OutputFlushMillis int // longest a chunk waits before it is written
+	OutputQueueBytes  int // per-session backlog; beyond it output is dropped, not persisted
+	// EncryptOutput stores output encrypted under per-session keys wrapped
+	// by ENCRYPTION_KEY.
+	EncryptOutput bool
 }
 
 // ServiceNowConfig holds ServiceNow instance configuration
@@ -47,12 +110,45 @@ type SessionConfig struct {
 	TimeoutMinutes   int
 	MaxPerUser       int
 	OutputBufferSize int
+	KillGraceSeconds int                     // SIGTERM-to-SIGKILL delay when terminating a session
+	ScrollbackLines  int                     // lines of history kept by the screen emulator
+	SupervisorDir    string                  // supervisor sockets, so sessions survive restarts; empty runs agents as children of the service
+	Agent            AgentProfile            // program launched when a request names no profile
+	Profiles         map[string]AgentProfile // admin-defined allowlist selectable per request
+}
+
+// DefaultProfile is the profile name that selects SessionConfig.Agent.
+const DefaultProfile = "default"
+
+// AgentProfile describes the program launched inside a session's PTY.
+type AgentProfile struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env,omitempty"`
+	// ResumeArgs are appended to Args when a terminated session is resumed,
+	// e.g. the CLI's --continue.
+	ResumeArgs []string `json:"resume_args,omitempty"`
+}
+
+// Profile returns the agent profile for name; empty selects the default.
+func (s SessionConfig) Profile(name string) (AgentProfile, error) {
+	if name == "" || name == DefaultProfile {
+		return s.Agent, nil
+	}
+	p, ok := s.Profiles[name]
+	if !ok {
+		return AgentProfile{}, fmt.Errorf("unknown profile %q", name)
+	}
+	return p, nil
 }
 
 // WorkspaceConfig holds workspace configuration
 type WorkspaceConfig struct {
-	BasePath string
-	Type     string // "isolated" or "persistent"
+	BasePath        string
+	Type            string   // default type when a request omits it: "isolated" or "persistent"
+	TemplatesPath   string   // directory of named workspace templates; empty disables templates
+	ReposPath       string   // directory of local bare git repos that may be cloned; empty disables local clones
+	GitAllowedHosts []string // hosts allowed for https:// clones; empty disables remote clones
 }
 
 // LoggingConfig holds logging configuration
@@ -68,12 +164,26 @@ type SecurityConfig struct {
 	CORSAllowedOrigins []string
 	TLSCertPath        string
 	TLSKeyPath         string
+	// AdminUserIDs may act across users, e.g. search every user's output.
+	AdminUserIDs []string
 }
 
-// Enabled returns true when a DB_HOST has been explicitly set, indicating
-// the operator wants PostgreSQL-backed session persistence.
+// IsAdmin reports whether userID is listed in ADMIN_USER_IDS.
+func (s SecurityConfig) IsAdmin(userID string) bool {
+	if userID == "" {
+		return false
+	}
+	for _, id := range s.AdminUserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled returns true when a session store has been selected.
 func (d DatabaseConfig) Enabled() bool {
-	return os.Getenv("DB_HOST") != ""
+	return d.Driver != ""
 }
 
 // Load loads configuration from environment variables
@@ -93,10 +203,22 @@ func Load() (*Config, error) {
 			TimeoutMinutes:   getEnvInt("SESSION_TIMEOUT_MINUTES", 30),
 			MaxPerUser:       getEnvInt("MAX_SESSIONS_PER_USER", 3),
 			OutputBufferSize: getEnvInt("OUTPUT_BUFFER_SIZE", 100),
+			KillGraceSeconds: getEnvInt("SESSION_KILL_GRACE_SECONDS", 5),
+			ScrollbackLines:  getEnvInt("SCREEN_SCROLLBACK_LINES", 1000),
+			SupervisorDir:    supervisorDir(),
+			Agent: AgentProfile{
+				Command:    getEnv("AGENT_COMMAND", "claude"),
+				Args:       strings.Fields(getEnv("AGENT_ARGS", "code")),
+				Env:        parseEnvList(getEnv("AGENT_ENV", "")),
+				ResumeArgs: strings.Fields(getEnv("AGENT_RESUME_ARGS", "--continue")),
+			},
 		},
 		Workspace: WorkspaceConfig{
-			BasePath: getEnv("WORKSPACE_BASE_PATH", "/tmp/claude-sessions"),
-			Type:     getEnv("WORKSPACE_TYPE", "isolated"),
+			BasePath:        getEnv("WORKSPACE_BASE_PATH", "/tmp/claude-sessions"),
+			Type:            getEnv("WORKSPACE_TYPE", "isolated"),
+			TemplatesPath:   getEnv("WORKSPACE_TEMPLATES_PATH", ""),
+			ReposPath:       getEnv("WORKSPACE_REPOS_PATH", ""),
+			GitAllowedHosts: parseList(getEnv("WORKSPACE_GIT_ALLOWED_HOSTS", "github.com")),
 		},
 		Logging: LoggingConfig{
 			Level: getEnv("LOG_LEVEL", "info"),
@@ -105,20 +227,76 @@ func Load() (*Config, error) {
 		Security: SecurityConfig{
 			EncryptionKey:      getEnv("ENCRYPTION_KEY", ""),
 			APIAuthToken:       getEnv("API_AUTH_TOKEN", ""),
-			CORSAllowedOrigins: parseCORSOrigins(getEnv("CORS_ALLOWED_ORIGINS", "http://localhost")),
+			CORSAllowedOrigins: parseList(getEnv("CORS_ALLOWED_ORIGINS", "http://localhost")),
 			TLSCertPath:        getEnv("TLS_CERT_PATH", ""),
 			TLSKeyPath:         getEnv("TLS_KEY_PATH", ""),
+			AdminUserIDs:       parseList(getEnv("ADMIN_USER_IDS", "")),
 		},
 		Database: DatabaseConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnvInt("DB_PORT", 5432),
-			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", ""),
-			DBName:   getEnv("DB_NAME", "claude_terminal"),
-			SSLMode:  getEnv("DB_SSLMODE", "disable"),
+			Driver:     getEnv("STORE_DRIVER", ""),
+			SQLitePath: getEnv("SQLITE_PATH", "/var/lib/claude-terminal/sessions.db"),
+			Host:       getEnv("DB_HOST", "localhost"),
+			Port:       getEnvInt("DB_PORT", 5432),
+			User:       getEnv("DB_USER", "postgres"),
+			Password:   getEnv("DB_PASSWORD", ""),
+			DBName:     getEnv("DB_NAME", "claude_terminal"),
+			SSLMode:    getEnv("DB_SSLMODE", "disable"),
+
+			OutputBatchSize:   getEnvInt("OUTPUT_BATCH_SIZE", 64),
+			OutputFlushMillis: getEnvInt("OUTPUT_FLUSH_INTERVAL_MS", 250),
+			OutputQueueBytes:  getEnvInt("OUTPUT_QUEUE_BYTES", 1<<20),
+			EncryptOutput:     getEnvBool("OUTPUT_ENCRYPTION_ENABLED", false),
 		},
 	}
 
+	// Setting DB_HOST alone keeps selecting PostgreSQL, as before
+	// STORE_DRIVER existed.
+	if cfg.Database.Driver == "" && os.Getenv("DB_HOST") != "" {
+		cfg.Database.Driver = "postgres"
+	}
+	switch cfg.Database.Driver {
+	case "", "postgres", "sqlite", "memory":
+	default:
+		return nil, fmt.Errorf("invalid STORE_DRIVER %q: must be postgres, sqlite or memory", cfg.Database.Driver)
+	}
+	if cfg.Database.EncryptOutput && cfg.Security.EncryptionKey == "" {
+		return nil, fmt.Errorf("OUTPUT_ENCRYPTION_ENABLED requires ENCRYPTION_KEY")
+	}
+
+	cfg.Sandbox = SandboxConfig{
+		Enabled:        getEnvBool("SANDBOX_ENABLED", false),
+		IsolateNetwork: getEnvBool("SANDBOX_ISOLATE_NETWORK", true),
+		CgroupRoot:     getEnv("SANDBOX_CGROUP_ROOT", "/sys/fs/cgroup/claude-terminal"),
+		CPUMillicores:  getEnvInt("SANDBOX_CPU_MILLICORES", 0),
+		MemoryMaxMB:    getEnvInt("SANDBOX_MEMORY_MAX_MB", 0),
+		PidsMax:        getEnvInt("SANDBOX_PIDS_MAX", 0),
+		UID:            getEnvInt("SANDBOX_UID", 65534),
+		GID:            getEnvInt("SANDBOX_GID", 65534),
+		ReadOnlyPaths:  parseList(getEnv("SANDBOX_READONLY_PATHS", "/usr,/bin,/sbin,/lib,/lib64,/etc,/opt")),
+	}
+	if cfg.Sandbox.Enabled && (cfg.Sandbox.UID <= 0 || cfg.Sandbox.GID <= 0) {
+		return nil, fmt.Errorf("SANDBOX_UID and SANDBOX_GID must be unprivileged IDs")
+	}
+
+	cfg.Recording = RecordingConfig{
+		Enabled: getEnvBool("RECORDING_ENABLED", true),
+		Path:    getEnv("RECORDING_PATH", "/var/lib/claude-terminal/recordings"),
+	}
+
+	cfg.Retention = RetentionConfig{
+		Days:                   getEnvInt("SESSION_RETENTION_DAYS", 30),
+		MaxOutputBytes:         getEnvInt("OUTPUT_MAX_BYTES_PER_SESSION", 10<<20),
+		CompactAfterMinutes:    getEnvInt("OUTPUT_COMPACT_AFTER_MINUTES", 60),
+		CompactBlobBytes:       getEnvInt("OUTPUT_COMPACT_BLOB_BYTES", 64<<10),
+		RecordingDays:          getEnvInt("RECORDING_RETENTION_DAYS", 90),
+		JanitorIntervalMinutes: getEnvInt("RETENTION_JANITOR_INTERVAL_MINUTES", 15),
+	}
+
+	cfg.Redaction = RedactionConfig{
+		Enabled:     getEnvBool("REDACTION_ENABLED", true),
+		WindowBytes: getEnvInt("REDACTION_WINDOW_BYTES", 8192),
+	}
+
 	// Validate required fields
 	if cfg.ServiceNow.Instance == "" {
 		return nil, fmt.Errorf("SERVICENOW_INSTANCE is required")
@@ -130,9 +308,32 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("SERVICENOW_API_PASSWORD is required")
 	}
 
+	if path := getEnv("AGENT_PROFILES_FILE", ""); path != "" {
+		profiles, err := loadProfiles(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Session.Profiles = profiles
+	}
+
+	patterns, err := loadRedactionPatterns(getEnv("REDACTION_PATTERNS_FILE", ""))
+	if err != nil {
+		return nil, err
+	}
+	cfg.Redaction.Patterns = patterns
+
 	return cfg, nil
 }
 
+// supervisorDir returns SESSION_SUPERVISOR_DIR, or "" when supervision is
+// disabled. It defaults to on where it is supported (Linux).
+func supervisorDir() string {
+	if !getEnvBool("SESSION_SUPERVISOR_ENABLED", runtime.GOOS == "linux") {
+		return ""
+	}
+	return getEnv("SESSION_SUPERVISOR_DIR", "/var/lib/claude-terminal/supervisors")
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -149,7 +350,17 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
-func parseCORSOrigins(raw string) []string {
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// parseList splits a comma-separated value, trimming blanks.
+func parseList(raw string) []string {
 	parts := strings.Split(raw, ",")
 	origins := make([]string, 0, len(parts))
 	for _, p := range parts {
@@ -160,3 +371,70 @@ func parseCORSOrigins(raw string) []string {
 	}
 	return origins
 }
+
+// parseEnvList parses "KEY=VALUE,KEY=VALUE" into a map, skipping malformed entries.
+func parseEnvList(raw string) map[string]string {
+	env := make(map[string]string)
+	for _, item := range parseList(raw) {
+		key, value, ok := strings.Cut(item, "=")
+		if ok && key != "" {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// loadProfiles reads the agent profile allowlist from a JSON file of the form
+// {"name": {"command": "...", "args": [...], "env": {...}}}.
+func loadProfiles(path string) (map[string]AgentProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AGENT_PROFILES_FILE: %w", err)
+	}
+
+	var profiles map[string]AgentProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse AGENT_PROFILES_FILE: %w", err)
+	}
+
+	for name, p := range profiles {
+		if name == DefaultProfile {
+			return nil, fmt.Errorf("AGENT_PROFILES_FILE: profile name %q is reserved", DefaultProfile)
+		}
+		if p.Command == "" {
+			return nil, fmt.Errorf("AGENT_PROFILES_FILE: profile %q has no command", name)
+		}
+	}
+
+	return profiles, nil
+}
+
+// loadRedactionPatterns compiles the built-in redaction patterns merged with
+// those in an optional JSON file of the form {"name": "regexp"}. A file entry
+// replaces the built-in pattern of the same name; "" disables it.
+func loadRedactionPatterns(path string) ([]redact.Pattern, error) {
+	patterns := make(map[string]string, len(redact.DefaultPatterns))
+	for name, expr := range redact.DefaultPatterns {
+		patterns[name] = expr
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read REDACTION_PATTERNS_FILE: %w", err)
+		}
+		var custom map[string]string
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return nil, fmt.Errorf("failed to parse REDACTION_PATTERNS_FILE: %w", err)
+		}
+		for name, expr := range custom {
+			patterns[name] = expr
+		}
+	}
+
+	compiled, err := redact.Compile(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("REDACTION_PATTERNS_FILE: %w", err)
+	}
+	return compiled, nil
+}
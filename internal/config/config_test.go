@@ -1,6 +1,9 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -69,6 +72,7 @@ func TestCustomValues(t *testing.T) {
 	t.Setenv("SESSION_TIMEOUT_MINUTES", "60")
 	t.Setenv("MAX_SESSIONS_PER_USER", "5")
 	t.Setenv("WORKSPACE_TYPE", "persistent")
+	t.Setenv("ADMIN_USER_IDS", "root, ops.admin")
 
 	cfg, err := Load()
 	if err != nil {
@@ -90,6 +94,90 @@ func TestCustomValues(t *testing.T) {
 	if cfg.Workspace.Type != "persistent" {
 		t.Errorf("Expected workspace type persistent, got %s", cfg.Workspace.Type)
 	}
+
+	if !cfg.Security.IsAdmin("ops.admin") || cfg.Security.IsAdmin("john.doe") || cfg.Security.IsAdmin("") {
+		t.Errorf("Unexpected admins %v", cfg.Security.AdminUserIDs)
+	}
+}
+
+func TestAgentConfig(t *testing.T) {
+	t.Setenv("SERVICENOW_INSTANCE", "test.service-now.com")
+	t.Setenv("SERVICENOW_API_USER", "test_user")
+	t.Setenv("SERVICENOW_API_PASSWORD", "test_password")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.Session.Agent.Command != "claude" || len(cfg.Session.Agent.Args) != 1 || cfg.Session.Agent.Args[0] != "code" {
+		t.Errorf("Expected default agent 'claude code', got %+v", cfg.Session.Agent)
+	}
+	if len(cfg.Session.Agent.ResumeArgs) != 1 || cfg.Session.Agent.ResumeArgs[0] != "--continue" {
+		t.Errorf("Expected default resume args '--continue', got %v", cfg.Session.Agent.ResumeArgs)
+	}
+
+	t.Setenv("AGENT_COMMAND", "/usr/local/bin/claude")
+	t.Setenv("AGENT_ARGS", "--verbose --model sonnet")
+	t.Setenv("AGENT_ENV", "DISABLE_TELEMETRY=1, CLAUDE_CONFIG_DIR=/etc/claude")
+
+	profilesPath := filepath.Join(t.TempDir(), "profiles.json")
+	os.WriteFile(profilesPath, []byte(`{
+		"shell": {"command": "/bin/bash", "args": ["-l"], "resume_args": ["-c", "exec bash -l"]},
+		"stub": {"command": "/bin/cat", "env": {"STUB": "1"}}
+	}`), 0644)
+	t.Setenv("AGENT_PROFILES_FILE", profilesPath)
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	agent, err := cfg.Session.Profile("")
+	if err != nil || agent.Command != "/usr/local/bin/claude" || len(agent.Args) != 3 {
+		t.Errorf("Unexpected default profile %+v (err %v)", agent, err)
+	}
+	if agent.Env["DISABLE_TELEMETRY"] != "1" || agent.Env["CLAUDE_CONFIG_DIR"] != "/etc/claude" {
+		t.Errorf("Unexpected agent env %v", agent.Env)
+	}
+
+	stub, err := cfg.Session.Profile("stub")
+	if err != nil || stub.Command != "/bin/cat" || stub.Env["STUB"] != "1" {
+		t.Errorf("Unexpected stub profile %+v (err %v)", stub, err)
+	}
+
+	if shell, _ := cfg.Session.Profile("shell"); len(shell.ResumeArgs) != 2 {
+		t.Errorf("Unexpected shell resume args %v", shell.ResumeArgs)
+	}
+
+	if _, err := cfg.Session.Profile("root-shell"); err == nil {
+		t.Error("Expected error for profile outside the allowlist")
+	}
+}
+
+func TestAgentProfilesFileInvalid(t *testing.T) {
+	t.Setenv("SERVICENOW_INSTANCE", "test.service-now.com")
+	t.Setenv("SERVICENOW_API_USER", "test_user")
+	t.Setenv("SERVICENOW_API_PASSWORD", "test_password")
+
+	dir := t.TempDir()
+	cases := map[string]string{
+		"malformed.json": `{"shell": `,
+		"nocommand.json": `{"shell": {"args": ["-l"]}}`,
+		"reserved.json":  `{"default": {"command": "/bin/sh"}}`,
+	}
+	for name, content := range cases {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0644)
+		t.Setenv("AGENT_PROFILES_FILE", path)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+
+	t.Setenv("AGENT_PROFILES_FILE", filepath.Join(dir, "missing.json"))
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for missing profiles file")
+	}
 }
 
 func BenchmarkLoadConfig(b *testing.B) {
@@ -102,3 +190,154 @@ func BenchmarkLoadConfig(b *testing.B) {
 		_, _ = Load()
 	}
 }
+
+func TestSandboxConfig(t *testing.T) {
+	t.Setenv("SERVICENOW_INSTANCE", "test.service-now.com")
+	t.Setenv("SERVICENOW_API_USER", "test_user")
+	t.Setenv("SERVICENOW_API_PASSWORD", "test_password")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.Sandbox.Enabled || !cfg.Sandbox.IsolateNetwork {
+		t.Errorf("Expected sandbox disabled with network isolation by default, got %+v", cfg.Sandbox)
+	}
+
+	t.Setenv("SANDBOX_ENABLED", "true")
+	t.Setenv("SANDBOX_ISOLATE_NETWORK", "false")
+	t.Setenv("SANDBOX_CPU_MILLICORES", "1500")
+	t.Setenv("SANDBOX_MEMORY_MAX_MB", "2048")
+	t.Setenv("SANDBOX_PIDS_MAX", "256")
+	t.Setenv("SANDBOX_UID", "2000")
+	t.Setenv("SANDBOX_GID", "2001")
+	t.Setenv("SANDBOX_READONLY_PATHS", "/usr, /etc")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	want := SandboxConfig{
+		Enabled:       true,
+		CgroupRoot:    "/sys/fs/cgroup/claude-terminal",
+		CPUMillicores: 1500,
+		MemoryMaxMB:   2048,
+		PidsMax:       256,
+		UID:           2000,
+		GID:           2001,
+		ReadOnlyPaths: []string{"/usr", "/etc"},
+	}
+	if !reflect.DeepEqual(cfg.Sandbox, want) {
+		t.Errorf("Expected %+v, got %+v", want, cfg.Sandbox)
+	}
+
+	// The agent must never run as root.
+	t.Setenv("SANDBOX_UID", "0")
+	if _, err := Load(); err == nil {
+		t.Error("Expected Load() to reject SANDBOX_UID=0")
+	}
+}
+
+func TestRecordingConfig(t *testing.T) {
+	t.Setenv("SERVICENOW_INSTANCE", "test.service-now.com")
+	t.Setenv("SERVICENOW_API_USER", "test_user")
+	t.Setenv("SERVICENOW_API_PASSWORD", "test_password")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if want := (RecordingConfig{Enabled: true, Path: "/var/lib/claude-terminal/recordings"}); cfg.Recording != want {
+		t.Errorf("Expected recording defaults %+v, got %+v", want, cfg.Recording)
+	}
+
+	t.Setenv("RECORDING_ENABLED", "false")
+	t.Setenv("RECORDING_PATH", "/srv/claude/recordings")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if want := (RecordingConfig{Path: "/srv/claude/recordings"}); cfg.Recording != want {
+		t.Errorf("Expected %+v, got %+v", want, cfg.Recording)
+	}
+}
+
+func TestRedactionConfig(t *testing.T) {
+	t.Setenv("SERVICENOW_INSTANCE", "test.service-now.com")
+	t.Setenv("SERVICENOW_API_USER", "test_user")
+	t.Setenv("SERVICENOW_API_PASSWORD", "test_password")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if !cfg.Redaction.Enabled || cfg.Redaction.WindowBytes != 8192 || len(cfg.Redaction.Patterns) == 0 {
+		t.Errorf("Expected redaction enabled with built-in patterns, got %+v", cfg.Redaction)
+	}
+
+	path := filepath.Join(t.TempDir(), "patterns.json")
+	os.WriteFile(path, []byte(`{"internal_token": "itk_[a-z0-9]{32}", "jwt": ""}`), 0644)
+	t.Setenv("REDACTION_PATTERNS_FILE", path)
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, p := range cfg.Redaction.Patterns {
+		names[p.Name] = true
+	}
+	if !names["internal_token"] || !names["aws_access_key"] || names["jwt"] {
+		t.Errorf("Expected custom pattern added and jwt disabled, got %v", names)
+	}
+
+	os.WriteFile(path, []byte(`{"broken": "("}`), 0644)
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for invalid redaction pattern")
+	}
+}
+
+func TestStoreDriverConfig(t *testing.T) {
+	t.Setenv("SERVICENOW_INSTANCE", "test.service-now.com")
+	t.Setenv("SERVICENOW_API_USER", "test_user")
+	t.Setenv("SERVICENOW_API_PASSWORD", "test_password")
+	t.Setenv("DB_HOST", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if cfg.Database.Enabled() {
+		t.Errorf("Expected no store by default, got driver %q", cfg.Database.Driver)
+	}
+
+	// DB_HOST alone still selects PostgreSQL.
+	t.Setenv("DB_HOST", "db.internal")
+	if cfg, _ = Load(); cfg.Database.Driver != "postgres" {
+		t.Errorf("Expected postgres driver with DB_HOST set, got %q", cfg.Database.Driver)
+	}
+
+	t.Setenv("STORE_DRIVER", "sqlite")
+	t.Setenv("SQLITE_PATH", "/data/sessions.db")
+	if cfg, _ = Load(); cfg.Database.Driver != "sqlite" || cfg.Database.SQLitePath != "/data/sessions.db" {
+		t.Errorf("Expected sqlite at /data/sessions.db, got %+v", cfg.Database)
+	}
+
+	t.Setenv("STORE_DRIVER", "mongodb")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for unknown STORE_DRIVER")
+	}
+	t.Setenv("STORE_DRIVER", "sqlite")
+
+	// Output encryption needs the master key.
+	t.Setenv("OUTPUT_ENCRYPTION_ENABLED", "true")
+	t.Setenv("ENCRYPTION_KEY", "")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for OUTPUT_ENCRYPTION_ENABLED without ENCRYPTION_KEY")
+	}
+	t.Setenv("ENCRYPTION_KEY", "abababababababababababababababababababababababababababababababab")
+	if cfg, err := Load(); err != nil || !cfg.Database.EncryptOutput {
+		t.Errorf("Expected output encryption enabled, got %+v, %v", cfg.Database, err)
+	}
+}
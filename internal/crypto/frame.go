@@ -0,0 +1,50 @@
+package crypto
+
+import "strings"
+
+// An encrypted frame holds Encrypt's hex ciphertext between these markers.
+// Frames are self-delimiting, so frames and plaintext can be concatenated
+// and still be opened.
+const (
+	FrameStart = "\x1b]ctenc;"
+	FrameEnd   = "\x07"
+)
+
+// SealFrame encrypts data under hexKey into a frame.
+func SealFrame(data, hexKey string) (string, error) {
+	ciphertext, err := Encrypt([]byte(data), hexKey)
+	if err != nil {
+		return "", err
+	}
+	return FrameStart + ciphertext + FrameEnd, nil
+}
+
+// OpenFrames replaces every encrypted frame in data with its plaintext. A
+// frame that does not decrypt, e.g. text that merely looks like one, is left
+// as it is.
+func OpenFrames(data, hexKey string) string {
+	if !strings.Contains(data, FrameStart) {
+		return data
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(data, FrameStart)
+		if i < 0 {
+			break
+		}
+		n := strings.Index(data[i+len(FrameStart):], FrameEnd)
+		if n < 0 {
+			break
+		}
+		end := i + len(FrameStart) + n + len(FrameEnd)
+		b.WriteString(data[:i])
+		if plaintext, err := Decrypt(data[i+len(FrameStart):end-len(FrameEnd)], hexKey); err == nil {
+			b.Write(plaintext)
+		} else {
+			b.WriteString(data[i:end])
+		}
+		data = data[end:]
+	}
+	b.WriteString(data)
+	return b.String()
+}
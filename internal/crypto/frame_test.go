@@ -0,0 +1,26 @@
+package crypto
+
+import "testing"
+
+func TestOpenFrames(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	frame := func(data string) string {
+		t.Helper()
+		sealed, err := SealFrame(data, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealed
+	}
+	for _, tc := range []struct{ data, want string }{
+		{"plain", "plain"},
+		{frame("a") + frame("b"), "ab"},
+		{"x" + frame("a") + "y", "xay"},
+		{FrameStart + "zz" + FrameEnd, FrameStart + "zz" + FrameEnd},
+		{FrameStart + "unterminated", FrameStart + "unterminated"},
+	} {
+		if got := OpenFrames(tc.data, key); got != tc.want {
+			t.Errorf("OpenFrames(%q) = %q, want %q", tc.data, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultPatterns are the secret formats masked out of the box, by name. A
+// configured pattern of the same name replaces the built-in one.
+var DefaultPatterns = map[string]string{
+	"aws_access_key":    `AKIA[0-9A-Z]{16}`,
+	"aws_temporary_key": `ASIA[0-9A-Z]{16}`,
+	"github_token":      `gh[pousr]_[A-Za-z0-9]{36,255}`,
+	"github_pat":        `github_pat_[A-Za-z0-9_]{22,255}`,
+	"anthropic_key":     `sk-ant-[A-Za-z0-9_-]{20,}`,
+	"jwt":               `eyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`,
+	"private_key":       `-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+}
+
+// MinSecretLength is the shortest literal secret that is masked; shorter
+// values would mask ordinary words.
+const MinSecretLength = 8
+
+// Pattern is a named secret format.
+type Pattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+// Compile compiles named patterns, sorted by name. Empty patterns are
+// skipped, so a configured "" disables a built-in pattern.
+func Compile(patterns map[string]string) ([]Pattern, error) {
+	names := make([]string, 0, len(patterns))
+	for name, expr := range patterns {
+		if expr != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	compiled := make([]Pattern, 0, len(names))
+	for _, name := range names {
+		re, err := regexp.Compile(patterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", name, err)
+		}
+		compiled = append(compiled, Pattern{Name: name, Regexp: re})
+	}
+	return compiled, nil
+}
+
+// rule is a pattern or literal secret prepared for streaming matching.
+type rule struct {
+	name   string
+	re     *regexp.Regexp // nil for literal secrets
+	secret string
+	prefix string // text every match starts with; empty if unknown
+	maxLen int    // longest possible match in bytes; -1 if unbounded
+}
+
+// match is a span of buf to mask. open matches touch the end of the data
+// and may still grow with the next chunk.
+type match struct {
+	start, end int
+	name       string
+	open       bool
+}
+
+// Redactor masks secrets in a stream of output chunks. A secret split
+// across chunks is caught by holding back the tail of a chunk while it
+// could still be the start of one, up to window bytes. Held data is
+// released by the next Write or by Flush. A Redactor is not safe for
+// concurrent use.
+type Redactor struct {
+	rules  []rule
+	window int
+	held   string
+	counts map[string]int
+}
+
+// New returns a Redactor for patterns and literal secrets, keyed by the name
+// reported in masks and counts. Secrets shorter than MinSecretLength are
+// ignored.
+func New(patterns []Pattern, secrets map[string]string, window int) *Redactor {
+	r := &Redactor{window: window, counts: make(map[string]int)}
+
+	names := make([]string, 0, len(secrets))
+	for name, secret := range secrets {
+		if len(secret) >= MinSecretLength {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		secret := secrets[name]
+		r.rules = append(r.rules, rule{name: name, secret: secret, prefix: secret, maxLen: len(secret)})
+	}
+
+	for _, p := range patterns {
+		prefix, _ := p.Regexp.LiteralPrefix()
+		r.rules = append(r.rules, rule{name: p.Name, re: p.Regexp, prefix: prefix, maxLen: patternMaxLen(p.Regexp)})
+	}
+	return r
+}
+
+// Write redacts data and returns the output that is safe to emit now, which
+// may be shorter than data when its tail is held back.
+func (r *Redactor) Write(data string) string {
+	buf := r.held + data
+	matches := r.find(buf)
+
+	cut := len(buf)
+	for _, m := range matches {
+		if m.open && m.start < cut {
+			cut = m.start
+		}
+	}
+	if p := r.pendingStart(buf, matches); p < cut {
+		cut = p
+	}
+	if len(buf)-cut > r.window {
+		// Holding more would delay output indefinitely; emit the excess,
+		// masking any match it ends inside of as a whole.
+		cut = len(buf) - r.window
+		for _, m := range matches {
+			if m.start < cut && cut < m.end {
+				cut = m.end
+			}
+		}
+	}
+
+	r.held = buf[cut:]
+	return r.mask(buf[:cut], matches)
+}
+
+// Flush redacts and returns any held-back output.
+func (r *Redactor) Flush() string {
+	buf := r.held
+	r.held = ""
+	return r.mask(buf, r.find(buf))
+}
+
+// Pending reports whether output is held back awaiting the next Write or a
+// Flush.
+func (r *Redactor) Pending() bool {
+	return r.held != ""
+}
+
+// Counts returns the number of redactions so far by secret name.
+func (r *Redactor) Counts() map[string]int {
+	counts := make(map[string]int, len(r.counts))
+	for name, n := range r.counts {
+		counts[name] = n
+	}
+	return counts
+}
+
+// Total returns the number of redactions so far.
+func (r *Redactor) Total() int {
+	total := 0
+	for _, n := range r.counts {
+		total += n
+	}
+	return total
+}
+
+// find returns the non-overlapping spans of buf to mask, in order.
+// Overlapping matches are merged and named after the earliest.
+func (r *Redactor) find(buf string) []match {
+	var all []match
+	for _, rl := range r.rules {
+		var spans [][]int
+		if rl.re != nil {
+			spans = rl.re.FindAllStringIndex(buf, -1)
+		} else {
+			for off := 0; ; {
+				i := strings.Index(buf[off:], rl.secret)
+				if i < 0 {
+					break
+				}
+				spans = append(spans, []int{off + i, off + i + len(rl.secret)})
+				off += i + len(rl.secret)
+			}
+		}
+		for _, sp := range spans {
+			if sp[0] == sp[1] {
+				continue
+			}
+			open := sp[1] == len(buf) && (rl.maxLen < 0 || sp[1]-sp[0] < rl.maxLen)
+			all = append(all, match{start: sp[0], end: sp[1], name: rl.name, open: open})
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].start != all[j].start {
+			return all[i].start < all[j].start
+		}
+		return all[i].end > all[j].end
+	})
+	var merged []match
+	for _, m := range all {
+		if n := len(merged); n > 0 && m.start < merged[n-1].end {
+			last := &merged[n-1]
+			if m.end >= last.end {
+				last.end = m.end
+				last.open = last.open || m.open
+			}
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+// pendingStart returns the earliest offset in the tail of buf where a
+// secret may have started without being complete yet, or len(buf).
+func (r *Redactor) pendingStart(buf string, matches []match) int {
+	pos := len(buf)
+	for _, rl := range r.rules {
+		if rl.prefix == "" {
+			continue
+		}
+		// A match starting before from would already be complete.
+		from := len(buf) - r.window
+		if rl.maxLen >= 0 && len(buf)-rl.maxLen+1 > from {
+			from = len(buf) - rl.maxLen + 1
+		}
+		if from < 0 {
+			from = 0
+		}
+		for i := from; i < pos; i++ {
+			rest := buf[i:]
+			if rest[0] != rl.prefix[0] || !(strings.HasPrefix(rest, rl.prefix) || strings.HasPrefix(rl.prefix, rest)) {
+				continue
+			}
+			if covered(matches, i) {
+				continue
+			}
+			pos = i
+			break
+		}
+	}
+	return pos
+}
+
+// covered reports whether offset i lies inside a complete match.
+func covered(matches []match, i int) bool {
+	for _, m := range matches {
+		if m.start <= i && i < m.end && !m.open {
+			return true
+		}
+	}
+	return false
+}
+
+// mask replaces the matches that lie within text and counts them.
+func (r *Redactor) mask(text string, matches []match) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		if m.end > len(text) {
+			break
+		}
+		b.WriteString(text[last:m.start])
+		b.WriteString("[REDACTED:" + m.name + "]")
+		r.counts[m.name]++
+		last = m.end
+	}
+	if last == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// patternMaxLen returns the longest match of re in bytes, or -1 if its
+// length is unbounded.
+func patternMaxLen(re *regexp.Regexp) int {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return -1
+	}
+	return maxLen(parsed.Simplify())
+}
+
+func maxLen(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText,
+		syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return 0
+	case syntax.OpLiteral:
+		n := 0
+		for _, c := range re.Rune {
+			n += runeLen(c, re.Flags&syntax.FoldCase != 0)
+		}
+		return n
+	case syntax.OpCharClass:
+		n := 0
+		for i := 1; i < len(re.Rune); i += 2 {
+			if l := runeLen(re.Rune[i], false); l > n {
+				n = l
+			}
+		}
+		return n
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return utf8.UTFMax
+	case syntax.OpCapture, syntax.OpQuest:
+		return maxLen(re.Sub[0])
+	case syntax.OpRepeat:
+		sub := maxLen(re.Sub[0])
+		if re.Max < 0 || sub < 0 {
+			return -1
+		}
+		return re.Max * sub
+	case syntax.OpConcat, syntax.OpAlternate:
+		n := 0
+		for _, sub := range re.Sub {
+			l := maxLen(sub)
+			if l < 0 {
+				return -1
+			}
+			if re.Op == syntax.OpConcat {
+				n += l
+			} else if l > n {
+				n = l
+			}
+		}
+		return n
+	}
+	return -1
+}
+
+// runeLen is the UTF-8 length of c, allowing for case-folded variants.
+func runeLen(c rune, fold bool) int {
+	if fold || utf8.RuneLen(c) < 0 {
+		return utf8.UTFMax
+	}
+	return utf8.RuneLen(c)
+}
@@ -1,10 +1,15 @@
 package server
 
 import (
+	"context"
 	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,8 +17,14 @@ import (
 
 	"github.com/servicenow/claude-terminal-mid-service/internal/config"
 	"github.com/servicenow/claude-terminal-mid-service/internal/session"
+	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+	"github.com/servicenow/claude-terminal-mid-service/internal/terminal"
 )
 
+// maxOutputWait caps long-poll requests so they finish well inside client and
+// proxy timeouts (the MID/ECC clients use 30s).
+const maxOutputWait = 25 * time.Second
+
 // Server represents the HTTP server
 type Server struct {
 	config         *config.Config
@@ -41,11 +52,21 @@ func (s *Server) RegisterRoutes() {
 	{
 		api.POST("/session/create", s.handleCreateSession)
 		api.POST("/session/:sessionId/command", s.handleSendCommand)
+		api.POST("/session/:sessionId/signal", s.handleSendSignal)
+		api.POST("/session/:sessionId/keys", s.handleSendKeys)
 		api.GET("/session/:sessionId/output", s.handleGetOutput)
+		api.GET("/session/:sessionId/stream", s.handleStream)
+		api.GET("/session/:sessionId/events", s.handleEvents)
 		api.GET("/session/:sessionId/status", s.handleGetStatus)
+		api.GET("/session/:sessionId/screen", s.handleGetScreen)
+		api.GET("/session/:sessionId/recording", s.handleGetRecording)
 		api.POST("/session/:sessionId/resize", s.handleResize)
+		api.POST("/session/:sessionId/resume", s.handleResumeSession)
 		api.DELETE("/session/:sessionId", s.handleTerminateSession)
 		api.GET("/sessions", s.handleListSessions)
+		api.GET("/sessions/history", s.handleSessionHistory)
+		api.GET("/session/:sessionId/transcript", s.handleGetTranscript)
+		api.GET("/sessions/search", s.handleSearchOutput)
 	}
 }
 
@@ -86,6 +107,7 @@ func (s *Server) handleHealth(c *gin.Context) {
 		"timestamp":     time.Now().Format(time.RFC3339),
 		"active_sessions": s.sessionManager.ActiveSessionCount(),
 		"memory_alloc_mb": memStats.Alloc / 1024 / 1024,
+		"output_persistence": s.sessionManager.OutputPersistenceStats(),
 	})
 }
 
@@ -94,6 +116,12 @@ type CreateSessionRequest struct {
 	UserID        string              `json:"userId" binding:"required"`
 	Credentials   session.Credentials `json:"credentials" binding:"required"`
 	WorkspaceType string              `json:"workspaceType"`
+	WorkspaceName string              `json:"workspaceName"`
+	Template      string              `json:"template"`
+	GitRepo       string              `json:"gitRepo"`
+	GitRef        string              `json:"gitRef"`
+	Profile       string              `json:"profile"`
+	Label         string              `json:"label"`
 }
 
 // handleCreateSession handles session creation requests
@@ -110,8 +138,32 @@ func (s *Server) handleCreateSession(c *gin.Context) {
 		return
 	}
 
-	sess, err := s.sessionManager.CreateSession(req.UserID, req.Credentials, req.WorkspaceType)
+	sess, err := s.sessionManager.CreateSessionWithOptions(req.UserID, req.Credentials, session.SessionOptions{
+		WorkspaceType: req.WorkspaceType,
+		WorkspaceName: req.WorkspaceName,
+		Template:      req.Template,
+		GitRepo:       req.GitRepo,
+		GitRef:        req.GitRef,
+		Profile:       req.Profile,
+		Label:         req.Label,
+	})
 	if err != nil {
+		if errors.Is(err, session.ErrWorkspaceLocked) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, session.ErrInitFailed) {
+			// The request was valid but the workspace or process could not
+			// be prepared (e.g. clone failure); report it as a session state.
+			log.WithError(err).Warn("Session failed to initialize")
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"sessionId":         sess.SessionID,
+				"status":            "failed",
+				"terminationReason": session.ReasonInitFailed,
+				"error":             err.Error(),
+			})
+			return
+		}
 		log.WithError(err).Error("Failed to create session")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -121,6 +173,9 @@ func (s *Server) handleCreateSession(c *gin.Context) {
 		"sessionId":     sess.SessionID,
 		"status":        sess.Status,
 		"workspacePath": sess.WorkspacePath,
+		"workspaceType": sess.WorkspaceType,
+		"profile":       sess.Profile,
+		"label":         sess.Label,
 	})
 }
 
@@ -161,12 +216,133 @@ func (s *Server) handleSendCommand(c *gin.Context) {
 	})
 }
 
-// handleGetOutput handles retrieving session output (H1: userId ownership check)
+// SendKeysRequest represents raw keyboard input for interactive TUIs.
+type SendKeysRequest struct {
+	Keys string `json:"keys" binding:"required"`
+}
+
+// handleSendKeys writes raw keystrokes, including allowlisted escape sequences,
+// to a session (H1: userId ownership check).
+func (s *Server) handleSendKeys(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	userID := c.GetHeader("X-User-ID")
+
+	var req SendKeysRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sess, err := s.getSessionWithAuth(sessionID, userID)
+	if err != nil {
+		if userID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "X-User-ID header is required"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if err := sess.SendKeys(req.Keys); err != nil {
+		if errors.Is(err, session.ErrDisallowedInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.WithError(err).Error("Failed to send keys")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// SendSignalRequest represents a signal request. Signal is a named key
+// ("interrupt", "eof", "escape", "suspend") or process signal ("SIGTERM").
+type SendSignalRequest struct {
+	Signal string `json:"signal" binding:"required"`
+}
+
+// handleSendSignal delivers an interrupt, key or signal to a session
+// (H1: userId ownership check).
+func (s *Server) handleSendSignal(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	userID := c.GetHeader("X-User-ID")
+
+	var req SendSignalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sess, err := s.getSessionWithAuth(sessionID, userID)
+	if err != nil {
+		if userID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "X-User-ID header is required"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if err := sess.SendSignal(req.Signal); err != nil {
+		if errors.Is(err, session.ErrUnknownSignal) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.WithError(err).Error("Failed to send signal")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// handleGetOutput handles retrieving session output (H1: userId ownership check).
+// With ?after=<seq> it returns only chunks newer than the cursor and never
+// clears the buffer; ?clear=true is kept for callers without a cursor. Adding
+// ?wait=<duration> long-polls until newer output arrives or the wait elapses.
+// ?format=text|html renders the chunks for clients that cannot interpret
+// escape sequences; the default is raw.
 func (s *Server) handleGetOutput(c *gin.Context) {
 	sessionID := c.Param("sessionId")
 	userID := c.GetHeader("X-User-ID")
 	clear := c.Query("clear") == "true"
 
+	after, hasCursor, err := parseCursor(c.Query("after"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format, err := terminal.ParseFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if hasCursor && clear {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "after and clear cannot be combined"})
+		return
+	}
+
+	var wait time.Duration
+	if raw := c.Query("wait"); raw != "" {
+		wait, err = time.ParseDuration(raw)
+		if err != nil || wait < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wait: must be a duration such as 20s"})
+			return
+		}
+		if !hasCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "wait requires an after cursor"})
+			return
+		}
+		if wait > maxOutputWait {
+			wait = maxOutputWait
+		}
+	}
+
 	sess, err := s.getSessionWithAuth(sessionID, userID)
 	if err != nil {
 		if userID == "" {
@@ -177,15 +353,63 @@ func (s *Server) handleGetOutput(c *gin.Context) {
 		return
 	}
 
+	if hasCursor {
+		var page session.OutputPage
+		if wait > 0 {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+			page = sess.WaitForOutput(ctx, after)
+			cancel()
+		} else {
+			page = sess.GetOutputAfter(after)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"sessionId":  sessionID,
+			"output":     formatOutput(page.Chunks, format),
+			"format":     format,
+			"nextCursor": page.NextCursor,
+			"gap":        page.Gap,
+			"status":     sess.GetStatus()["status"],
+		})
+		return
+	}
+
 	output := sess.GetOutput(clear)
 
 	c.JSON(http.StatusOK, gin.H{
 		"sessionId": sessionID,
-		"output":    output,
+		"output":    formatOutput(output, format),
+		"format":    format,
 		"status":    sess.Status,
 	})
 }
 
+// formatOutput renders chunks in format. One converter runs across the
+// chunks, so escape sequences split between them are still recognised.
+func formatOutput(chunks []session.OutputChunk, format terminal.Format) []session.OutputChunk {
+	if format == terminal.FormatRaw {
+		return chunks
+	}
+	conv := terminal.NewConverter(format)
+	out := make([]session.OutputChunk, len(chunks))
+	for i, chunk := range chunks {
+		chunk.Data = conv.Convert(chunk.Data)
+		out[i] = chunk
+	}
+	return out
+}
+
+// parseCursor parses an optional output cursor query value.
+func parseCursor(raw string) (uint64, bool, error) {
+	if raw == "" {
+		return 0, false, nil
+	}
+	after, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid cursor: must be a non-negative integer")
+	}
+	return after, true, nil
+}
+
 // handleGetStatus handles retrieving session status (H1: userId ownership check)
 func (s *Server) handleGetStatus(c *gin.Context) {
 	sessionID := c.Param("sessionId")
@@ -204,10 +428,72 @@ func (s *Server) handleGetStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, sess.GetStatus())
 }
 
+// handleGetScreen returns the session's rendered terminal screen: the visible
+// rows as plain text, with styled runs when attributes=true and up to
+// scrollback lines of history (H1: userId ownership check).
+func (s *Server) handleGetScreen(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	userID := c.GetHeader("X-User-ID")
+
+	opts := terminal.SnapshotOptions{Attributes: c.Query("attributes") == "true"}
+	if raw := c.Query("scrollback"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scrollback: must be a non-negative integer"})
+			return
+		}
+		opts.Scrollback = n
+	}
+
+	sess, err := s.getSessionWithAuth(sessionID, userID)
+	if err != nil {
+		if userID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "X-User-ID header is required"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessionId": sessionID,
+		"screen":    sess.Screen(opts),
+		"status":    sess.GetStatus()["status"],
+	})
+}
+
+// handleGetRecording downloads the session's asciicast v2 recording. Sessions
+// that have already ended are served from the store when one is configured
+// (H1: userId ownership check).
+func (s *Server) handleGetRecording(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	userID := c.GetHeader("X-User-ID")
+
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-User-ID header is required"})
+		return
+	}
+
+	recording, err := s.sessionManager.GetRecording(c.Request.Context(), sessionID, userID)
+	if err != nil {
+		if errors.Is(err, session.ErrRecordingNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "recording not found"})
+			return
+		}
+		log.WithError(err).WithField("session_id", sessionID).Error("Failed to load recording")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load recording"})
+		return
+	}
+	defer recording.Close()
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.cast"`, sessionID))
+	c.DataFromReader(http.StatusOK, -1, "application/x-asciicast", recording, nil)
+}
+
 // ResizeRequest represents a terminal resize request
 type ResizeRequest struct {
-	Cols int `json:"cols" binding:"required"`
-	Rows int `json:"rows" binding:"required"`
+	Cols int `json:"cols" binding:"required,min=1,max=1000"`
+	Rows int `json:"rows" binding:"required,min=1,max=1000"`
 }
 
 // handleResize handles terminal resize requests (H1: userId ownership check)
@@ -242,6 +528,52 @@ func (s *Server) handleResize(c *gin.Context) {
 	})
 }
 
+// handleResumeSession starts a new session continuing a terminated one in
+// its workspace (H1: userId ownership check).
+func (s *Server) handleResumeSession(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	userID := c.GetHeader("X-User-ID")
+
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-User-ID header is required"})
+		return
+	}
+
+	sess, err := s.sessionManager.ResumeSession(c.Request.Context(), sessionID, userID)
+	if err != nil {
+		if errors.Is(err, session.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		if errors.Is(err, session.ErrNotResumable) || errors.Is(err, session.ErrWorkspaceLocked) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, session.ErrInitFailed) {
+			log.WithError(err).WithField("session_id", sessionID).Warn("Resumed session failed to initialize")
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"sessionId":         sess.SessionID,
+				"status":            "failed",
+				"terminationReason": session.ReasonInitFailed,
+				"error":             err.Error(),
+			})
+			return
+		}
+		log.WithError(err).WithField("session_id", sessionID).Error("Failed to resume session")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessionId":     sess.SessionID,
+		"resumedFrom":   sess.ResumedFrom,
+		"status":        sess.Status,
+		"workspacePath": sess.WorkspacePath,
+		"workspaceType": sess.WorkspaceType,
+		"profile":       sess.Profile,
+	})
+}
+
 // handleTerminateSession handles session termination requests (H1: userId ownership check)
 func (s *Server) handleTerminateSession(c *gin.Context) {
 	sessionID := c.Param("sessionId")
@@ -278,6 +610,259 @@ func (s *Server) handleListSessions(c *gin.Context) {
 	})
 }
 
+// Session history page sizes.
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+// handleSessionHistory returns a page of the user's sessions from the store,
+// including ended ones (H10). Filters: status, label, and from/to (RFC 3339)
+// on the creation time.
+func (s *Server) handleSessionHistory(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-User-ID header is required"})
+		return
+	}
+
+	filter, err := parseSessionFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// One extra row tells whether another page follows.
+	limit := filter.Limit
+	filter.Limit++
+	records, err := s.sessionManager.SessionHistory(c.Request.Context(), userID, filter)
+	if err != nil {
+		if errors.Is(err, session.ErrHistoryUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		log.WithError(err).WithField("user_id", userID).Error("Failed to load session history")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load session history"})
+		return
+	}
+	hasMore := len(records) > limit
+	if hasMore {
+		records = records[:limit]
+	}
+
+	sessions := make([]gin.H, 0, len(records))
+	for _, rec := range records {
+		sessions = append(sessions, historyEntry(rec))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+		"limit":    limit,
+		"offset":   filter.Offset,
+		"hasMore":  hasMore,
+	})
+}
+
+// parseSessionFilter reads the history query parameters.
+func parseSessionFilter(c *gin.Context) (store.SessionFilter, error) {
+	filter := store.SessionFilter{
+		Status: c.Query("status"),
+		Label:  c.Query("label"),
+		Limit:  defaultHistoryLimit,
+	}
+	for param, dst := range map[string]*time.Time{"from": &filter.CreatedAfter, "to": &filter.CreatedBefore} {
+		if raw := c.Query(param); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: must be an RFC 3339 time", param)
+			}
+			*dst = t
+		}
+	}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxHistoryLimit {
+			return filter, fmt.Errorf("invalid limit: must be between 1 and %d", maxHistoryLimit)
+		}
+		filter.Limit = n
+	}
+	if raw := c.Query("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return filter, fmt.Errorf("invalid offset: must be a non-negative integer")
+		}
+		filter.Offset = n
+	}
+	return filter, nil
+}
+
+// historyEntry is the public view of a stored session: credentials and the
+// supervisor socket stay on the server.
+func historyEntry(rec store.SessionRecord) gin.H {
+	entry := gin.H{
+		"session_id":     rec.SessionID,
+		"user_id":        rec.UserID,
+		"workspace_path": rec.WorkspacePath,
+		"workspace_type": rec.WorkspaceType,
+		"profile":        rec.Profile,
+		"status":         rec.Status,
+		"last_activity":  rec.LastActivity.Format(time.RFC3339),
+		"created":        rec.CreatedAt.Format(time.RFC3339),
+	}
+	if rec.Label != "" {
+		entry["label"] = rec.Label
+	}
+	if rec.ResumedFrom != "" {
+		entry["resumed_from"] = rec.ResumedFrom
+	}
+	if rec.TerminationReason != "" {
+		entry["termination_reason"] = rec.TerminationReason
+	}
+	if rec.ExitCode != nil {
+		entry["exit_code"] = *rec.ExitCode
+	}
+	if rec.ExitSignal != "" {
+		entry["exit_signal"] = rec.ExitSignal
+	}
+	if rec.TerminatedAt != nil {
+		entry["terminated_at"] = rec.TerminatedAt.Format(time.RFC3339)
+	}
+	return entry
+}
+
+// handleGetTranscript streams a session's stored output as newline-delimited
+// JSON, one {"id", "timestamp", "data"} object per stored chunk. It serves live and
+// ended sessions alike (H1: userId ownership check).
+func (s *Server) handleGetTranscript(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	userID := c.GetHeader("X-User-ID")
+
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-User-ID header is required"})
+		return
+	}
+
+	started := false
+	enc := json.NewEncoder(c.Writer)
+	err := s.sessionManager.StreamTranscript(c.Request.Context(), sessionID, userID, func(chunk store.OutputChunk) error {
+		if !started {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(http.StatusOK)
+			started = true
+		}
+		if err := enc.Encode(gin.H{
+			"id":        chunk.ID,
+			"timestamp": chunk.Timestamp.Format(time.RFC3339Nano),
+			"data":      chunk.Data,
+		}); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+
+	switch {
+	case started:
+		// Headers are out; a failure can only cut the stream short.
+		if err != nil {
+			log.WithError(err).WithField("session_id", sessionID).Warn("Transcript stream ended early")
+		}
+	case errors.Is(err, session.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+	case errors.Is(err, session.ErrHistoryUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case err != nil:
+		log.WithError(err).WithField("session_id", sessionID).Error("Failed to load transcript")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load transcript"})
+	default:
+		// No output was stored.
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+	}
+}
+
+// Output search sizes: the number of matching chunks returned.
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
+// handleSearchOutput searches the stored output of the user's sessions, or
+// with all=true of every user's sessions for admins (H10). Matches are
+// grouped by session, best first; each carries the chunk's id and its index
+// in the transcript, and an HTML snippet with the matched words in <mark>.
+func (s *Server) handleSearchOutput(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-User-ID header is required"})
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	q := store.SearchQuery{Query: query, UserID: userID, Limit: defaultSearchLimit}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit: must be between 1 and %d", maxSearchLimit)})
+			return
+		}
+		q.Limit = n
+	}
+	if c.Query("all") == "true" {
+		if !s.config.Security.IsAdmin(userID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "searching all users requires an admin"})
+			return
+		}
+		q.UserID = ""
+	}
+
+	hits, err := s.sessionManager.SearchOutput(c.Request.Context(), q)
+	if err != nil {
+		if errors.Is(err, session.ErrHistoryUnavailable) || errors.Is(err, store.ErrSearchUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		log.WithError(err).WithField("user_id", userID).Error("Failed to search session output")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search session output"})
+		return
+	}
+
+	// Hits come best first, so each session sits where its best match does.
+	sessions := make([]gin.H, 0)
+	bySession := make(map[string]int)
+	for _, hit := range hits {
+		i, ok := bySession[hit.SessionID]
+		if !ok {
+			i = len(sessions)
+			bySession[hit.SessionID] = i
+			entry := gin.H{
+				"session_id": hit.SessionID,
+				"user_id":    hit.UserID,
+				"matches":    []gin.H{},
+			}
+			if hit.Label != "" {
+				entry["label"] = hit.Label
+			}
+			sessions = append(sessions, entry)
+		}
+		sessions[i]["matches"] = append(sessions[i]["matches"].([]gin.H), gin.H{
+			"chunk_id":    hit.ChunkID,
+			"chunk_index": hit.ChunkIndex,
+			"timestamp":   hit.Timestamp.Format(time.RFC3339Nano),
+			"snippet":     hit.Snippet,
+			"rank":        hit.Rank,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"query":    query,
+		"sessions": sessions,
+		"matches":  len(hits),
+	})
+}
+
 // getSessionWithAuth returns a session, always enforcing ownership via X-User-ID.
 func (s *Server) getSessionWithAuth(sessionID, userID string) (*session.Session, error) {
 	if userID == "" {
@@ -2,14 +2,19 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/servicenow/claude-terminal-mid-service/internal/config"
 	"github.com/servicenow/claude-terminal-mid-service/internal/session"
+	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+	"github.com/servicenow/claude-terminal-mid-service/internal/terminal"
 )
 
 func setupTestServer() (*Server, *gin.Engine) {
@@ -68,6 +73,9 @@ func TestHealthEndpoint(t *testing.T) {
 	if _, ok := result["memory_alloc_mb"]; !ok {
 		t.Error("Expected 'memory_alloc_mb' in health response")
 	}
+	if _, ok := result["output_persistence"].(map[string]interface{}); !ok {
+		t.Error("Expected 'output_persistence' in health response")
+	}
 }
 
 func TestCreateSessionMissingFields(t *testing.T) {
@@ -103,8 +111,8 @@ func TestCreateSessionValidRequest(t *testing.T) {
 	router.ServeHTTP(resp, req)
 
 	// May fail without Claude CLI, but should get proper error
-	if resp.Code != http.StatusOK && resp.Code != http.StatusInternalServerError {
-		t.Errorf("Expected status 200 or 500, got %d", resp.Code)
+	if resp.Code != http.StatusOK && resp.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 200 or 422, got %d", resp.Code)
 	}
 }
 
@@ -150,6 +158,346 @@ func TestSendCommandMissingBody(t *testing.T) {
 	}
 }
 
+func TestSendSignalEndpoint(t *testing.T) {
+	_, router := setupTestServer()
+
+	tests := []struct {
+		body   string
+		userID string
+		want   int
+	}{
+		{`{}`, "test-user", http.StatusBadRequest},
+		{`{"signal":"interrupt"}`, "", http.StatusBadRequest},
+		{`{"signal":"interrupt"}`, "test-user", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/api/session/some-id/signal", bytes.NewBufferString(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.userID != "" {
+			req.Header.Set("X-User-ID", tt.userID)
+		}
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != tt.want {
+			t.Errorf("Expected status %d for body %s (user %q), got %d", tt.want, tt.body, tt.userID, resp.Code)
+		}
+	}
+}
+
+func TestSendKeysEndpoint(t *testing.T) {
+	_, router := setupTestServer()
+
+	for body, want := range map[string]int{
+		`{}`:                  http.StatusBadRequest,
+		`{"keys":"\u001b[A"}`: http.StatusNotFound,
+	} {
+		req, _ := http.NewRequest("POST", "/api/session/some-id/keys", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", "test-user")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Errorf("Expected status %d for body %s, got %d", want, body, resp.Code)
+		}
+	}
+}
+
+func TestGetScreenEndpoint(t *testing.T) {
+	_, router := setupTestServer()
+
+	tests := []struct {
+		query  string
+		userID string
+		want   int
+	}{
+		{"", "", http.StatusBadRequest},
+		{"?scrollback=-1", "test-user", http.StatusBadRequest},
+		{"?scrollback=abc", "test-user", http.StatusBadRequest},
+		{"?attributes=true&scrollback=10", "test-user", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/api/session/some-id/screen"+tt.query, nil)
+		if tt.userID != "" {
+			req.Header.Set("X-User-ID", tt.userID)
+		}
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != tt.want {
+			t.Errorf("Expected status %d for query %q, got %d", tt.want, tt.query, resp.Code)
+		}
+	}
+}
+
+func TestGetRecordingEndpoint(t *testing.T) {
+	_, router := setupTestServer()
+
+	for userID, want := range map[string]int{"": http.StatusBadRequest, "test-user": http.StatusNotFound} {
+		req, _ := http.NewRequest("GET", "/api/session/some-id/recording", nil)
+		if userID != "" {
+			req.Header.Set("X-User-ID", userID)
+		}
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Errorf("Expected status %d for user %q, got %d", want, userID, resp.Code)
+		}
+	}
+}
+
+func TestResumeSessionEndpoint(t *testing.T) {
+	_, router := setupTestServer()
+
+	for userID, want := range map[string]int{"": http.StatusBadRequest, "test-user": http.StatusNotFound} {
+		req, _ := http.NewRequest("POST", "/api/session/some-id/resume", nil)
+		if userID != "" {
+			req.Header.Set("X-User-ID", userID)
+		}
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Errorf("Expected status %d for user %q, got %d", want, userID, resp.Code)
+		}
+	}
+}
+
+// setupHistoryServer returns a router whose session manager reads a store
+// seeded with two of alice's sessions and one of bob's.
+func setupHistoryServer(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	db := store.NewMemoryStore()
+	created := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i, rec := range []store.SessionRecord{
+		{SessionID: "old", UserID: "alice", Status: "terminated", Label: "ldap"},
+		{SessionID: "new", UserID: "alice", Status: "active"},
+		{SessionID: "bobs", UserID: "bob", Status: "terminated"},
+	} {
+		rec.CreatedAt = created.Add(time.Duration(i) * time.Hour)
+		rec.EncryptedCredentials = json.RawMessage(`{"anthropicApiKey":"secret"}`)
+		db.SaveSession(ctx, rec)
+	}
+	db.MarkSessionTerminated(ctx, "old", session.ReasonUserTerminated, nil, "")
+	db.SaveOutputChunks(ctx, "old", []store.OutputChunk{
+		{Timestamp: created, Data: "hello "},
+		{Timestamp: created, Data: "world\r\n"},
+	})
+	db.SaveOutputChunks(ctx, "bobs", []store.OutputChunk{
+		{Timestamp: created, Data: "bob's <world>\r\n"},
+	})
+
+	cfg := &config.Config{
+		Workspace: config.WorkspaceConfig{BasePath: t.TempDir()},
+		Security:  config.SecurityConfig{AdminUserIDs: []string{"admin"}},
+	}
+	router := gin.New()
+	New(cfg, session.NewManager(cfg, db), router).RegisterRoutes()
+	return router
+}
+
+func TestSessionHistoryEndpoint(t *testing.T) {
+	router := setupHistoryServer(t)
+
+	get := func(path, userID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if userID != "" {
+			req.Header.Set("X-User-ID", userID)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	ids := func(resp *httptest.ResponseRecorder) ([]string, bool) {
+		var body struct {
+			Sessions []map[string]interface{} `json:"sessions"`
+			HasMore  bool                     `json:"hasMore"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		var got []string
+		for _, s := range body.Sessions {
+			got = append(got, s["session_id"].(string))
+		}
+		return got, body.HasMore
+	}
+
+	if resp := get("/api/sessions/history", ""); resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without X-User-ID, got %d", resp.Code)
+	}
+	for _, query := range []string{"limit=0", "limit=x", "offset=-1", "from=yesterday"} {
+		if resp := get("/api/sessions/history?"+query, "alice"); resp.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, resp.Code)
+		}
+	}
+
+	resp := get("/api/sessions/history", "alice")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if got, more := ids(resp); strings.Join(got, ",") != "new,old" || more {
+		t.Errorf("history = %v (more %v), want new,old", got, more)
+	}
+	if strings.Contains(resp.Body.String(), "secret") {
+		t.Errorf("history exposes stored credentials: %s", resp.Body.String())
+	}
+
+	for query, want := range map[string]string{
+		"status=terminated":          "old",
+		"label=ldap":                 "old",
+		"from=2026-03-01T10:30:00Z":  "new",
+		"to=2026-03-01T10:30:00Z":    "old",
+		"limit=1&offset=1":           "old",
+		"status=terminated&label=xx": "",
+	} {
+		got, _ := ids(get("/api/sessions/history?"+query, "alice"))
+		if strings.Join(got, ",") != want {
+			t.Errorf("history?%s = %v, want %q", query, got, want)
+		}
+	}
+	if got, more := ids(get("/api/sessions/history?limit=1", "alice")); len(got) != 1 || !more {
+		t.Errorf("first page = %v (more %v), want one session and more", got, more)
+	}
+}
+
+func TestGetTranscriptEndpoint(t *testing.T) {
+	router := setupHistoryServer(t)
+
+	for userID, want := range map[string]int{"": http.StatusBadRequest, "bob": http.StatusNotFound, "alice": http.StatusOK} {
+		req, _ := http.NewRequest("GET", "/api/session/old/transcript", nil)
+		if userID != "" {
+			req.Header.Set("X-User-ID", userID)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Errorf("Expected status %d for user %q, got %d", want, userID, resp.Code)
+		}
+		if want != http.StatusOK {
+			continue
+		}
+		if ct := resp.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		var data strings.Builder
+		lines := strings.Split(strings.TrimSpace(resp.Body.String()), "\n")
+		for _, line := range lines {
+			var chunk struct{ Data string }
+			json.Unmarshal([]byte(line), &chunk)
+			data.WriteString(chunk.Data)
+		}
+		if len(lines) != 2 || data.String() != "hello world\r\n" {
+			t.Errorf("transcript = %q", resp.Body.String())
+		}
+	}
+
+	// Without a store there is no history to read.
+	_, noStore := setupTestServer()
+	req, _ := http.NewRequest("GET", "/api/session/old/transcript", nil)
+	req.Header.Set("X-User-ID", "alice")
+	resp := httptest.NewRecorder()
+	noStore.ServeHTTP(resp, req)
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a store, got %d", resp.Code)
+	}
+}
+
+func TestSearchOutputEndpoint(t *testing.T) {
+	router := setupHistoryServer(t)
+
+	search := func(query, userID string) (*httptest.ResponseRecorder, []map[string]interface{}) {
+		req, _ := http.NewRequest("GET", "/api/sessions/search?"+query, nil)
+		if userID != "" {
+			req.Header.Set("X-User-ID", userID)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var body struct {
+			Sessions []map[string]interface{} `json:"sessions"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		return resp, body.Sessions
+	}
+
+	if resp, _ := search("q=world", ""); resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without X-User-ID, got %d", resp.Code)
+	}
+	for query, want := range map[string]int{
+		"q=%20":             http.StatusBadRequest,
+		"q=world&limit=0":   http.StatusBadRequest,
+		"q=world&all=true":  http.StatusForbidden,
+		"q=world&limit=1":   http.StatusOK,
+		"q=world&all=false": http.StatusOK,
+	} {
+		if resp, _ := search(query, "alice"); resp.Code != want {
+			t.Errorf("search?%s = %d, want %d", query, resp.Code, want)
+		}
+	}
+
+	resp, sessions := search("q=world", "alice")
+	if resp.Code != http.StatusOK || len(sessions) != 1 || sessions[0]["session_id"] != "old" || sessions[0]["label"] != "ldap" {
+		t.Fatalf("alice's search = %d %s", resp.Code, resp.Body.String())
+	}
+	matches := sessions[0]["matches"].([]interface{})
+	match := matches[0].(map[string]interface{})
+	if len(matches) != 1 || match["chunk_index"] != float64(1) || match["snippet"] != "<mark>world</mark>" {
+		t.Errorf("matches = %v", matches)
+	}
+
+	// Admins may search everyone's output; snippets stay HTML-escaped.
+	resp, sessions = search("q=world&all=true", "admin")
+	if resp.Code != http.StatusOK || len(sessions) != 2 {
+		t.Fatalf("admin search = %d %s", resp.Code, resp.Body.String())
+	}
+	bobs := sessions[0]["matches"].([]interface{})[0].(map[string]interface{})
+	if sessions[0]["session_id"] != "bobs" || bobs["snippet"] != "bob&#39;s &lt;<mark>world</mark>&gt;" {
+		t.Errorf("bob's match = %v", bobs)
+	}
+	if _, sessions := search("q=world", "admin"); len(sessions) != 0 {
+		t.Errorf("admin search without all = %v, want only their own sessions", sessions)
+	}
+
+	// Encrypted output cannot be searched.
+	encrypted, err := store.NewEncryptedStore(store.NewMemoryStore(), strings.Repeat("0f", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Workspace: config.WorkspaceConfig{BasePath: t.TempDir()}}
+	router = gin.New()
+	New(cfg, session.NewManager(cfg, encrypted), router).RegisterRoutes()
+	if resp, _ := search("q=world", "alice"); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with encrypted output, got %d", resp.Code)
+	}
+}
+
+func TestResizeOutOfRange(t *testing.T) {
+	_, router := setupTestServer()
+
+	for _, body := range []string{`{"cols": 0, "rows": 24}`, `{"cols": 80, "rows": 100000}`} {
+		req, _ := http.NewRequest("POST", "/api/session/test-id/resize", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", "test-user")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for body %s, got %d", body, resp.Code)
+		}
+	}
+}
+
 func TestResizeMissingParameters(t *testing.T) {
 	_, router := setupTestServer()
 
@@ -165,6 +513,87 @@ func TestResizeMissingParameters(t *testing.T) {
 	}
 }
 
+func TestStreamRequiresUserID(t *testing.T) {
+	_, router := setupTestServer()
+
+	req, _ := http.NewRequest("GET", "/api/session/some-id/stream", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without X-User-ID, got %d", resp.Code)
+	}
+}
+
+func TestStreamNonExistentSession(t *testing.T) {
+	_, router := setupTestServer()
+
+	req, _ := http.NewRequest("GET", "/api/session/non-existent-id/stream", nil)
+	req.Header.Set("X-User-ID", "test-user")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", resp.Code)
+	}
+}
+
+func TestEventsNonExistentSession(t *testing.T) {
+	_, router := setupTestServer()
+
+	req, _ := http.NewRequest("GET", "/api/session/non-existent-id/events", nil)
+	req.Header.Set("X-User-ID", "test-user")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", resp.Code)
+	}
+}
+
+func TestGetOutputInvalidCursor(t *testing.T) {
+	_, router := setupTestServer()
+
+	for _, query := range []string{"after=abc", "after=-1", "after=5&clear=true", "after=5&wait=soon", "wait=5s", "format=ansi"} {
+		req, _ := http.NewRequest("GET", "/api/session/some-id/output?"+query, nil)
+		req.Header.Set("X-User-ID", "test-user")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, resp.Code)
+		}
+	}
+}
+
+func TestFormatOutput(t *testing.T) {
+	chunks := []session.OutputChunk{
+		{Seq: 1, Data: "\x1b[1;3"},
+		{Seq: 2, Data: "2mok\x1b[0m\r\n"},
+	}
+
+	text := formatOutput(chunks, terminal.FormatText)
+	if text[0].Data != "" || text[1].Data != "ok\n" || text[1].Seq != 2 {
+		t.Errorf("text output = %+v", text)
+	}
+	html := formatOutput(chunks, terminal.FormatHTML)
+	if html[1].Data != `<span style="color:#00cd00;font-weight:bold">ok</span>`+"\n" {
+		t.Errorf("html output = %+v", html)
+	}
+
+	// Raw leaves the chunks untouched.
+	if raw := formatOutput(chunks, terminal.FormatRaw); raw[0].Data != chunks[0].Data {
+		t.Errorf("raw output = %+v", raw)
+	}
+	if chunks[1].Data != "2mok\x1b[0m\r\n" {
+		t.Errorf("formatOutput modified its input: %+v", chunks)
+	}
+}
+
 // C1: Test auth middleware blocks unauthenticated requests when token is configured
 func TestAuthMiddlewareRejectsNoToken(t *testing.T) {
 	gin.SetMode(gin.TestMode)
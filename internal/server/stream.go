@@ -0,0 +1,350 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/session"
+)
+
+const (
+	// Time allowed to write a single frame to the client.
+	wsWriteWait = 10 * time.Second
+
+	// Time allowed to read the next pong from the client.
+	wsPongWait = 60 * time.Second
+
+	// Ping interval; must be shorter than wsPongWait.
+	wsPingPeriod = (wsPongWait * 9) / 10
+
+	// Maximum inbound frame size. Generous enough for a max-length command
+	// wrapped in JSON.
+	wsMaxMessageSize = 64 * 1024
+
+	// Interval between SSE heartbeat comments, kept well under typical proxy
+	// idle timeouts.
+	sseHeartbeatPeriod = 15 * time.Second
+
+	// Reconnect delay suggested to EventSource clients.
+	sseRetryMillis = 3000
+)
+
+// StreamClientMessage is a frame sent by the client over the stream WebSocket.
+// Type is "input" (Data is sent as a command), "keys" (Data is raw keyboard
+// input with allowlisted escape sequences) or "resize" (Cols/Rows).
+type StreamClientMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+}
+
+// StreamServerMessage is a frame sent by the server over the stream WebSocket.
+// Type is "output", "gap" (output after the requested cursor was evicted),
+// "error" or "status".
+type StreamServerMessage struct {
+	Type      string `json:"type"`
+	Seq       uint64 `json:"seq,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Status    string `json:"status,omitempty"`
+}
+
+// upgrader builds a WebSocket upgrader whose origin check mirrors the CORS
+// allowlist (C2). Requests without an Origin header (non-browser clients such
+// as the MID server) are allowed; auth is still enforced by authMiddleware.
+func (s *Server) upgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+			for _, allowed := range s.config.Security.CORSAllowedOrigins {
+				if origin == allowed {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// handleStream upgrades to a WebSocket that pushes PTY output as it arrives and
+// accepts input and resize frames (H1: userId ownership check).
+func (s *Server) handleStream(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	userID := c.GetHeader("X-User-ID")
+
+	sess, err := s.getSessionWithAuth(sessionID, userID)
+	if err != nil {
+		if userID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "X-User-ID header is required"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	after, _, err := parseCursor(c.Query("after"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conn, err := s.upgrader().Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error response.
+		log.WithError(err).WithField("session_id", sessionID).Warn("WebSocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	backlog, output, unsubscribe := sess.Subscribe(after)
+	defer unsubscribe()
+
+	log.WithFields(log.Fields{
+		"session_id": sessionID,
+		"user_id":    userID,
+	}).Info("Output stream connected")
+
+	// The reader goroutine reports per-frame errors back through errs so that
+	// only this goroutine ever writes to the connection.
+	errs := make(chan string, 16)
+	closed := make(chan struct{})
+	go s.readStream(conn, sess, errs, closed)
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	if after > 0 && backlog.Gap {
+		if err := writeStreamMessage(conn, StreamServerMessage{Type: "gap", Seq: backlog.NextCursor}); err != nil {
+			return
+		}
+	}
+	for _, chunk := range backlog.Chunks {
+		if err := writeStreamMessage(conn, outputMessage(chunk)); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case chunk, ok := <-output:
+			if !ok {
+				// Session ended or subscriber fell behind; tell the client why.
+				_ = writeStreamMessage(conn, StreamServerMessage{Type: "status", Status: sess.GetStatus()["status"].(string)})
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "stream ended"),
+					time.Now().Add(wsWriteWait))
+				return
+			}
+			if err := writeStreamMessage(conn, outputMessage(chunk)); err != nil {
+				return
+			}
+		case msg := <-errs:
+			if err := writeStreamMessage(conn, StreamServerMessage{Type: "error", Error: msg}); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-closed:
+			log.WithField("session_id", sessionID).Info("Output stream disconnected")
+			return
+		}
+	}
+}
+
+// readStream consumes client frames, applying the same validation as the
+// command and resize endpoints. It closes done when the connection ends.
+func (s *Server) readStream(conn *websocket.Conn, sess *session.Session, errs chan<- string, done chan<- struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var msg StreamClientMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.WithError(err).WithField("session_id", sess.SessionID).Warn("Output stream read error")
+			}
+			return
+		}
+
+		var err error
+		switch msg.Type {
+		case "input":
+			err = sess.SendCommand(msg.Data)
+		case "keys":
+			err = sess.SendKeys(msg.Data)
+		case "resize":
+			if msg.Cols <= 0 || msg.Rows <= 0 {
+				reportStreamError(errs, "cols and rows must be positive")
+				continue
+			}
+			err = sess.Resize(msg.Cols, msg.Rows)
+		default:
+			reportStreamError(errs, "unknown message type: "+msg.Type)
+			continue
+		}
+
+		if err != nil {
+			reportStreamError(errs, err.Error())
+		}
+	}
+}
+
+// reportStreamError queues an error frame, dropping it if the client is not
+// keeping up rather than stalling the reader.
+func reportStreamError(errs chan<- string, msg string) {
+	select {
+	case errs <- msg:
+	default:
+	}
+}
+
+func outputMessage(chunk session.OutputChunk) StreamServerMessage {
+	return StreamServerMessage{Type: "output", Seq: chunk.Seq, Timestamp: chunk.Timestamp, Data: chunk.Data}
+}
+
+func writeStreamMessage(conn *websocket.Conn, msg StreamServerMessage) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return conn.WriteJSON(msg)
+}
+
+// handleEvents serves a Server-Sent Events feed of PTY output for clients whose
+// proxies cannot carry WebSockets (H1: userId ownership check). Each output
+// event carries the chunk sequence number as its id, so reconnecting clients
+// resume after Last-Event-ID.
+func (s *Server) handleEvents(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	userID := c.GetHeader("X-User-ID")
+
+	sess, err := s.getSessionWithAuth(sessionID, userID)
+	if err != nil {
+		if userID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "X-User-ID header is required"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	// EventSource sends Last-Event-ID on reconnect; the query parameter lets
+	// clients that cannot set headers resume explicitly.
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	var after uint64
+	if lastEventID != "" {
+		after, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Last-Event-ID"})
+			return
+		}
+	}
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "streaming not supported"})
+		return
+	}
+
+	backlog, output, unsubscribe := sess.Subscribe(after)
+	defer unsubscribe()
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// Disable response buffering in nginx-style reverse proxies.
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetryMillis); err != nil {
+		return
+	}
+
+	// Tell a resuming client that some output it has not seen is gone.
+	if after > 0 && backlog.Gap {
+		if err := writeSSEEvent(w, "", "gap", gin.H{"after": after}); err != nil {
+			return
+		}
+	}
+	for _, chunk := range backlog.Chunks {
+		if err := writeSSEChunk(w, chunk); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	log.WithFields(log.Fields{
+		"session_id": sessionID,
+		"user_id":    userID,
+		"after":      after,
+	}).Info("Event stream connected")
+
+	heartbeat := time.NewTicker(sseHeartbeatPeriod)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case chunk, ok := <-output:
+			if !ok {
+				_ = writeSSEEvent(w, "", "status", gin.H{"status": sess.GetStatus()["status"]})
+				flusher.Flush()
+				return
+			}
+			if err := writeSSEChunk(w, chunk); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-c.Request.Context().Done():
+			log.WithField("session_id", sessionID).Info("Event stream disconnected")
+			return
+		}
+	}
+}
+
+func writeSSEChunk(w http.ResponseWriter, chunk session.OutputChunk) error {
+	return writeSSEEvent(w, strconv.FormatUint(chunk.Seq, 10), "output", chunk)
+}
+
+// writeSSEEvent writes one event. The payload is JSON-encoded so it always
+// fits on a single data line regardless of the newlines in terminal output.
+func writeSSEEvent(w http.ResponseWriter, id, event string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -202,15 +203,35 @@ func NewNodeServiceClient(cfg *config.Config) *NodeServiceClient {
 	}
 }
 
+// CreateSessionParams holds the fields forwarded to POST /api/session/create.
+type CreateSessionParams struct {
+	UserID        string
+	APIKey        string
+	GitHubToken   string
+	WorkspaceType string
+	WorkspaceName string
+	Template      string
+	GitRepo       string
+	GitRef        string
+	Profile       string
+	Label         string
+}
+
 // CreateSession creates a new terminal session
-func (c *NodeServiceClient) CreateSession(ctx context.Context, userID, apiKey, githubToken, workspaceType string) (interface{}, error) {
+func (c *NodeServiceClient) CreateSession(ctx context.Context, params CreateSessionParams) (interface{}, error) {
 	data := map[string]interface{}{
-		"userId": userID,
+		"userId": params.UserID,
 		"credentials": map[string]string{
-			"anthropicApiKey": apiKey,
-			"githubToken":     githubToken,
+			"anthropicApiKey": params.APIKey,
+			"githubToken":     params.GitHubToken,
 		},
-		"workspaceType": workspaceType,
+		"workspaceType": params.WorkspaceType,
+		"workspaceName": params.WorkspaceName,
+		"template":      params.Template,
+		"gitRepo":       params.GitRepo,
+		"gitRef":        params.GitRef,
+		"profile":       params.Profile,
+		"label":         params.Label,
 	}
 
 	return c.makeRequest(ctx, "POST", "/api/session/create", data)
@@ -225,9 +246,69 @@ func (c *NodeServiceClient) SendCommand(ctx context.Context, sessionID, command
 	return c.makeRequest(ctx, "POST", fmt.Sprintf("/api/session/%s/command", sessionID), data)
 }
 
+// SendSignal sends a named key (e.g. "interrupt") or signal (e.g. "SIGTERM")
+// to a session
+func (c *NodeServiceClient) SendSignal(ctx context.Context, sessionID, signal string) (interface{}, error) {
+	data := map[string]interface{}{
+		"signal": signal,
+	}
+
+	return c.makeRequest(ctx, "POST", fmt.Sprintf("/api/session/%s/signal", sessionID), data)
+}
+
+// OutputOptions controls how session output is retrieved.
+type OutputOptions struct {
+	// Clear empties the session buffer after reading (legacy, destructive).
+	Clear bool
+	// After, when set, returns only chunks newer than this cursor.
+	After *uint64
+	// Wait long-polls for output newer than After for up to this long.
+	Wait time.Duration
+	// Format is "raw" (default), "text" or "html".
+	Format string
+}
+
 // GetOutput gets session output
-func (c *NodeServiceClient) GetOutput(ctx context.Context, sessionID string, clear bool) (interface{}, error) {
-	endpoint := fmt.Sprintf("/api/session/%s/output?clear=%t", sessionID, clear)
+func (c *NodeServiceClient) GetOutput(ctx context.Context, sessionID string, opts OutputOptions) (interface{}, error) {
+	query := url.Values{}
+	if opts.After != nil {
+		query.Set("after", strconv.FormatUint(*opts.After, 10))
+		if opts.Wait > 0 {
+			query.Set("wait", opts.Wait.String())
+		}
+	} else {
+		query.Set("clear", strconv.FormatBool(opts.Clear))
+	}
+	if opts.Format != "" {
+		query.Set("format", opts.Format)
+	}
+
+	endpoint := fmt.Sprintf("/api/session/%s/output?%s", sessionID, query.Encode())
+	return c.makeRequest(ctx, "GET", endpoint, nil)
+}
+
+// ScreenOptions controls what a screen snapshot includes.
+type ScreenOptions struct {
+	// Attributes adds styled runs (colours, bold, ...) for each row.
+	Attributes bool
+	// Scrollback is the number of history lines to include above the screen.
+	Scrollback int
+}
+
+// GetScreen gets the session's rendered terminal screen
+func (c *NodeServiceClient) GetScreen(ctx context.Context, sessionID string, opts ScreenOptions) (interface{}, error) {
+	query := url.Values{}
+	if opts.Attributes {
+		query.Set("attributes", "true")
+	}
+	if opts.Scrollback > 0 {
+		query.Set("scrollback", strconv.Itoa(opts.Scrollback))
+	}
+
+	endpoint := fmt.Sprintf("/api/session/%s/screen", sessionID)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
 	return c.makeRequest(ctx, "GET", endpoint, nil)
 }
 
@@ -251,6 +332,11 @@ func (c *NodeServiceClient) ResizeTerminal(ctx context.Context, sessionID string
 	return c.makeRequest(ctx, "POST", fmt.Sprintf("/api/session/%s/resize", sessionID), data)
 }
 
+// ResumeSession starts a new session continuing a terminated one
+func (c *NodeServiceClient) ResumeSession(ctx context.Context, sessionID string) (interface{}, error) {
+	return c.makeRequest(ctx, "POST", fmt.Sprintf("/api/session/%s/resume", sessionID), nil)
+}
+
 func (c *NodeServiceClient) makeRequest(ctx context.Context, method, endpoint string, data interface{}) (interface{}, error) {
 	var body []byte
 	var err error
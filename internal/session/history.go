@@ -0,0 +1,94 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+)
+
+// ErrHistoryUnavailable is returned by the history reads when no session
+// store is configured.
+var ErrHistoryUnavailable = errors.New("session history requires a session store")
+
+// maxLabelLength is the longest session label accepted, in bytes.
+const maxLabelLength = 100
+
+// transcriptBatchSize is the number of output chunks StreamTranscript reads
+// from the store at a time.
+const transcriptBatchSize = 500
+
+// validateLabel accepts labels of printable text up to maxLabelLength bytes.
+func validateLabel(label string) error {
+	if len(label) > maxLabelLength {
+		return fmt.Errorf("label must be at most %d bytes", maxLabelLength)
+	}
+	if !utf8.ValidString(label) {
+		return fmt.Errorf("label must be valid UTF-8")
+	}
+	for _, r := range label {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("label must not contain control characters")
+		}
+	}
+	return nil
+}
+
+// SessionHistory returns the user's sessions in the store matching f, live
+// and ended, newest first (H10).
+func (m *Manager) SessionHistory(ctx context.Context, userID string, f store.SessionFilter) ([]store.SessionRecord, error) {
+	if m.store == nil {
+		return nil, ErrHistoryUnavailable
+	}
+	return m.store.GetSessionsForUser(ctx, userID, f)
+}
+
+// SearchOutput searches the stored output of q.UserID's sessions, or of every
+// user's when q.UserID is empty. Callers decide who may search all users.
+func (m *Manager) SearchOutput(ctx context.Context, q store.SearchQuery) ([]store.SearchHit, error) {
+	if m.store == nil {
+		return nil, ErrHistoryUnavailable
+	}
+	return m.store.SearchOutput(ctx, q)
+}
+
+// StreamTranscript calls fn with each output chunk stored for a session,
+// oldest first, reading the store in batches so long transcripts are never
+// held in memory. It stops at fn's first error and returns it. Sessions
+// owned by another user are reported as ErrSessionNotFound (H1).
+func (m *Manager) StreamTranscript(ctx context.Context, sessionID, userID string, fn func(store.OutputChunk) error) error {
+	if m.store == nil {
+		return ErrHistoryUnavailable
+	}
+
+	rec, err := m.store.GetSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	if rec.UserID != userID {
+		return ErrSessionNotFound
+	}
+
+	var after int64
+	for {
+		chunks, err := m.store.GetOutputChunksAfter(ctx, sessionID, after, transcriptBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, c := range chunks {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		if len(chunks) < transcriptBatchSize {
+			return nil
+		}
+		after = chunks[len(chunks)-1].ID
+	}
+}
@@ -0,0 +1,237 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// ErrDisallowedInput is returned by SendKeys when the input contains an escape
+// sequence or control character outside the allowlist.
+var ErrDisallowedInput = errors.New("disallowed input")
+
+// Keystroke rate limit for SendKeys. Interactive typing sends one request or
+// frame per key, so this is far looser than commandRateInterval.
+const (
+	keysRatePerSecond = 50
+	keysRateBurst     = 100
+)
+
+// Bracketed paste markers (DECSET 2004).
+const (
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// tildeKeys are the CSI <n> ~ parameters sent by editing and function keys:
+// Home/Insert/Delete/End/PgUp/PgDn (1-8) and F1-F12 (11-24).
+var tildeKeys = map[int]bool{
+	1: true, 2: true, 3: true, 4: true, 5: true, 6: true, 7: true, 8: true,
+	11: true, 12: true, 13: true, 14: true, 15: true,
+	17: true, 18: true, 19: true, 20: true, 21: true, 23: true, 24: true,
+}
+
+// validateKeys checks raw keyboard input against an allowlist. Plain text and
+// C0 controls (Ctrl-<key>, Tab, Enter, Backspace) pass through. ESC may only
+// start a cursor/function key sequence (CSI or SS3), a bracketed paste marker,
+// an Alt-modified key, or stand alone as the Esc key. String sequences (OSC,
+// DCS, APC, PM, SOS) and C1 controls are rejected: when the PTY echoes input
+// they would reach the viewer's terminal as clipboard writes or title changes.
+func validateKeys(input string) error {
+	inPaste := false
+
+	for i := 0; i < len(input); {
+		r, size := utf8.DecodeRuneInString(input[i:])
+		switch {
+		case r == utf8.RuneError && size <= 1:
+			return fmt.Errorf("%w: invalid UTF-8 at byte %d", ErrDisallowedInput, i)
+		case r >= 0x80 && r <= 0x9f:
+			return fmt.Errorf("%w: C1 control U+%04X at byte %d", ErrDisallowedInput, r, i)
+		case r != 0x1b:
+			i += size
+			continue
+		}
+
+		n, err := escapeLength(input[i:])
+		if err != nil {
+			return fmt.Errorf("%w: %v at byte %d", ErrDisallowedInput, err, i)
+		}
+		seq := input[i : i+n]
+
+		switch {
+		case seq == pasteStart:
+			if inPaste {
+				return fmt.Errorf("%w: nested bracketed paste at byte %d", ErrDisallowedInput, i)
+			}
+			inPaste = true
+		case seq == pasteEnd:
+			if !inPaste {
+				return fmt.Errorf("%w: unexpected end of bracketed paste at byte %d", ErrDisallowedInput, i)
+			}
+			inPaste = false
+		case inPaste:
+			// Pasted text is data; only the end marker may appear inside it.
+			return fmt.Errorf("%w: escape sequence inside bracketed paste at byte %d", ErrDisallowedInput, i)
+		}
+		i += n
+	}
+
+	if inPaste {
+		return fmt.Errorf("%w: unterminated bracketed paste", ErrDisallowedInput)
+	}
+	return nil
+}
+
+// escapeLength returns the length of the allowed escape sequence at the start
+// of s (which begins with ESC), or an error if it is not allowed.
+func escapeLength(s string) (int, error) {
+	if len(s) == 1 {
+		return 1, nil // lone Esc key
+	}
+
+	switch c := s[1]; {
+	case c == '[':
+		return csiLength(s)
+	case c == 'O':
+		// SS3: application-mode cursor keys, Home/End and F1-F4.
+		if len(s) > 2 && strings.IndexByte("ABCDHFPQRS", s[2]) >= 0 {
+			return 3, nil
+		}
+		if len(s) == 2 {
+			return 2, nil // Alt-O
+		}
+		return 0, fmt.Errorf("unsupported SS3 sequence")
+	case c == ']':
+		return 0, fmt.Errorf("OSC sequence not allowed")
+	case c == 'P' || c == '_' || c == '^' || c == 'X':
+		return 0, fmt.Errorf("string control sequence not allowed")
+	case c == 0x1b:
+		return 1, nil // Esc pressed twice; the next ESC is checked on its own
+	case c >= 0x20 && c < 0x7f:
+		return 2, nil // Alt-<key>
+	case c < 0x20 || c == 0x7f:
+		return 2, nil // Alt-Ctrl-<key>, Alt-Backspace
+	default:
+		// ESC before non-ASCII text: treat as a lone Esc.
+		return 1, nil
+	}
+}
+
+// csiLength validates a CSI sequence against the keys a terminal sends.
+func csiLength(s string) (int, error) {
+	// ESC [ parameters (0x30-0x3F)* intermediates (0x20-0x2F)* final (0x40-0x7E)
+	end := 2
+	for end < len(s) && s[end] >= 0x30 && s[end] <= 0x3f {
+		end++
+	}
+	params := s[2:end]
+	for end < len(s) && s[end] >= 0x20 && s[end] <= 0x2f {
+		end++
+	}
+	if end >= len(s) || s[end] < 0x40 || s[end] > 0x7e {
+		return 0, fmt.Errorf("incomplete CSI sequence")
+	}
+	if end != 2+len(params) {
+		return 0, fmt.Errorf("CSI intermediates not allowed")
+	}
+	final := s[end]
+	n := end + 1
+
+	switch final {
+	case 'A', 'B', 'C', 'D', 'H', 'F', 'P', 'Q', 'R', 'S':
+		// Cursor keys, Home/End, F1-F4, optionally with "1;<mod>".
+		if params == "" || validModifier(params, "1") {
+			return n, nil
+		}
+		// Cursor position report ("<row>;<col>R"), the terminal's reply to
+		// a TUI's position query.
+		if final == 'R' && validPositionReport(params) {
+			return n, nil
+		}
+	case 'Z', 'I', 'O':
+		// Shift-Tab, focus in, focus out.
+		if params == "" {
+			return n, nil
+		}
+	case '~':
+		if params == "200" || params == "201" {
+			return n, nil
+		}
+		key, mod, _ := strings.Cut(params, ";")
+		code, err := strconv.Atoi(key)
+		if err == nil && tildeKeys[code] && (mod == "" || validModifier(params, key)) {
+			return n, nil
+		}
+	}
+
+	return 0, fmt.Errorf("CSI sequence %q not allowed", s[:n])
+}
+
+// validModifier reports whether params is "<key>;<mod>" with mod in 1-16.
+func validModifier(params, key string) bool {
+	mod, ok := strings.CutPrefix(params, key+";")
+	if !ok {
+		return false
+	}
+	m, err := strconv.Atoi(mod)
+	return err == nil && m >= 1 && m <= 16
+}
+
+// validPositionReport reports whether params is "<row>;<col>".
+func validPositionReport(params string) bool {
+	row, col, ok := strings.Cut(params, ";")
+	if !ok {
+		return false
+	}
+	r, err1 := strconv.Atoi(row)
+	c, err2 := strconv.Atoi(col)
+	return err1 == nil && err2 == nil && r > 0 && c > 0
+}
+
+// SendKeys writes raw keyboard input to the PTY for interactive TUIs. Unlike
+// SendCommand it keeps allowlisted escape sequences (validateKeys) and is
+// limited by a keystroke-rate token bucket rather than commandRateInterval.
+func (s *Session) SendKeys(keys string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Status != "active" {
+		return fmt.Errorf("session is not active (status: %s)", s.Status)
+	}
+
+	// C3: Same length limit as commands
+	if len(keys) > maxCommandLength {
+		return fmt.Errorf("input too long (max %d bytes)", maxCommandLength)
+	}
+
+	if s.keysLimiter == nil {
+		s.keysLimiter = rate.NewLimiter(keysRatePerSecond, keysRateBurst)
+	}
+	if !s.keysLimiter.Allow() {
+		return fmt.Errorf("input rate limit exceeded, try again shortly")
+	}
+
+	if err := validateKeys(keys); err != nil {
+		return err
+	}
+
+	s.LastActivity = time.Now()
+
+	if _, err := s.PTY.Write([]byte(keys)); err != nil {
+		return fmt.Errorf("failed to write input: %w", err)
+	}
+	s.recorder.input(keys)
+
+	log.WithFields(log.Fields{
+		"session_id": s.SessionID,
+		"bytes":      len(keys),
+	}).Debug("Keys sent to session")
+
+	return nil
+}
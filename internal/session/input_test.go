@@ -0,0 +1,126 @@
+package session
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateKeysAllowed(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"plain text", "hello world"},
+		{"unicode", "héllo 世界 🚀"},
+		{"enter tab backspace", "ls\t\r\x7f\x08\n"},
+		{"ctrl keys", "\x01\x03\x04\x12\x1a"},
+		{"lone escape", "\x1b"},
+		{"double escape", "\x1b\x1b"},
+		{"cursor keys", "\x1b[A\x1b[B\x1b[C\x1b[D"},
+		{"application cursor keys", "\x1bOA\x1bOB\x1bOC\x1bOD"},
+		{"home end", "\x1b[H\x1b[F\x1bOH\x1bOF"},
+		{"modified cursor keys", "\x1b[1;5C\x1b[1;2A\x1b[1;16D"},
+		{"shift tab", "\x1b[Z"},
+		{"editing keys", "\x1b[2~\x1b[3~\x1b[5~\x1b[6~\x1b[1~\x1b[4~"},
+		{"modified delete", "\x1b[3;5~"},
+		{"function keys", "\x1bOP\x1bOS\x1b[15~\x1b[24~\x1b[1;2P"},
+		{"focus events", "\x1b[I\x1b[O"},
+		{"cursor position report", "\x1b[12;40R"},
+		{"alt keys", "\x1bb\x1bf\x1b."},
+		{"alt backspace", "\x1b\x7f"},
+		{"bracketed paste", "\x1b[200~line one\nline two\t\x1b[201~"},
+		{"paste then keys", "\x1b[200~x\x1b[201~\r\x1b[A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateKeys(tt.input); err != nil {
+				t.Errorf("validateKeys(%q) returned error: %v", tt.input, err)
+			}
+		})
+	}
+}
+
+func TestValidateKeysRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"OSC 52 clipboard write", "\x1b]52;c;ZXZpbA==\x07"},
+		{"OSC title", "\x1b]0;pwned\x1b\\"},
+		{"OSC 8 hyperlink", "\x1b]8;;http://evil\x1b\\"},
+		{"DCS", "\x1bP+q544e\x1b\\"},
+		{"APC", "\x1b_payload\x1b\\"},
+		{"PM", "\x1b^payload\x1b\\"},
+		{"SOS", "\x1bXpayload\x1b\\"},
+		{"C1 CSI", "\u009b31m"},
+		{"C1 OSC", "\u009d0;title\u0007"},
+		{"raw C1 byte", "\x9b"},
+		{"invalid UTF-8", "\xff\xfe"},
+		{"SGR", "\x1b[31m"},
+		{"erase display", "\x1b[2J"},
+		{"private mode set", "\x1b[?1049h"},
+		{"device attributes query", "\x1b[c"},
+		{"window manipulation", "\x1b[8;100;100t"},
+		{"unknown tilde key", "\x1b[99~"},
+		{"bad modifier", "\x1b[1;99C"},
+		{"CSI intermediates", "\x1b[1 q"},
+		{"incomplete CSI", "\x1b[1;5"},
+		{"unsupported SS3", "\x1bOx"},
+		{"unterminated paste", "\x1b[200~text"},
+		{"paste end without start", "text\x1b[201~"},
+		{"nested paste", "\x1b[200~\x1b[200~x\x1b[201~\x1b[201~"},
+		{"escape inside paste", "\x1b[200~\x1b]0;t\x07\x1b[201~"},
+		{"cursor key inside paste", "\x1b[200~\x1b[A\x1b[201~"},
+		{"OSC after allowed keys", "\x1b[Aok\x1b]52;c;eA==\x07"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateKeys(tt.input)
+			if !errors.Is(err, ErrDisallowedInput) {
+				t.Errorf("validateKeys(%q) = %v, want ErrDisallowedInput", tt.input, err)
+			}
+		})
+	}
+}
+
+func TestSendKeysValidation(t *testing.T) {
+	sess := &Session{SessionID: "test", Status: "active", done: make(chan struct{})}
+
+	// Rejected before anything is written, so no PTY is needed.
+	if err := sess.SendKeys("\x1b]52;c;ZXZpbA==\x07"); !errors.Is(err, ErrDisallowedInput) {
+		t.Errorf("Expected ErrDisallowedInput, got %v", err)
+	}
+
+	if err := sess.SendKeys(strings.Repeat("a", maxCommandLength+1)); err == nil {
+		t.Error("Expected error for oversized input")
+	}
+
+	sess.Status = "terminated"
+	if err := sess.SendKeys("a"); err == nil {
+		t.Error("Expected error sending keys to inactive session")
+	}
+}
+
+func TestSendKeysToAgent(t *testing.T) {
+	cfg := fakeAgentConfig(t)
+	// od prints the bytes it receives, so escape sequences are visible even
+	// though the PTY line discipline would otherwise swallow them.
+	cfg.Session.Agent.Args = []string{"-c", "stty raw -echo; echo ready; exec od -An -c"}
+	manager := NewManager(cfg, nil)
+
+	sess, err := manager.CreateSession("test-user", Credentials{AnthropicAPIKey: "test-key"}, "isolated")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	defer manager.TerminateSession(sess.SessionID)
+	waitForOutput(t, sess, "ready")
+
+	// 16 bytes fill one od line: Up, Esc, Ctrl-C, text.
+	if err := sess.SendKeys("\x1b[A\x1b\x03abcdefghijk"); err != nil {
+		t.Fatalf("SendKeys failed: %v", err)
+	}
+	waitForOutput(t, sess, `033   [   A 033 003   a   b`)
+}
@@ -0,0 +1,265 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+)
+
+// Output persistence defaults, used when the configuration leaves them unset.
+const (
+	defaultOutputBatchSize  = 64
+	defaultOutputFlushDelay = 250 * time.Millisecond
+	defaultOutputQueueBytes = 1 << 20
+)
+
+const (
+	outputWriteTimeout  = 3 * time.Second
+	outputWriteAttempts = 3               // a failed batch is retried, e.g. while the session record is still being saved
+	outputCloseTimeout  = 5 * time.Second // longest Cleanup waits for the final flush
+)
+
+// OutputPersistenceStats counts session output written to the store since
+// the process started.
+type OutputPersistenceStats struct {
+	Batches   int64 `json:"batches"`
+	Persisted int64 `json:"persisted_chunks"`
+	Dropped   int64 `json:"dropped_chunks"` // queue full: the store fell behind
+	Failed    int64 `json:"failed_chunks"`  // write failed after retries
+}
+
+// persistCounters accumulate a batchWriter's outcomes across sessions.
+type persistCounters struct {
+	batches, persisted, dropped, failed atomic.Int64
+}
+
+var outputStats persistCounters
+
+// OutputPersistenceStats returns the output persistence counters of all
+// sessions.
+func (m *Manager) OutputPersistenceStats() OutputPersistenceStats {
+	return OutputPersistenceStats{
+		Batches:   outputStats.batches.Load(),
+		Persisted: outputStats.persisted.Load(),
+		Dropped:   outputStats.dropped.Load(),
+		Failed:    outputStats.failed.Load(),
+	}
+}
+
+// batchWriter persists a session's output, or its recording events, in
+// batches from its own goroutine. The PTY reader only appends to a bounded
+// queue; when the store falls behind, new items are dropped and counted
+// rather than blocking the session.
+type batchWriter[T any] struct {
+	sessionID string
+	what      string // for logs, e.g. "output chunks"
+	save      func(ctx context.Context, batch []T) error
+	size      func(item T) int // bytes counted against maxQueued
+	stats     *persistCounters // nil when not counted globally
+	batchSize int
+	interval  time.Duration
+	maxQueued int // bytes
+
+	mu      sync.Mutex
+	queue   []T
+	queued  int // bytes in queue, including the batch being written
+	closed  bool
+	dropped int64
+	failed  int64
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// outputWriter batches a session's output chunks.
+type outputWriter = batchWriter[store.OutputChunk]
+
+// newOutputWriter starts a writer for a session's output.
+func newOutputWriter(dbStore store.Store, sessionID string, cfg config.DatabaseConfig) *outputWriter {
+	save := func(ctx context.Context, batch []store.OutputChunk) error {
+		return dbStore.SaveOutputChunks(ctx, sessionID, batch)
+	}
+	size := func(c store.OutputChunk) int { return len(c.Data) }
+	return newBatchWriter(sessionID, "output chunks", save, size, &outputStats, cfg)
+}
+
+// newBatchWriter starts a writer that saves batches of items with save,
+// sized by the output settings in cfg.
+func newBatchWriter[T any](sessionID, what string, save func(context.Context, []T) error, size func(T) int, stats *persistCounters, cfg config.DatabaseConfig) *batchWriter[T] {
+	w := &batchWriter[T]{
+		sessionID: sessionID,
+		what:      what,
+		save:      save,
+		size:      size,
+		stats:     stats,
+		batchSize: cfg.OutputBatchSize,
+		interval:  time.Duration(cfg.OutputFlushMillis) * time.Millisecond,
+		maxQueued: cfg.OutputQueueBytes,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	if w.batchSize <= 0 {
+		w.batchSize = defaultOutputBatchSize
+	}
+	if w.interval <= 0 {
+		w.interval = defaultOutputFlushDelay
+	}
+	if w.maxQueued <= 0 {
+		w.maxQueued = defaultOutputQueueBytes
+	}
+	go w.run()
+	return w
+}
+
+// add queues an item. It never blocks on the store.
+func (w *batchWriter[T]) add(item T) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	n := w.size(item)
+	if w.queued+n > w.maxQueued {
+		w.dropped++
+		if w.stats != nil {
+			w.stats.dropped.Add(1)
+		}
+		// Log the first drop and then every thousandth, not every item.
+		if w.dropped%1000 == 1 {
+			log.WithFields(log.Fields{
+				"session_id":   w.sessionID,
+				"queued_bytes": w.queued,
+				"dropped":      w.dropped,
+			}).Warnf("Persistence queue full; dropping %s", w.what)
+		}
+		return
+	}
+	w.queue = append(w.queue, item)
+	w.queued += n
+	if len(w.queue) >= w.batchSize {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// lostChunks returns how many of the session's items were not persisted.
+func (w *batchWriter[T]) lostChunks() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dropped + w.failed
+}
+
+// close writes what is still queued and stops the writer, waiting at most
+// outputCloseTimeout.
+func (w *batchWriter[T]) close() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case <-w.done:
+	case <-time.After(outputCloseTimeout):
+		log.WithField("session_id", w.sessionID).Warnf("Timed out flushing session %s to DB", w.what)
+	}
+}
+
+func (w *batchWriter[T]) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		fullOnly := false
+		select {
+		case <-ticker.C:
+		case <-w.wake:
+			fullOnly = true
+		}
+		if closed := w.flush(fullOnly); closed {
+			return
+		}
+	}
+}
+
+// flush writes the queue in batches and reports whether the writer has been
+// closed and drained. With fullOnly, a partial batch is left for the next
+// tick unless the writer is closing.
+func (w *batchWriter[T]) flush(fullOnly bool) bool {
+	for {
+		w.mu.Lock()
+		n := min(len(w.queue), w.batchSize)
+		batch := w.queue[:n:n]
+		closed := w.closed
+		w.mu.Unlock()
+		if n == 0 {
+			return closed
+		}
+		if fullOnly && !closed && n < w.batchSize {
+			return false
+		}
+
+		w.write(batch)
+
+		size := 0
+		for _, item := range batch {
+			size += w.size(item)
+		}
+		w.mu.Lock()
+		w.queue = w.queue[n:]
+		if len(w.queue) == 0 {
+			w.queue = nil
+		}
+		w.queued -= size
+		w.mu.Unlock()
+	}
+}
+
+// write saves a batch, retrying failures, and counts the outcome.
+func (w *batchWriter[T]) write(batch []T) {
+	var err error
+	for attempt := 1; attempt <= outputWriteAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(w.interval)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), outputWriteTimeout)
+		err = w.save(ctx, batch)
+		cancel()
+		if err == nil {
+			if w.stats != nil {
+				w.stats.batches.Add(1)
+				w.stats.persisted.Add(int64(len(batch)))
+			}
+			return
+		}
+	}
+
+	if w.stats != nil {
+		w.stats.failed.Add(int64(len(batch)))
+	}
+	w.mu.Lock()
+	w.failed += int64(len(batch))
+	w.mu.Unlock()
+	log.WithError(err).WithFields(log.Fields{
+		"session_id": w.sessionID,
+		"count":      len(batch),
+	}).Warnf("Failed to save %s to DB", w.what)
+}
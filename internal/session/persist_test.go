@@ -0,0 +1,155 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+)
+
+// batchStore records output batches and can hold or fail writes.
+type batchStore struct {
+	*store.MemoryStore
+	mu       sync.Mutex
+	batches  []int
+	failures int           // fail this many writes before succeeding
+	gate     chan struct{} // when set, writes wait for it to be closed
+}
+
+func (b *batchStore) SaveOutputChunks(ctx context.Context, sessionID string, chunks []store.OutputChunk) error {
+	b.mu.Lock()
+	gate := b.gate
+	b.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures > 0 {
+		b.failures--
+		return errors.New("database unavailable")
+	}
+	b.batches = append(b.batches, len(chunks))
+	return b.MemoryStore.SaveOutputChunks(ctx, sessionID, chunks)
+}
+
+func newBatchStore(t *testing.T, sessionID string) *batchStore {
+	b := &batchStore{MemoryStore: store.NewMemoryStore()}
+	if err := b.SaveSession(context.Background(), store.SessionRecord{SessionID: sessionID, UserID: "test-user", Status: "active"}); err != nil {
+		t.Fatalf("SaveSession failed: %v", err)
+	}
+	return b
+}
+
+func savedOutput(t *testing.T, db store.Store, sessionID string) string {
+	t.Helper()
+	chunks, err := db.GetOutputChunks(context.Background(), sessionID, 10000)
+	if err != nil {
+		t.Fatalf("GetOutputChunks failed: %v", err)
+	}
+	var all strings.Builder
+	for _, c := range chunks {
+		all.WriteString(c.Data)
+	}
+	return all.String()
+}
+
+func TestOutputWriterBatches(t *testing.T) {
+	db := newBatchStore(t, "s1")
+	w := newOutputWriter(db, "s1", config.DatabaseConfig{OutputBatchSize: 10, OutputFlushMillis: 60000})
+
+	// A partial batch waits for the interval (or close).
+	w.add(store.OutputChunk{Timestamp: time.Now(), Data: "first\n"})
+	time.Sleep(50 * time.Millisecond)
+	if got := savedOutput(t, db, "s1"); got != "" {
+		t.Fatalf("partial batch written early: %q", got)
+	}
+
+	// Full batches are written at once; the rest waits.
+	want := "first\n"
+	for i := 0; i < 24; i++ {
+		data := fmt.Sprintf("line %d\n", i)
+		want += data
+		w.add(store.OutputChunk{Timestamp: time.Now(), Data: data})
+	}
+	eventually(t, "full batches", func() bool {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return len(db.batches) == 2
+	})
+
+	// Closing writes the remainder; output after close is ignored.
+	w.close()
+	w.add(store.OutputChunk{Timestamp: time.Now(), Data: "late"})
+	if got := savedOutput(t, db, "s1"); got != want {
+		t.Errorf("saved output = %q, want %q", got, want)
+	}
+	if fmt.Sprint(db.batches) != "[10 10 5]" || w.lostChunks() != 0 {
+		t.Errorf("batches = %v, lost %d; want [10 10 5] and none lost", db.batches, w.lostChunks())
+	}
+}
+
+func TestOutputWriterFlushesOnInterval(t *testing.T) {
+	db := newBatchStore(t, "s1")
+	w := newOutputWriter(db, "s1", config.DatabaseConfig{OutputBatchSize: 100, OutputFlushMillis: 20})
+	defer w.close()
+
+	w.add(store.OutputChunk{Timestamp: time.Now(), Data: "hello"})
+	eventually(t, "interval flush", func() bool { return savedOutput(t, db, "s1") == "hello" })
+}
+
+func TestOutputWriterDropsWhenStoreIsSlow(t *testing.T) {
+	db := newBatchStore(t, "s1")
+	db.gate = make(chan struct{})
+	w := newOutputWriter(db, "s1", config.DatabaseConfig{OutputBatchSize: 1, OutputFlushMillis: 10, OutputQueueBytes: 10})
+
+	before := outputStats.dropped.Load()
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		w.add(store.OutputChunk{Timestamp: time.Now(), Data: "12345"})
+	}
+	if time.Since(start) > time.Second {
+		t.Error("add blocked on a slow store")
+	}
+	// The queue holds 10 bytes: two chunks, the rest are dropped.
+	if lost := w.lostChunks(); lost != 18 {
+		t.Errorf("lost = %d, want 18", lost)
+	}
+	if dropped := outputStats.dropped.Load() - before; dropped != 18 {
+		t.Errorf("dropped counter grew by %d, want 18", dropped)
+	}
+
+	close(db.gate)
+	w.close()
+	if got := savedOutput(t, db, "s1"); got != "1234512345" {
+		t.Errorf("saved output = %q", got)
+	}
+}
+
+func TestOutputWriterRetriesFailedBatch(t *testing.T) {
+	db := newBatchStore(t, "s1")
+	db.failures = outputWriteAttempts - 1
+	w := newOutputWriter(db, "s1", config.DatabaseConfig{OutputFlushMillis: 10})
+	w.add(store.OutputChunk{Timestamp: time.Now(), Data: "kept"})
+	w.close()
+	if got := savedOutput(t, db, "s1"); got != "kept" || w.lostChunks() != 0 {
+		t.Errorf("saved output = %q, lost %d; want the batch saved on retry", got, w.lostChunks())
+	}
+
+	// A batch that keeps failing is counted and given up on.
+	db.failures = outputWriteAttempts
+	before := outputStats.failed.Load()
+	w = newOutputWriter(db, "s1", config.DatabaseConfig{OutputFlushMillis: 10})
+	w.add(store.OutputChunk{Timestamp: time.Now(), Data: "lost"})
+	w.close()
+	if w.lostChunks() != 1 || outputStats.failed.Load()-before != 1 {
+		t.Errorf("lost = %d; want the failed chunk counted", w.lostChunks())
+	}
+}
@@ -0,0 +1,41 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ensurePrivateDir creates dir with mode 0700 if it is missing, then checks it
+// with checkPrivateDir. Defaults must not live under a world-writable
+// directory such as /tmp, where another user could create dir first.
+func ensurePrivateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return checkPrivateDir(dir)
+}
+
+// checkPrivateDir refuses dir unless it is a real directory, not a symlink,
+// owned by the service's uid with mode 0700. Files the service creates or
+// trusts in it could otherwise be planted or swapped by another user.
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("cannot determine the owner of %s", dir)
+	}
+	if int(st.Uid) != os.Geteuid() {
+		return fmt.Errorf("%s is owned by uid %d, not the service's uid %d", dir, st.Uid, os.Geteuid())
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		return fmt.Errorf("%s has mode %#o; it must be 0700", dir, perm)
+	}
+	return nil
+}
@@ -0,0 +1,545 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+	"github.com/servicenow/claude-terminal-mid-service/internal/crypto"
+	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+)
+
+// ErrRecordingNotFound is returned when a session has no recording the
+// caller may read.
+var ErrRecordingNotFound = errors.New("recording not found")
+
+// asciicast v2 event types.
+const (
+	eventOutput = "o"
+	eventInput  = "i"
+	eventResize = "r"
+)
+
+// castHeader is the first line of an asciicast v2 file.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+	// DataKey is the recording's data key wrapped by ENCRYPTION_KEY, set
+	// when event data in the file is encrypted. Only the file holds it:
+	// served recordings and the stored header leave it out.
+	DataKey string `json:"data_key,omitempty"`
+}
+
+// recordingBatchMax caps a recording write batch, keeping its multi-row
+// insert within the databases' bind parameter limits.
+const recordingBatchMax = 1000
+
+// recorder writes a session's terminal I/O as an asciicast v2 file. When a
+// store is configured every event is mirrored to it, so the recording
+// survives the session and the server. Methods must be called with the
+// session lock held.
+type recorder struct {
+	sessionID string
+	path      string
+	file      *os.File
+	size      int64 // bytes written; readers copy at most this much
+	start     time.Time
+	seq       int64
+	key       string                       // hex data key sealing event data in the file; empty when it is plaintext
+	pending   []byte                       // incomplete UTF-8 at the end of the last output chunk
+	persister *batchWriter[recordingWrite] // nil without a store
+}
+
+// recordingWrite is an item of a recording's write queue: its header, queued
+// first so it is stored before any event, or an event.
+type recordingWrite struct {
+	header *store.RecordingRecord
+	event  store.RecordingEvent
+}
+
+// newRecordingWriter starts a writer for a session's recording.
+func newRecordingWriter(dbStore store.Store, sessionID string, cfg config.DatabaseConfig) *batchWriter[recordingWrite] {
+	save := func(ctx context.Context, batch []recordingWrite) error {
+		events := make([]store.RecordingEvent, 0, len(batch))
+		for _, w := range batch {
+			if w.header == nil {
+				events = append(events, w.event)
+				continue
+			}
+			if err := dbStore.SaveRecording(ctx, *w.header); err != nil {
+				return err
+			}
+			// The file's key becomes the stored recording's, so both hold
+			// the events under one per-recording key.
+			if w.header.DataKey != "" {
+				if _, err := dbStore.SetRecordingKey(ctx, sessionID, w.header.DataKey); err != nil {
+					return err
+				}
+			}
+		}
+		return dbStore.SaveRecordingEvents(ctx, sessionID, events)
+	}
+	size := func(w recordingWrite) int {
+		if w.header != nil {
+			return len(w.header.Header)
+		}
+		return len(w.event.Data)
+	}
+	cfg.OutputBatchSize = min(cfg.OutputBatchSize, recordingBatchMax)
+	return newBatchWriter(sessionID, "recording events", save, size, nil, cfg)
+}
+
+// recordingOpenFlags keeps recordings from being opened through a symlink
+// planted in their place.
+const recordingOpenFlags = syscall.O_NOFOLLOW
+
+// newRecorder creates dir/<sessionID>.cast and writes its header. The
+// directory is created, or must already be, private to the service. With a
+// masterKey, event data in the file is encrypted under a new data key
+// wrapped by it.
+func newRecorder(dir, sessionID, userID string, cols, rows int, masterKey string, dbStore store.Store, dbCfg config.DatabaseConfig) (*recorder, error) {
+	if err := ensurePrivateDir(dir); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+
+	start := time.Now()
+	head := castHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: start.Unix(),
+		Env:       map[string]string{"TERM": os.Getenv("TERM")},
+	}
+	header, err := json.Marshal(head)
+	if err != nil {
+		return nil, err
+	}
+	var key string
+	if masterKey != "" {
+		if key, head.DataKey, err = newRecordingKey(masterKey); err != nil {
+			return nil, err
+		}
+	}
+	line, err := json.Marshal(head)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, sessionID+".cast")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND|recordingOpenFlags, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	r := &recorder{sessionID: sessionID, path: path, file: file, start: start, key: key}
+	if err := r.writeLine(line); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	if dbStore != nil {
+		r.persister = newRecordingWriter(dbStore, sessionID, dbCfg)
+		r.persister.add(recordingWrite{header: &store.RecordingRecord{
+			SessionID: sessionID,
+			UserID:    userID,
+			Header:    string(header),
+			StartedAt: start,
+			DataKey:   head.DataKey,
+		}})
+	}
+
+	return r, nil
+}
+
+// reopenRecorder continues dir/<sessionID>.cast for a session recovered
+// after a service restart. Elapsed times stay relative to the original start,
+// and an encrypted recording stays encrypted under its key, unwrapped with
+// masterKey.
+func reopenRecorder(dir, sessionID, masterKey string, dbStore store.Store, dbCfg config.DatabaseConfig) (*recorder, error) {
+	if err := checkPrivateDir(dir); err != nil {
+		return nil, fmt.Errorf("refusing recording directory: %w", err)
+	}
+	path := filepath.Join(dir, sessionID+".cast")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|recordingOpenFlags, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	var header castHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("invalid recording header: %w", err)
+	}
+	var key string
+	if header.DataKey != "" {
+		raw, err := crypto.Decrypt(header.DataKey, masterKey)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to unwrap recording key: %w", err)
+		}
+		key = hex.EncodeToString(raw)
+	}
+
+	r := &recorder{
+		sessionID: sessionID,
+		path:      path,
+		file:      file,
+		size:      int64(len(data)),
+		start:     time.Unix(header.Timestamp, 0),
+		key:       key,
+		seq:       int64(bytes.Count(data, []byte("\n"))) - 1, // every line after the header is an event
+	}
+	if dbStore != nil {
+		r.persister = newRecordingWriter(dbStore, sessionID, dbCfg)
+	}
+	return r, nil
+}
+
+func (r *recorder) writeLine(line []byte) error {
+	n, err := r.file.Write(append(line, '\n'))
+	r.size += int64(n)
+	return err
+}
+
+// output records PTY output. A UTF-8 character split across reads is held
+// back until it is complete, since events must be valid UTF-8.
+func (r *recorder) output(data string) {
+	if r == nil || r.file == nil {
+		return
+	}
+	buf := append(r.pending, data...)
+	r.pending = nil
+	if cut := incompleteSuffix(buf); cut > 0 {
+		r.pending = append([]byte(nil), buf[len(buf)-cut:]...)
+		buf = buf[:len(buf)-cut]
+	}
+	if len(buf) > 0 {
+		r.event(eventOutput, string(buf))
+	}
+}
+
+// input records data written to the PTY.
+func (r *recorder) input(data string) {
+	r.event(eventInput, data)
+}
+
+// resize records a terminal size change.
+func (r *recorder) resize(cols, rows int) {
+	r.event(eventResize, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (r *recorder) event(kind, data string) {
+	if r == nil || r.file == nil {
+		return
+	}
+
+	elapsed := math.Round(time.Since(r.start).Seconds()*1e6) / 1e6
+	fileData := data
+	if r.key != "" {
+		sealed, err := crypto.SealFrame(data, r.key)
+		if err != nil {
+			log.WithError(err).WithField("session_id", r.sessionID).Warn("Failed to encrypt recording event")
+			return
+		}
+		fileData = sealed
+	}
+	line, err := json.Marshal([]interface{}{elapsed, kind, fileData})
+	if err != nil {
+		return
+	}
+	if err := r.writeLine(line); err != nil {
+		log.WithError(err).WithField("session_id", r.sessionID).Warn("Failed to write recording event")
+	}
+
+	// Mirror to the DB in batches; never blocks the PTY reader.
+	r.seq++
+	r.persister.add(recordingWrite{event: store.RecordingEvent{Seq: r.seq, Elapsed: elapsed, Kind: kind, Data: data}})
+}
+
+// open returns a reader over the recording as written so far, decrypted
+// when the file is encrypted.
+func (r *recorder) open() (io.ReadCloser, error) {
+	f, err := os.OpenFile(r.path, os.O_RDONLY|recordingOpenFlags, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.key == "" {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(f, 0, r.size), f}, nil
+	}
+
+	defer f.Close()
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, r.size))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := decryptCast(data, r.key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(plain)), nil
+}
+
+// decryptCast returns an encrypted recording as it is served: the header
+// without its key, and every event's data decrypted.
+func decryptCast(data []byte, key string) ([]byte, error) {
+	var out bytes.Buffer
+	header, events, _ := bytes.Cut(data, []byte("\n"))
+	var head castHeader
+	if err := json.Unmarshal(header, &head); err != nil {
+		return nil, fmt.Errorf("invalid recording header: %w", err)
+	}
+	head.DataKey = ""
+	line, err := json.Marshal(head)
+	if err != nil {
+		return nil, err
+	}
+	out.Write(line)
+	out.WriteByte('\n')
+
+	for _, event := range bytes.Split(events, []byte("\n")) {
+		if len(event) == 0 {
+			continue
+		}
+		var fields [3]json.RawMessage
+		var text string
+		if err := json.Unmarshal(event, &fields); err != nil {
+			return nil, fmt.Errorf("invalid recording event: %w", err)
+		}
+		if err := json.Unmarshal(fields[2], &text); err != nil {
+			return nil, fmt.Errorf("invalid recording event: %w", err)
+		}
+		if fields[2], err = json.Marshal(crypto.OpenFrames(text, key)); err != nil {
+			return nil, err
+		}
+		if line, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), nil
+}
+
+// newRecordingKey returns a random hex data key, and the same key wrapped by
+// masterKey as EncryptedStore wraps its keys.
+func newRecordingKey(masterKey string) (key, wrapped string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("failed to generate recording key: %w", err)
+	}
+	if wrapped, err = crypto.Encrypt(raw, masterKey); err != nil {
+		return "", "", fmt.Errorf("failed to wrap recording key: %w", err)
+	}
+	return hex.EncodeToString(raw), wrapped, nil
+}
+
+// close flushes any held-back output, closes the file and writes what is
+// still queued for the DB.
+func (r *recorder) close() {
+	if r == nil || r.file == nil {
+		return
+	}
+	if len(r.pending) > 0 {
+		r.event(eventOutput, string(r.pending))
+		r.pending = nil
+	}
+	if err := r.file.Close(); err != nil {
+		log.WithError(err).WithField("session_id", r.sessionID).Warn("Failed to close recording")
+	}
+	r.file = nil
+	r.persister.close()
+}
+
+// discard closes and deletes a recording of a session that never started.
+func (r *recorder) discard() {
+	r.close()
+	os.Remove(r.path)
+}
+
+// incompleteSuffix returns the length of a truncated UTF-8 character at the
+// end of b, or 0.
+func incompleteSuffix(b []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if c < 0x80 {
+			return 0
+		}
+		if utf8.RuneStart(c) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// Recording returns the session's asciicast v2 recording so far.
+func (s *Session) Recording() (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.recorder == nil {
+		return nil, ErrRecordingNotFound
+	}
+	return s.recorder.open()
+}
+
+// GetRecording returns the recording of a session owned by userID: from the
+// live session when it is still in memory, otherwise from the store.
+func (m *Manager) GetRecording(ctx context.Context, sessionID, userID string) (io.ReadCloser, error) {
+	if sess, err := m.GetSessionForUser(sessionID, userID); err == nil {
+		return sess.Recording()
+	}
+
+	if m.store == nil {
+		return nil, ErrRecordingNotFound
+	}
+	rec, events, err := m.store.GetRecording(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrRecordingNotFound
+		}
+		return nil, err
+	}
+	if rec.UserID != userID {
+		return nil, ErrRecordingNotFound
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(rec.Header)
+	buf.WriteByte('\n')
+	for _, ev := range events {
+		line, err := json.Marshal([]interface{}{ev.Elapsed, ev.Kind, ev.Data})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return io.NopCloser(&buf), nil
+}
+
+// StartRecordingJanitor deletes expired recording files every
+// Retention.JanitorIntervalMinutes until ctx is cancelled. The store's
+// janitor does the same for stored recordings.
+func (m *Manager) StartRecordingJanitor(ctx context.Context) {
+	interval := time.Duration(m.config.Retention.JanitorIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := m.PurgeRecordingFiles(time.Now())
+		if err != nil {
+			log.WithError(err).Warn("Recording janitor failed")
+		} else if n > 0 {
+			log.WithField("purged_recordings", n).Info("Purged expired recording files")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeRecordingFiles deletes the <session-id>.cast files under
+// RECORDING_PATH that started more than Retention.RecordingDays before now,
+// except those of sessions still in memory, and returns how many there were.
+func (m *Manager) PurgeRecordingFiles(now time.Time) (int, error) {
+	if m.config.Retention.RecordingDays <= 0 {
+		return 0, nil
+	}
+	cutoff := now.AddDate(0, 0, -m.config.Retention.RecordingDays)
+
+	dir := m.config.Recording.Path
+	if err := checkPrivateDir(dir); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("refusing recording directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list recordings: %w", err)
+	}
+
+	m.mu.RLock()
+	live := make(map[string]bool, len(m.sessions))
+	for id := range m.sessions {
+		live[id] = true
+	}
+	m.mu.RUnlock()
+
+	n := 0
+	for _, e := range entries {
+		sessionID, ok := strings.CutSuffix(e.Name(), ".cast")
+		if !ok || !e.Type().IsRegular() || live[sessionID] {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		start, err := recordingStart(path)
+		if err != nil {
+			log.WithError(err).WithField("path", path).Warn("Skipping unreadable recording")
+			continue
+		}
+		if !start.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return n, fmt.Errorf("failed to delete recording: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// recordingStart reads the start time from the header of a .cast file.
+func recordingStart(path string) (time.Time, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|recordingOpenFlags, 0)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return time.Time{}, err
+	}
+	var header castHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return time.Time{}, fmt.Errorf("invalid recording header: %w", err)
+	}
+	return time.Unix(header.Timestamp, 0), nil
+}
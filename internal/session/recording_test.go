@@ -0,0 +1,419 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+)
+
+// readCast parses an asciicast v2 stream into its header and events.
+func readCast(t *testing.T, r io.Reader) (castHeader, [][3]interface{}) {
+	t.Helper()
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		t.Fatal("recording is empty")
+	}
+	var header castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatalf("invalid header %q: %v", scanner.Text(), err)
+	}
+
+	var events [][3]interface{}
+	for scanner.Scan() {
+		var ev [3]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	return header, events
+}
+
+// eventData concatenates the data of all events of kind.
+func eventData(events [][3]interface{}, kind string) string {
+	var b strings.Builder
+	for _, ev := range events {
+		if ev[1] == kind {
+			b.WriteString(ev[2].(string))
+		}
+	}
+	return b.String()
+}
+
+func TestSessionRecording(t *testing.T) {
+	cfg := fakeAgentConfig(t)
+	cfg.Recording.Enabled = true
+	cfg.Recording.Path = recordingDir(t)
+	manager := NewManager(cfg, nil)
+
+	sess, err := manager.CreateSession("test-user", Credentials{AnthropicAPIKey: "test-key"}, "isolated")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	waitForOutput(t, sess, "fake-agent-ready")
+
+	if err := sess.SendCommand("hello\n"); err != nil {
+		t.Fatalf("SendCommand failed: %v", err)
+	}
+	waitForOutput(t, sess, "hello")
+	if err := sess.Resize(100, 30); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+
+	rc, err := manager.GetRecording(context.Background(), sess.SessionID, "test-user")
+	if err != nil {
+		t.Fatalf("GetRecording failed: %v", err)
+	}
+	header, events := readCast(t, rc)
+	rc.Close()
+
+	if header.Version != 2 || header.Width != 80 || header.Height != 24 || header.Timestamp == 0 {
+		t.Errorf("header = %+v, want version 2, 80x24 and a timestamp", header)
+	}
+	if got := eventData(events, "i"); got != "hello\n" {
+		t.Errorf("input events = %q, want %q", got, "hello\n")
+	}
+	if got := eventData(events, "o"); !strings.Contains(got, "fake-agent-ready") || !strings.Contains(got, "hello") {
+		t.Errorf("output events = %q, want agent output", got)
+	}
+	if got := eventData(events, "r"); got != "100x30" {
+		t.Errorf("resize events = %q, want 100x30", got)
+	}
+	last := -1.0
+	for _, ev := range events {
+		if ev[0].(float64) < last {
+			t.Errorf("event times not ordered: %v", events)
+		}
+		last = ev[0].(float64)
+	}
+
+	// Other users cannot read it.
+	if _, err := manager.GetRecording(context.Background(), sess.SessionID, "other-user"); !errors.Is(err, ErrRecordingNotFound) {
+		t.Errorf("Expected ErrRecordingNotFound for another user, got %v", err)
+	}
+
+	// The file outlives the session and the isolated workspace.
+	if err := manager.TerminateSession(sess.SessionID); err != nil {
+		t.Fatalf("TerminateSession failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(cfg.Recording.Path, sess.SessionID+".cast"))
+	if err != nil {
+		t.Fatalf("Expected recording file after termination: %v", err)
+	}
+	if _, events := readCast(t, strings.NewReader(string(data))); eventData(events, "i") != "hello\n" {
+		t.Errorf("recording file events = %v", events)
+	}
+
+	// Without a store, ended sessions are no longer served.
+	if _, err := manager.GetRecording(context.Background(), sess.SessionID, "test-user"); !errors.Is(err, ErrRecordingNotFound) {
+		t.Errorf("Expected ErrRecordingNotFound after termination without a store, got %v", err)
+	}
+}
+
+func TestRecordingFromStore(t *testing.T) {
+	cfg := fakeAgentConfig(t)
+	cfg.Recording.Enabled = true
+	cfg.Recording.Path = recordingDir(t)
+	db := store.NewMemoryStore()
+	manager := NewManager(cfg, db)
+
+	sess, err := manager.CreateSession("test-user", Credentials{AnthropicAPIKey: "test-key"}, "isolated")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	waitForOutput(t, sess, "fake-agent-ready")
+	if err := manager.TerminateSession(sess.SessionID); err != nil {
+		t.Fatalf("TerminateSession failed: %v", err)
+	}
+
+	// Ended sessions are rebuilt from the store's events.
+	eventually(t, "stored recording", func() bool {
+		rc, err := manager.GetRecording(context.Background(), sess.SessionID, "test-user")
+		if err != nil {
+			return false
+		}
+		defer rc.Close()
+		_, events := readCast(t, rc)
+		return strings.Contains(eventData(events, "o"), "fake-agent-ready")
+	})
+	if _, err := manager.GetRecording(context.Background(), sess.SessionID, "other-user"); !errors.Is(err, ErrRecordingNotFound) {
+		t.Errorf("Expected ErrRecordingNotFound for another user, got %v", err)
+	}
+}
+
+func TestEncryptedRecording(t *testing.T) {
+	ctx := context.Background()
+	cfg := fakeAgentConfig(t)
+	cfg.Recording.Enabled = true
+	cfg.Recording.Path = recordingDir(t)
+	cfg.Database.EncryptOutput = true
+	cfg.Security.EncryptionKey = strings.Repeat("ab", 32)
+	inner := store.NewMemoryStore()
+	db, err := store.NewEncryptedStore(inner, cfg.Security.EncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := NewManager(cfg, db)
+
+	sess, err := manager.CreateSession("test-user", Credentials{AnthropicAPIKey: "test-key"}, "isolated")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	waitForOutput(t, sess, "fake-agent-ready")
+	if err := sess.SendCommand("hunter2\n"); err != nil {
+		t.Fatalf("SendCommand failed: %v", err)
+	}
+	waitForOutput(t, sess, "hunter2")
+
+	// The file only holds ciphertext and the wrapped key...
+	path := filepath.Join(cfg.Recording.Path, sess.SessionID+".cast")
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "hunter2") || strings.Contains(string(raw), "fake-agent-ready") {
+		t.Errorf("recording file holds plaintext: %q", raw)
+	}
+	var fileHeader castHeader
+	line, _, _ := strings.Cut(string(raw), "\n")
+	if err := json.Unmarshal([]byte(line), &fileHeader); err != nil || fileHeader.DataKey == "" {
+		t.Fatalf("file header = %q, want a wrapped data key", line)
+	}
+
+	// ...while the live recording is served decrypted, without the key.
+	rc, err := sess.Recording()
+	if err != nil {
+		t.Fatalf("Recording failed: %v", err)
+	}
+	header, events := readCast(t, rc)
+	rc.Close()
+	if header.DataKey != "" || !strings.Contains(eventData(events, "o"), "hunter2") || eventData(events, "i") != "hunter2\n" {
+		t.Errorf("served recording = %+v, %v", header, events)
+	}
+
+	if err := manager.TerminateSession(sess.SessionID); err != nil {
+		t.Fatalf("TerminateSession failed: %v", err)
+	}
+	// The stored copy is encrypted under the same per-recording key.
+	rec, _, err := inner.GetRecording(ctx, sess.SessionID)
+	if err != nil || rec.DataKey != fileHeader.DataKey || strings.Contains(rec.Header, "data_key") {
+		t.Errorf("stored recording = %+v, %v; want the file's key", rec, err)
+	}
+
+	// A recovered session keeps encrypting under the recording's key.
+	r, err := reopenRecorder(cfg.Recording.Path, sess.SessionID, cfg.Security.EncryptionKey, nil, config.DatabaseConfig{})
+	if err != nil {
+		t.Fatalf("reopenRecorder failed: %v", err)
+	}
+	r.output("after-restart")
+	rc, err = r.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, events = readCast(t, rc)
+	rc.Close()
+	r.close()
+	if out := eventData(events, "o"); !strings.Contains(out, "hunter2") || !strings.HasSuffix(out, "after-restart") {
+		t.Errorf("reopened recording output = %q", out)
+	}
+	if raw, _ := os.ReadFile(path); strings.Contains(string(raw), "after-restart") {
+		t.Error("reopened recording appended plaintext")
+	}
+}
+
+func TestRecordingDisabled(t *testing.T) {
+	manager := NewManager(fakeAgentConfig(t), nil)
+
+	sess, err := manager.CreateSession("test-user", Credentials{AnthropicAPIKey: "test-key"}, "isolated")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	defer manager.TerminateSession(sess.SessionID)
+
+	if _, err := sess.Recording(); !errors.Is(err, ErrRecordingNotFound) {
+		t.Errorf("Expected ErrRecordingNotFound with recording disabled, got %v", err)
+	}
+}
+
+func TestRecordingFailureFailsSession(t *testing.T) {
+	cfg := fakeAgentConfig(t)
+	cfg.Recording.Enabled = true
+	// A file where the directory should be.
+	cfg.Recording.Path = filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(cfg.Recording.Path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	manager := NewManager(cfg, nil)
+
+	if _, err := manager.CreateSession("test-user", Credentials{AnthropicAPIKey: "test-key"}, "isolated"); !errors.Is(err, ErrInitFailed) {
+		t.Errorf("Expected ErrInitFailed when the recording cannot be created, got %v", err)
+	}
+}
+
+// recordingDir returns a RECORDING_PATH for a test. It does not exist yet,
+// so the recorder creates it private to the service.
+func recordingDir(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "recordings")
+}
+
+func TestRecorderRefusesUnsafePaths(t *testing.T) {
+	open := t.TempDir()
+	if err := os.Chmod(open, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newRecorder(open, "open", "test-user", 80, 24, "", nil, config.DatabaseConfig{}); err == nil {
+		t.Error("Expected a directory with mode 0755 to be refused")
+	}
+
+	if os.Geteuid() == 0 {
+		foreign := recordingDir(t)
+		if err := os.Mkdir(foreign, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(foreign, 65534, 65534); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newRecorder(foreign, "foreign", "test-user", 80, 24, "", nil, config.DatabaseConfig{}); err == nil {
+			t.Error("Expected a directory owned by another user to be refused")
+		}
+	}
+
+	// A recording swapped for a symlink is not followed on reopen.
+	dir := recordingDir(t)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), "target")
+	if err := os.WriteFile(target, []byte(`{"version":2}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(dir, "linked.cast")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopenRecorder(dir, "linked", "", nil, config.DatabaseConfig{}); err == nil {
+		t.Error("Expected reopening a symlinked recording to fail")
+	}
+}
+
+// recordingCalls logs the recording writes reaching the store.
+type recordingCalls struct {
+	*store.MemoryStore
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recordingCalls) SaveRecording(ctx context.Context, rec store.RecordingRecord) error {
+	r.mu.Lock()
+	r.calls = append(r.calls, "header")
+	r.mu.Unlock()
+	return r.MemoryStore.SaveRecording(ctx, rec)
+}
+
+func (r *recordingCalls) SaveRecordingEvents(ctx context.Context, sessionID string, events []store.RecordingEvent) error {
+	r.mu.Lock()
+	r.calls = append(r.calls, fmt.Sprintf("%d events", len(events)))
+	r.mu.Unlock()
+	return r.MemoryStore.SaveRecordingEvents(ctx, sessionID, events)
+}
+
+func TestPurgeRecordingFiles(t *testing.T) {
+	cfg := fakeAgentConfig(t)
+	cfg.Recording.Enabled = true
+	cfg.Recording.Path = recordingDir(t)
+	cfg.Retention.RecordingDays = 90
+	manager := NewManager(cfg, nil)
+
+	sess, err := manager.CreateSession("test-user", Credentials{AnthropicAPIKey: "test-key"}, "isolated")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	defer manager.TerminateSession(sess.SessionID)
+	rec, err := newRecorder(cfg.Recording.Path, "ended", "test-user", 80, 24, "", nil, config.DatabaseConfig{})
+	if err != nil {
+		t.Fatalf("newRecorder failed: %v", err)
+	}
+	rec.close()
+	other := filepath.Join(cfg.Recording.Path, "notes.txt")
+	os.WriteFile(other, nil, 0600)
+
+	if n, err := manager.PurgeRecordingFiles(time.Now().AddDate(0, 0, 89)); err != nil || n != 0 {
+		t.Errorf("PurgeRecordingFiles within retention = %d, %v; want 0", n, err)
+	}
+	// The live session's recording and files that are not recordings stay.
+	if n, err := manager.PurgeRecordingFiles(time.Now().AddDate(0, 0, 91)); err != nil || n != 1 {
+		t.Errorf("PurgeRecordingFiles = %d, %v; want 1", n, err)
+	}
+	for path, want := range map[string]bool{
+		filepath.Join(cfg.Recording.Path, "ended.cast"):           false,
+		filepath.Join(cfg.Recording.Path, sess.SessionID+".cast"): true,
+		other: true,
+	} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", path, err == nil, want)
+		}
+	}
+}
+
+func TestRecorderBatchesEvents(t *testing.T) {
+	db := &recordingCalls{MemoryStore: store.NewMemoryStore()}
+	rec, err := newRecorder(recordingDir(t), "batched", "test-user", 80, 24, "", db, config.DatabaseConfig{OutputBatchSize: 10, OutputFlushMillis: 60000})
+	if err != nil {
+		t.Fatalf("newRecorder failed: %v", err)
+	}
+	for i := 0; i < 25; i++ {
+		rec.output(fmt.Sprintf("line %d\n", i))
+	}
+	rec.close()
+
+	// The header goes first, in the same queue as the events, which are
+	// written in multi-row batches rather than one insert each.
+	if got := strings.Join(db.calls, ", "); got != "header, 9 events, 10 events, 6 events" {
+		t.Errorf("store calls = %s", got)
+	}
+	_, events, err := db.GetRecording(context.Background(), "batched")
+	if err != nil || len(events) != 25 || events[24].Data != "line 24\n" {
+		t.Errorf("stored recording = %v, %v", events, err)
+	}
+}
+
+func TestRecorderSplitUTF8(t *testing.T) {
+	rec, err := newRecorder(recordingDir(t), "split", "test-user", 80, 24, "", nil, config.DatabaseConfig{})
+	if err != nil {
+		t.Fatalf("newRecorder failed: %v", err)
+	}
+
+	// "世" is e4 b8 96; the PTY read boundary falls inside it.
+	rec.output("a\xe4\xb8")
+	rec.output("\x96b")
+	rec.output("c\xe4")
+	rec.close()
+
+	rc, err := rec.open()
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer rc.Close()
+	_, events := readCast(t, rc)
+
+	var outputs []string
+	for _, ev := range events {
+		outputs = append(outputs, ev[2].(string))
+	}
+	// The dangling byte at close is flushed (as U+FFFD in JSON).
+	want := []string{"a", "世b", "c", "�"}
+	if strings.Join(outputs, "|") != strings.Join(want, "|") {
+		t.Errorf("output events = %q, want %q", outputs, want)
+	}
+}
@@ -0,0 +1,251 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/creack/pty"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/crypto"
+	"github.com/servicenow/claude-terminal-mid-service/internal/redact"
+	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+)
+
+// RecoverSessions takes over the sessions a previous run left active: those
+// whose agents still run under their supervisors are reattached, and the rest
+// are marked terminated. The most recently active sessions are recovered
+// first, so those over a lowered per-user limit are the least recently used.
+// This should be called once during initialization.
+func (m *Manager) RecoverSessions(ctx context.Context) {
+	if m.store == nil {
+		return
+	}
+
+	records, err := m.store.GetActiveSessions(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to load active sessions; marking them terminated")
+		m.markStaleSessionsTerminated(ctx)
+		return
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].LastActivity.After(records[j].LastActivity)
+	})
+
+	var recovered, ended int
+	for _, rec := range records {
+		if m.recoverSession(ctx, rec) {
+			recovered++
+		} else {
+			ended++
+		}
+	}
+
+	if len(records) > 0 {
+		log.WithFields(log.Fields{
+			"recovered":  recovered,
+			"terminated": ended,
+		}).Info("Recovered sessions from previous run")
+	} else {
+		log.Info("No stale sessions found on startup")
+	}
+}
+
+// markStaleSessionsTerminated marks every active session terminated.
+func (m *Manager) markStaleSessionsTerminated(ctx context.Context) {
+	count, err := m.store.MarkStaleSessionsTerminated(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to mark stale sessions as terminated")
+		return
+	}
+	if count > 0 {
+		log.WithField("count", count).Info("Marked stale sessions as terminated on startup")
+	}
+}
+
+// recoverSession reattaches to a session left active by a previous run and
+// reports whether it is live again. Otherwise it is marked terminated: with
+// the agent's exit status when its supervisor saw the agent end, or as a
+// server shutdown when the agent went with the previous run.
+func (m *Manager) recoverSession(ctx context.Context, rec store.SessionRecord) bool {
+	fields := log.Fields{"session_id": rec.SessionID, "user_id": rec.UserID}
+
+	if rec.SupervisorSocket == "" {
+		m.markRecoveryFailed(ctx, rec.SessionID)
+		return false
+	}
+	sc, err := attachSupervisor(rec.SupervisorSocket)
+	if err != nil {
+		log.WithError(err).WithFields(fields).Info("Session supervisor is gone; marking session terminated")
+		m.markRecoveryFailed(ctx, rec.SessionID)
+		return false
+	}
+
+	s := m.newSession(rec.SessionID, rec.UserID, rec.WorkspacePath, rec.WorkspaceType, rec.Profile)
+	s.Created = rec.CreatedAt
+	s.Label = rec.Label
+	s.ResumedFrom = rec.ResumedFrom
+	s.LastActivity = rec.LastActivity
+	s.PTY, s.pid = sc.pty, sc.hello.Pid
+	s.supervisor, s.supervisorSocket = sc, rec.SupervisorSocket
+	s.Status = "active"
+	s.exited = make(chan struct{})
+	if s.sandbox != nil {
+		s.cgroup = existingCgroup(*s.sandbox, s.SessionID)
+	}
+
+	// The agent ended while no service was attached: record how, then clean
+	// up as the previous run would have.
+	if sc.hello.Exited {
+		s.recordExit(sc.hello.ExitCode, sc.hello.ExitSignal, s.exited)
+		s.Cleanup()
+		return false
+	}
+
+	if err := s.resume(rec.EncryptedCredentials); err != nil {
+		log.WithError(err).WithFields(fields).Warn("Failed to recover session; terminating it")
+		s.Terminate(ReasonServerShutdown)
+		s.persistTermination()
+		return false
+	}
+	m.restoreOutput(ctx, s)
+
+	m.mu.Lock()
+	if err := m.checkUserLimit(s.UserID); err != nil {
+		m.mu.Unlock()
+		log.WithError(err).WithFields(fields).Warn("Recovered session is over the user's session limit; terminating it")
+		s.Terminate(ReasonSessionLimit)
+		s.persistTermination()
+		return false
+	}
+	m.sessions[s.SessionID] = s
+	m.mu.Unlock()
+
+	go s.readOutput()
+	go s.watchSupervisor(sc, s.exited)
+	go m.reapWhenEnded(s)
+
+	fields["pid"] = s.pid
+	log.WithFields(fields).Info("Session recovered")
+	return true
+}
+
+// restoreOutput seeds a recovered session's output buffer and screen with its
+// most recent stored output. The chunks are numbered up to the store ID of
+// the last one: IDs grow by at least one per chunk written, so new output
+// numbers on from the previous run rather than restarting below clients'
+// cursors.
+func (m *Manager) restoreOutput(ctx context.Context, s *Session) {
+	limit := m.config.Session.OutputBufferSize
+	if limit <= 0 {
+		limit = 100
+	}
+	chunks, err := m.store.GetOutputChunks(ctx, s.SessionID, limit)
+	if err != nil {
+		log.WithError(err).WithField("session_id", s.SessionID).Warn("Failed to load output for recovered session")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(chunks) > 0 {
+		// Buffered sequence numbers are consecutive.
+		s.lastSeq = uint64(chunks[len(chunks)-1].ID) - uint64(len(chunks))
+	}
+	for _, c := range chunks {
+		s.lastSeq++
+		s.OutputBuffer = append(s.OutputBuffer, OutputChunk{
+			Seq:       s.lastSeq,
+			Timestamp: c.Timestamp.Format(time.RFC3339),
+			Data:      c.Data,
+		})
+		if s.screen != nil {
+			s.screen.Write([]byte(c.Data))
+		}
+	}
+}
+
+// markRecoveryFailed records that a session ended with the previous run.
+func (m *Manager) markRecoveryFailed(ctx context.Context, sessionID string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := m.store.MarkSessionTerminated(ctx, sessionID, ReasonServerShutdown, nil, ""); err != nil {
+		log.WithError(err).WithField("session_id", sessionID).Warn("Failed to record session termination in DB")
+	}
+}
+
+// resume restores what a recovered session holds besides its agent: the
+// workspace lock, the redactor, the recording and the screen size.
+func (s *Session) resume(encCreds json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(encCreds) > 0 {
+		if err := json.Unmarshal(encCreds, &s.EncryptedCredentials); err != nil {
+			return fmt.Errorf("invalid stored credentials: %w", err)
+		}
+	}
+
+	if s.WorkspaceType == WorkspacePersistent {
+		lock, err := lockWorkspace(s.WorkspacePath)
+		if err != nil {
+			return err
+		}
+		s.workspaceLock = lock
+	}
+
+	if s.redaction != nil {
+		creds, err := decryptCredentials(s.EncryptedCredentials, s.encryptionKey)
+		if err != nil {
+			return err
+		}
+		s.redactor = redact.New(s.redaction.Patterns, map[string]string{
+			"anthropic_api_key": creds.AnthropicAPIKey,
+			"github_token":      creds.GitHubToken,
+		}, s.redaction.WindowBytes)
+	}
+
+	if s.recordingDir != "" {
+		rec, err := reopenRecorder(s.recordingDir, s.SessionID, s.encryptionKey, s.dbStore, s.recordingDB)
+		if err != nil {
+			return err
+		}
+		s.recorder = rec
+	}
+
+	if size, err := pty.GetsizeFull(s.PTY); err == nil && s.screen != nil {
+		s.screen.Resize(int(size.Cols), int(size.Rows))
+	}
+	return nil
+}
+
+// decryptCredentials recovers the raw credentials stored for a session (C6).
+// Without a key they were stored unencrypted.
+func decryptCredentials(enc EncryptedCredentials, key string) (Credentials, error) {
+	if key == "" {
+		return Credentials(enc), nil
+	}
+
+	var creds Credentials
+	for _, field := range []struct {
+		enc string
+		raw *string
+	}{
+		{enc.AnthropicAPIKey, &creds.AnthropicAPIKey},
+		{enc.GitHubToken, &creds.GitHubToken},
+	} {
+		if field.enc == "" {
+			continue
+		}
+		raw, err := crypto.Decrypt(field.enc, key)
+		if err != nil {
+			return creds, fmt.Errorf("failed to decrypt credentials: %w", err)
+		}
+		*field.raw = string(raw)
+	}
+	return creds, nil
+}
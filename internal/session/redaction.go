@@ -0,0 +1,70 @@
+package session
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// redactFlushDelay is how long output held back by the redactor, because it
+// may be the start of a secret, waits for more output before it is released.
+const redactFlushDelay = 100 * time.Millisecond
+
+// redactOutput masks secrets in PTY output and returns what may be delivered
+// now (must be called with lock held).
+func (s *Session) redactOutput(data string) string {
+	before := s.redactor.Total()
+	data = s.redactor.Write(data)
+	s.noteRedactions(before)
+	s.scheduleRedactFlush()
+	return data
+}
+
+// scheduleRedactFlush arms the timer that releases held-back output, or
+// stops it when nothing is held (must be called with lock held).
+func (s *Session) scheduleRedactFlush() {
+	if !s.redactor.Pending() {
+		if s.redactFlush != nil {
+			s.redactFlush.Stop()
+		}
+		return
+	}
+	if s.redactFlush == nil {
+		s.redactFlush = time.AfterFunc(redactFlushDelay, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.flushRedactor()
+		})
+		return
+	}
+	s.redactFlush.Reset(redactFlushDelay)
+}
+
+// flushRedactor delivers any output held back by the redactor (must be
+// called with lock held).
+func (s *Session) flushRedactor() {
+	if s.redactor == nil {
+		return
+	}
+	if s.redactFlush != nil {
+		s.redactFlush.Stop()
+	}
+	before := s.redactor.Total()
+	data := s.redactor.Flush()
+	s.noteRedactions(before)
+	if data != "" {
+		s.appendOutput(data)
+	}
+}
+
+// noteRedactions logs secrets masked since the count was before (must be
+// called with lock held).
+func (s *Session) noteRedactions(before int) {
+	if n := s.redactor.Total() - before; n > 0 {
+		log.WithFields(log.Fields{
+			"session_id": s.SessionID,
+			"redactions": n,
+			"total":      s.redactor.Total(),
+		}).Warn("Redacted secrets from session output")
+	}
+}
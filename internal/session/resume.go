@@ -0,0 +1,175 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+)
+
+// ErrNotResumable is returned when a session cannot be resumed: it has not
+// ended, its workspace was not persistent, or its workspace is gone or in
+// use.
+var ErrNotResumable = errors.New("session cannot be resumed")
+
+// ResumeSession starts a new session that continues a terminated one: a
+// fresh agent, launched with the profile's resume arguments, in the same
+// workspace and with the same stored credentials. The new session's output
+// buffer starts with the old session's persisted output. Only sessions with a
+// persistent workspace can be resumed: an isolated workspace belongs to its
+// one session and is removed when it ends, so one still on disk is a
+// leftover, not the user's work to continue. Like
+// CreateSessionWithOptions, it also returns a session failing with
+// ErrInitFailed.
+func (m *Manager) ResumeSession(ctx context.Context, sessionID, userID string) (*Session, error) {
+	if m.store == nil {
+		return nil, ErrSessionNotFound
+	}
+
+	// H1: sessions of other users look like missing ones.
+	rec, err := m.store.GetSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	if rec.UserID != userID {
+		return nil, ErrSessionNotFound
+	}
+	if rec.Status != "terminated" {
+		return nil, fmt.Errorf("%w: session is %s", ErrNotResumable, rec.Status)
+	}
+	if rec.WorkspaceType != WorkspacePersistent {
+		return nil, fmt.Errorf("%w: workspace was not persistent", ErrNotResumable)
+	}
+
+	// C4: the stored path must still resolve under BasePath.
+	workspace, err := resolveUnderBase(m.config.Workspace.BasePath, rec.WorkspacePath)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(workspace); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%w: workspace no longer exists", ErrNotResumable)
+	}
+
+	var encCreds EncryptedCredentials
+	if len(rec.EncryptedCredentials) > 0 {
+		if err := json.Unmarshal(rec.EncryptedCredentials, &encCreds); err != nil {
+			return nil, fmt.Errorf("invalid stored credentials: %w", err)
+		}
+	}
+	creds, err := decryptCredentials(encCreds, m.config.Security.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := rec.Profile
+	if profile == "" {
+		profile = config.DefaultProfile
+	}
+	agent, err := m.config.Session.Profile(profile)
+	if err != nil {
+		return nil, err
+	}
+	if agent.Command == "" {
+		agent = defaultAgent
+	}
+	agent.Args = append(append([]string(nil), agent.Args...), agent.ResumeArgs...)
+
+	// Prior output is read before taking the lock; the old session no longer
+	// writes any.
+	limit := m.config.Session.OutputBufferSize
+	if limit <= 0 {
+		limit = 100
+	}
+	prior, err := m.store.GetOutputChunks(ctx, sessionID, limit)
+	if err != nil {
+		log.WithError(err).WithField("session_id", sessionID).Warn("Failed to load prior output for resumed session")
+	}
+
+	m.mu.Lock()
+	if err := m.checkUserLimit(userID); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	// Ended sessions still in the map are on their way out and have let go
+	// of the workspace lock; lockWorkspace catches any that have not.
+	for _, sessions := range []map[string]*Session{m.sessions, m.pending} {
+		for _, s := range sessions {
+			if s.WorkspacePath == workspace && (s.Status == "active" || s.Status == "initializing") {
+				m.mu.Unlock()
+				return nil, fmt.Errorf("%w: workspace is in use by session %s", ErrNotResumable, s.SessionID)
+			}
+		}
+	}
+
+	wsLock, err := lockWorkspace(workspace)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	newID := uuid.New().String()
+	session := m.newSession(newID, userID, workspace, rec.WorkspaceType, profile)
+	session.Label = rec.Label
+	session.ResumedFrom = sessionID
+	session.EncryptedCredentials = encCreds
+	session.workspaceLock = wsLock
+	session.agent = agent
+	session.preloadOutput(prior)
+	m.pending[newID] = session
+	m.mu.Unlock()
+
+	// Unlike a new session, a failed resume leaves the workspace alone: it
+	// holds the user's work.
+	err = session.Initialize(creds)
+	m.admit(session, err == nil)
+	if err != nil {
+		session.persister.close()
+		releaseWorkspaceLock(wsLock)
+		m.recordFailedInit(session)
+		return session, fmt.Errorf("%w: %v", ErrInitFailed, err)
+	}
+
+	go func() {
+		m.saveSessionToDB(session)
+		m.reapWhenEnded(session)
+	}()
+
+	log.WithFields(log.Fields{
+		"session_id":   newID,
+		"resumed_from": sessionID,
+		"user_id":      userID,
+	}).Info("Session resumed")
+
+	return session, nil
+}
+
+// preloadOutput seeds the output buffer and screen with a previous session's
+// output before the agent starts. The chunks are already redacted, recorded
+// and persisted under that session, so they are not delivered again.
+func (s *Session) preloadOutput(chunks []store.OutputChunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range chunks {
+		s.lastSeq++
+		s.OutputBuffer = append(s.OutputBuffer, OutputChunk{
+			Seq:       s.lastSeq,
+			Timestamp: c.Timestamp.Format(time.RFC3339),
+			Data:      c.Data,
+		})
+		if s.screen != nil {
+			s.screen.Write([]byte(c.Data))
+		}
+	}
+}
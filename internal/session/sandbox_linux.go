@@ -0,0 +1,502 @@
+//go:build linux
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+)
+
+// sandboxInitName is the argv[0] the service re-executes itself with to run
+// the in-namespace init helper (see runSandboxInit).
+const sandboxInitName = "claude-terminal-sandbox-init"
+
+// cpuPeriod is the cgroup v2 cpu.max period in microseconds.
+const cpuPeriod = 100000
+
+// cgroupRemoveTimeout bounds how long cleanup waits for killed members to
+// leave the cgroup before giving up on removing it.
+const cgroupRemoveTimeout = 2 * time.Second
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == sandboxInitName {
+		runSandboxInit(os.Args[1:])
+	}
+}
+
+// sandboxCommand builds the command that runs the agent inside new user, mount
+// and PID namespaces (and a network namespace when configured). The service
+// binary is re-executed as the namespace init, which pivots into a minimal
+// root holding only the session's workspace and read-only system directories,
+// and then runs the agent without capabilities. When a cgroup root is
+// configured the process is started directly in a per-session cgroup carrying
+// the configured limits; the caller owns the returned cgroup (nil when
+// disabled).
+func sandboxCommand(cfg config.SandboxConfig, agent config.AgentProfile, basePath, workspace, sessionID string) (*exec.Cmd, *cgroup, error) {
+	absBase, err := filepath.Abs(basePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve base path: %w", err)
+	}
+
+	isolateNet := "0"
+	flags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID)
+	if cfg.IsolateNetwork {
+		isolateNet = "1"
+		flags |= syscall.CLONE_NEWNET
+	}
+
+	attr := &syscall.SysProcAttr{Cloneflags: flags}
+
+	// The init helper is root inside the user namespace, so it can mount, but
+	// its capabilities end at the namespace. An unprivileged service can only
+	// map itself, and the agent runs as that user without capabilities. A
+	// root service also maps the configured unprivileged IDs, as 1 inside, and
+	// the agent runs as those: root on the host must never reach the agent.
+	agentIDs := ""
+	if euid := os.Geteuid(); euid != 0 {
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: euid, Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	} else {
+		if cfg.UID <= 0 || cfg.GID <= 0 {
+			return nil, nil, errors.New("sandbox needs an unprivileged UID and GID to run the agent as")
+		}
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: 1}, {ContainerID: 1, HostID: cfg.UID, Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: 1}, {ContainerID: 1, HostID: cfg.GID, Size: 1}}
+		attr.GidMappingsEnableSetgroups = true
+		agentIDs = "1:1"
+		if err := chownTree(workspace, cfg.UID, cfg.GID); err != nil {
+			return nil, nil, fmt.Errorf("failed to hand workspace to sandbox user: %w", err)
+		}
+	}
+
+	var cg *cgroup
+	if cfg.CgroupRoot != "" {
+		cg, err = newCgroup(cfg, sessionID)
+		if err != nil {
+			return nil, nil, err
+		}
+		attr.UseCgroupFD = true
+		attr.CgroupFD = int(cg.dir.Fd())
+	}
+
+	cmd := &exec.Cmd{
+		Path: "/proc/self/exe",
+		Args: append([]string{sandboxInitName, absBase, workspace, isolateNet, agentIDs,
+			strings.Join(cfg.ReadOnlyPaths, ","), agent.Command}, agent.Args...),
+		SysProcAttr: attr,
+	}
+	return cmd, cg, nil
+}
+
+// chownTree gives path and everything below it to uid and gid.
+func chownTree(path string, uid, gid int) error {
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, uid, gid)
+	})
+}
+
+// runSandboxInit runs as PID 1 of the sandbox. It prepares the mount
+// namespace, starts the agent, forwards termination signals to it and reaps
+// orphaned processes until the agent exits. It never returns.
+// Errors are written to stderr, which is the session's PTY.
+func runSandboxInit(args []string) {
+	if len(args) < 6 {
+		fmt.Fprintln(os.Stderr, "sandbox: invalid arguments")
+		os.Exit(126)
+	}
+	base, workspace, isolateNet, agentIDs, argv := args[0], args[1], args[2] == "1", args[3], args[5:]
+	var readOnly []string
+	if args[4] != "" {
+		readOnly = strings.Split(args[4], ",")
+	}
+
+	// Capability bounding sets and no_new_privs are per thread; the agent is
+	// forked from this one.
+	runtime.LockOSThread()
+
+	if err := setupSandboxMounts(base, workspace, readOnly); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(126)
+	}
+	if isolateNet {
+		if err := loopbackUp(); err != nil {
+			fmt.Fprintf(os.Stderr, "sandbox: failed to bring up loopback: %v\n", err)
+			os.Exit(126)
+		}
+	}
+
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(127)
+	}
+
+	procAttr := &os.ProcAttr{
+		Dir:   workspace,
+		Env:   os.Environ(),
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+	}
+	if agentIDs != "" {
+		var uid, gid uint32
+		if _, err := fmt.Sscanf(agentIDs, "%d:%d", &uid, &gid); err != nil {
+			fmt.Fprintf(os.Stderr, "sandbox: invalid agent IDs %q\n", agentIDs)
+			os.Exit(126)
+		}
+		procAttr.Sys = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uid, Gid: gid, Groups: []uint32{}}}
+	}
+	if err := dropPrivileges(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(126)
+	}
+
+	// Terminal-generated signals (SIGINT, SIGTSTP, SIGWINCH...) reach the
+	// agent directly through the shared process group; as namespace init this
+	// process drops them. Only signals sent explicitly to init are forwarded.
+	sigs := make(chan os.Signal, 16)
+	signal.Notify(sigs, syscall.SIGCHLD, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	proc, err := os.StartProcess(path, argv, procAttr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(126)
+	}
+
+	for sig := range sigs {
+		if sig != syscall.SIGCHLD {
+			_ = proc.Signal(sig)
+			continue
+		}
+		for {
+			var status syscall.WaitStatus
+			pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+			if err != nil || pid <= 0 {
+				break
+			}
+			if pid != proc.Pid {
+				continue
+			}
+			if status.Signaled() {
+				os.Exit(128 + int(status.Signal()))
+			}
+			os.Exit(status.ExitStatus())
+		}
+	}
+}
+
+// sandboxDevices are bound from the host into the sandbox's /dev.
+var sandboxDevices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// setupSandboxMounts builds the sandbox root on a tmpfs over base: the
+// read-only paths, a minimal /dev, a private /tmp, the session's workspace
+// and a /proc for the new PID namespace. It then pivots into it and detaches
+// the host's root, so no mount can be lifted to reveal what lies beneath.
+func setupSandboxMounts(base, workspace string, readOnly []string) error {
+	// Keep every mount below private to this namespace.
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	// Hold the workspace open so it stays reachable once base is covered.
+	ws, err := os.OpenFile(workspace, os.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open workspace: %w", err)
+	}
+	defer ws.Close()
+
+	root := base
+	if err := syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755,size=64k"); err != nil {
+		return fmt.Errorf("failed to create sandbox root: %w", err)
+	}
+
+	for _, p := range readOnly {
+		if err := bindReadOnly(p, filepath.Join(root, p)); err != nil {
+			return err
+		}
+	}
+
+	dev := filepath.Join(root, "dev")
+	if err := os.Mkdir(dev, 0755); err != nil {
+		return fmt.Errorf("failed to create /dev: %w", err)
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_NOEXEC, "mode=0755,size=64k"); err != nil {
+		return fmt.Errorf("failed to mount /dev: %w", err)
+	}
+	for _, name := range sandboxDevices {
+		target := filepath.Join(dev, name)
+		if err := os.WriteFile(target, nil, 0644); err != nil {
+			return fmt.Errorf("failed to create /dev/%s: %w", name, err)
+		}
+		if err := syscall.Mount("/dev/"+name, target, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed to bind /dev/%s: %w", name, err)
+		}
+	}
+	for name, target := range map[string]string{"fd": "/proc/self/fd", "stdin": "/proc/self/fd/0", "stdout": "/proc/self/fd/1", "stderr": "/proc/self/fd/2"} {
+		if err := os.Symlink(target, filepath.Join(dev, name)); err != nil {
+			return fmt.Errorf("failed to create /dev/%s: %w", name, err)
+		}
+	}
+
+	tmp := filepath.Join(root, "tmp")
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return fmt.Errorf("failed to create /tmp: %w", err)
+	}
+	if err := syscall.Mount("tmpfs", tmp, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("failed to mount /tmp: %w", err)
+	}
+
+	target := filepath.Join(root, workspace)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create workspace mount point: %w", err)
+	}
+	src := fmt.Sprintf("/proc/self/fd/%d", ws.Fd())
+	if err := syscall.Mount(src, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind workspace: %w", err)
+	}
+
+	proc := filepath.Join(root, "proc")
+	if err := os.Mkdir(proc, 0555); err != nil {
+		return fmt.Errorf("failed to create /proc: %w", err)
+	}
+	if err := syscall.Mount("proc", proc, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount /proc: %w", err)
+	}
+
+	// pivot_root(".", ".") stacks the old root on top of the new one, from
+	// where it is detached.
+	if err := syscall.Chdir(root); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("failed to pivot into sandbox root: %w", err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach host root: %w", err)
+	}
+	if err := unix.MountSetattr(unix.AT_FDCWD, "/", 0, &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}); err != nil {
+		return fmt.Errorf("failed to make sandbox root read-only: %w", err)
+	}
+
+	return syscall.Chdir(workspace)
+}
+
+// bindReadOnly recreates the host path src at target: directories and files
+// are bound read-only, symlinks are copied. Missing paths are skipped.
+func bindReadOnly(src, target string) error {
+	info, err := os.Lstat(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(src), err)
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	case info.IsDir():
+		err = os.Mkdir(target, 0755)
+	default:
+		err = os.WriteFile(target, nil, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create mount point for %s: %w", src, err)
+	}
+
+	if err := syscall.Mount(src, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind %s: %w", src, err)
+	}
+	attr := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY | unix.MOUNT_ATTR_NOSUID}
+	if err := unix.MountSetattr(unix.AT_FDCWD, target, unix.AT_RECURSIVE, attr); err != nil {
+		return fmt.Errorf("failed to make %s read-only: %w", src, err)
+	}
+	return nil
+}
+
+// dropPrivileges empties the calling thread's capability bounding and
+// ambient sets and sets no_new_privs, so a process it starts cannot hold a
+// capability even as root or through a setuid or file-capability binary.
+// It also stops the agent from attaching to init, which keeps its own
+// capabilities in the namespace.
+func dropPrivileges() error {
+	if err := unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to make init non-dumpable: %w", err)
+	}
+	for c := 0; ; c++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0)
+		if errors.Is(err, unix.EINVAL) {
+			break // past the last capability
+		}
+		if err != nil {
+			return fmt.Errorf("failed to drop capability %d: %w", c, err)
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to clear ambient capabilities: %w", err)
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	return nil
+}
+
+// loopbackUp brings up lo in a fresh network namespace so local tooling that
+// binds 127.0.0.1 keeps working without external connectivity.
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// struct ifreq: 16-byte name followed by the flags union.
+	var ifr [40]byte
+	copy(ifr[:], "lo")
+	*(*uint16)(unsafe.Pointer(&ifr[syscall.IFNAMSIZ])) = syscall.IFF_UP | syscall.IFF_RUNNING
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// cgroup is a per-session cgroup v2 directory.
+type cgroup struct {
+	path string
+	dir  *os.File // passed to clone(CLONE_INTO_CGROUP); closed once started
+}
+
+// newCgroup creates <CgroupRoot>/<name> and applies the configured limits.
+// CgroupRoot must be a cgroup v2 directory delegated to the service user.
+func newCgroup(cfg config.SandboxConfig, name string) (*cgroup, error) {
+	if err := os.MkdirAll(cfg.CgroupRoot, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup root: %w", err)
+	}
+
+	var limits [][2]string
+	if cfg.CPUMillicores > 0 {
+		limits = append(limits, [2]string{"cpu.max", fmt.Sprintf("%d %d", cfg.CPUMillicores*cpuPeriod/1000, cpuPeriod)})
+	}
+	if cfg.MemoryMaxMB > 0 {
+		limits = append(limits, [2]string{"memory.max", strconv.Itoa(cfg.MemoryMaxMB * 1024 * 1024)})
+	}
+	if cfg.PidsMax > 0 {
+		limits = append(limits, [2]string{"pids.max", strconv.Itoa(cfg.PidsMax)})
+	}
+
+	// Enable the needed controllers for children of the root. A failure here
+	// surfaces below as a missing limit file, with a clearer error.
+	for _, limit := range limits {
+		controller := "+" + strings.SplitN(limit[0], ".", 2)[0]
+		_ = os.WriteFile(filepath.Join(cfg.CgroupRoot, "cgroup.subtree_control"), []byte(controller), 0)
+	}
+
+	cg := &cgroup{path: filepath.Join(cfg.CgroupRoot, name)}
+	if err := os.Mkdir(cg.path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	for _, limit := range limits {
+		if err := os.WriteFile(filepath.Join(cg.path, limit[0]), []byte(limit[1]), 0); err != nil {
+			_ = cg.remove()
+			return nil, fmt.Errorf("failed to set %s: %w", limit[0], err)
+		}
+	}
+
+	dir, err := os.Open(cg.path)
+	if err != nil {
+		_ = cg.remove()
+		return nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+	cg.dir = dir
+
+	return cg, nil
+}
+
+// existingCgroup returns the cgroup newCgroup created for name, or nil when
+// there is none.
+func existingCgroup(cfg config.SandboxConfig, name string) *cgroup {
+	if cfg.CgroupRoot == "" {
+		return nil
+	}
+	cg := &cgroup{path: filepath.Join(cfg.CgroupRoot, name)}
+	if !cg.exists() {
+		return nil
+	}
+	return cg
+}
+
+// started releases the directory handle once the process has joined.
+func (cg *cgroup) started() {
+	if cg.dir != nil {
+		cg.dir.Close()
+		cg.dir = nil
+	}
+}
+
+// kill sends SIGKILL to every process in the cgroup.
+func (cg *cgroup) kill() error {
+	err := os.WriteFile(filepath.Join(cg.path, "cgroup.kill"), []byte("1"), 0)
+	if err == nil || !cg.exists() {
+		return nil
+	}
+
+	// cgroup.kill needs Linux 5.14; fall back to signalling each member.
+	data, readErr := os.ReadFile(filepath.Join(cg.path, "cgroup.procs"))
+	if readErr != nil {
+		return fmt.Errorf("failed to list cgroup members: %w", readErr)
+	}
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+	return nil
+}
+
+// remove deletes the cgroup, waiting briefly for killed members to exit.
+func (cg *cgroup) remove() error {
+	cg.started()
+
+	deadline := time.Now().Add(cgroupRemoveTimeout)
+	for {
+		err := syscall.Rmdir(cg.path)
+		if err == nil || errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) || time.Now().After(deadline) {
+			return fmt.Errorf("failed to remove cgroup: %w", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (cg *cgroup) exists() bool {
+	_, err := os.Stat(cg.path)
+	return err == nil
+}
@@ -0,0 +1,171 @@
+//go:build linux
+
+package session
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+)
+
+// sandboxTestConfig returns a config whose agent runs script under the sandbox.
+func sandboxTestConfig(t *testing.T, script string) *config.Config {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("sandbox tests require root")
+	}
+
+	cfg := fakeAgentConfig(t)
+	cfg.Session.Agent = config.AgentProfile{Command: "/bin/sh", Args: []string{"-c", script}}
+	cfg.Sandbox = config.SandboxConfig{
+		Enabled:        true,
+		IsolateNetwork: true,
+		UID:            65534,
+		GID:            65534,
+		ReadOnlyPaths:  []string{"/usr", "/bin", "/sbin", "/lib", "/lib64", "/etc"},
+	}
+	return cfg
+}
+
+// cgroup2Mount returns the cgroup v2 mount point, skipping if there is none.
+func cgroup2Mount(t *testing.T) string {
+	t.Helper()
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		t.Skipf("cannot read mountinfo: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Fields after the " - " separator are: fstype source options.
+		parts := strings.SplitN(scanner.Text(), " - ", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[1], "cgroup2 ") {
+			return strings.Fields(parts[0])[4]
+		}
+	}
+	t.Skip("cgroup v2 is not mounted")
+	return ""
+}
+
+func TestSandboxedSessionIsolation(t *testing.T) {
+	cfg := sandboxTestConfig(t, "")
+	base := cfg.Workspace.BasePath
+	if err := os.MkdirAll(filepath.Join(base, "other-user", "secret"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Session.Agent.Args = []string{"-c", fmt.Sprintf(
+		"echo init=$(tr '\\0' ' ' </proc/1/cmdline); echo users=$(ls %s); echo ifaces=$(grep -c : /proc/net/dev); touch marker; echo cwd=$(pwd); exec cat", base)}
+
+	manager := NewManager(cfg, nil)
+	sess, err := manager.CreateSession("test-user", Credentials{AnthropicAPIKey: "test-key"}, "isolated")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	defer manager.TerminateSession(sess.SessionID)
+
+	// The echoed init cmdline also contains "cwd=", so wait for the value.
+	output := waitForOutput(t, sess, "cwd="+sess.WorkspacePath)
+
+	// The re-executed init helper is PID 1 of the new PID namespace.
+	if !strings.Contains(output, "init="+sandboxInitName) {
+		t.Errorf("Expected agent to run in a new PID namespace, got %q", output)
+	}
+	if !strings.Contains(output, "users=test-user\r") {
+		t.Errorf("Expected other workspaces to be hidden, got %q", output)
+	}
+	if !strings.Contains(output, "ifaces=1") {
+		t.Errorf("Expected only loopback in the network namespace, got %q", output)
+	}
+
+	// Writes land in the host workspace through the bind mount.
+	if _, err := os.Stat(filepath.Join(sess.WorkspacePath, "marker")); err != nil {
+		t.Errorf("Expected file written in the sandbox to exist on the host: %v", err)
+	}
+}
+
+func TestSandboxEscape(t *testing.T) {
+	cfg := sandboxTestConfig(t, "")
+	base := cfg.Workspace.BasePath
+	if err := os.MkdirAll(filepath.Join(base, "other-user", "secret"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Lifting the mount over the base path used to reveal every workspace.
+	cfg.Session.Agent.Args = []string{"-c", fmt.Sprintf(`
+		cd /; umount -l %[1]s; umount -l /; umount -l /etc
+		echo users=$(ls %[1]s)
+		cat /etc/shadow >/dev/null 2>&1 && echo shadow=readable || echo shadow=denied
+		touch /usr/escape 2>/dev/null && echo usr=writable || echo usr=read-only
+		grep -E '^Cap(Eff|Prm|Bnd)' /proc/self/status
+		echo done; exec cat`, base)}
+
+	manager := NewManager(cfg, nil)
+	sess, err := manager.CreateSession("test-user", Credentials{AnthropicAPIKey: "test-key"}, "isolated")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	defer manager.TerminateSession(sess.SessionID)
+
+	output := waitForOutput(t, sess, "done")
+	if !strings.Contains(output, "users=test-user\r") {
+		t.Errorf("Expected other workspaces to stay hidden after umount, got %q", output)
+	}
+	if !strings.Contains(output, "shadow=denied") {
+		t.Errorf("Expected /etc/shadow to be unreadable, got %q", output)
+	}
+	if !strings.Contains(output, "usr=read-only") {
+		t.Errorf("Expected system directories to be read-only, got %q", output)
+	}
+	for _, set := range []string{"CapEff", "CapPrm", "CapBnd"} {
+		if !strings.Contains(output, set+":\t0000000000000000") {
+			t.Errorf("Expected the agent's %s to be empty, got %q", set, output)
+		}
+	}
+	if _, err := os.Stat("/usr/escape"); err == nil {
+		os.Remove("/usr/escape")
+		t.Error("Expected the sandbox not to write to /usr")
+	}
+}
+
+func TestSandboxCgroupKill(t *testing.T) {
+	cfg := sandboxTestConfig(t, "sleep 300 & echo started; exec cat")
+	root := filepath.Join(cgroup2Mount(t), fmt.Sprintf("claude-terminal-test-%d", os.Getpid()))
+	cfg.Sandbox.CgroupRoot = root
+	t.Cleanup(func() { syscall.Rmdir(root) })
+
+	manager := NewManager(cfg, nil)
+	sess, err := manager.CreateSession("test-user", Credentials{AnthropicAPIKey: "test-key"}, "isolated")
+	if err != nil {
+		if os.IsPermission(err) || strings.Contains(err.Error(), "cgroup") {
+			t.Skipf("cgroup v2 not usable here: %v", err)
+		}
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	waitForOutput(t, sess, "started")
+
+	cgPath := filepath.Join(root, sess.SessionID)
+	procs, err := os.ReadFile(filepath.Join(cgPath, "cgroup.procs"))
+	if err != nil {
+		t.Fatalf("Failed to read cgroup members: %v", err)
+	}
+	// init helper, cat and the background sleep
+	if n := len(strings.Fields(string(procs))); n < 3 {
+		t.Fatalf("Expected at least 3 processes in the session cgroup, got %d", n)
+	}
+
+	if err := manager.TerminateSession(sess.SessionID); err != nil {
+		t.Fatalf("TerminateSession failed: %v", err)
+	}
+
+	// Cleanup removes the cgroup, which only succeeds once every member,
+	// including the orphaned sleep, has been killed.
+	if _, err := os.Stat(cgPath); !os.IsNotExist(err) {
+		t.Errorf("Expected session cgroup to be removed after termination, got %v", err)
+	}
+}
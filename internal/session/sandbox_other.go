@@ -0,0 +1,23 @@
+//go:build !linux
+
+package session
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+)
+
+// cgroup is a placeholder; cgroups are Linux-only.
+type cgroup struct{}
+
+func sandboxCommand(cfg config.SandboxConfig, agent config.AgentProfile, basePath, workspace, sessionID string) (*exec.Cmd, *cgroup, error) {
+	return nil, nil, errors.New("sandboxing is only supported on Linux")
+}
+
+func existingCgroup(cfg config.SandboxConfig, name string) *cgroup { return nil }
+
+func (cg *cgroup) started()      {}
+func (cg *cgroup) kill() error   { return nil }
+func (cg *cgroup) remove() error { return nil }
@@ -3,6 +3,7 @@ package session
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -11,15 +12,20 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/creack/pty"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"golang.org/x/time/rate"
 
 	"github.com/servicenow/claude-terminal-mid-service/internal/config"
 	"github.com/servicenow/claude-terminal-mid-service/internal/crypto"
+	"github.com/servicenow/claude-terminal-mid-service/internal/redact"
 	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+	"github.com/servicenow/claude-terminal-mid-service/internal/terminal"
 )
 
 // Maximum command length in bytes.
@@ -28,15 +34,55 @@ const maxCommandLength = 16384
 // Minimum interval between commands per session.
 const commandRateInterval = 100 * time.Millisecond
 
+// defaultAgent is launched when neither config nor the request names a program.
+var defaultAgent = config.AgentProfile{Command: "claude", Args: []string{"code"}}
+
+// subscriberBufferSize is the number of chunks a live output subscriber may
+// fall behind before it is disconnected.
+const subscriberBufferSize = 256
+
+// processReapTimeout bounds how long cleanup waits for a SIGKILLed agent to be
+// reaped (e.g. a process stuck in uninterruptible sleep).
+const processReapTimeout = 5 * time.Second
+
+// Termination reasons recorded on a session when it ends.
+const (
+	ReasonUserTerminated = "user_terminated"
+	ReasonIdleTimeout    = "idle_timeout"
+	ReasonProcessExited  = "process_exited"   // agent exited on its own; see exit code
+	ReasonKilledBySignal = "killed_by_signal" // agent died from a signal; see exit signal
+	ReasonServerShutdown = "server_shutdown"
+	ReasonInitFailed     = "init_failed"
+	ReasonSessionLimit   = "session_limit" // recovered after a restart with the user over MAX_SESSIONS_PER_USER
+)
+
+// ErrSessionNotFound is returned when a session does not exist or belongs to
+// another user (H1).
+var ErrSessionNotFound = errors.New("session not found")
+
 // validIDPattern matches alphanumeric strings, hyphens, and underscores only.
 var validIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
-// OutputChunk represents a chunk of terminal output
+// OutputChunk represents a chunk of terminal output.
+// Seq increases monotonically per session, starting at 1.
 type OutputChunk struct {
+	Seq       uint64 `json:"seq"`
 	Timestamp string `json:"timestamp"`
 	Data      string `json:"data"`
 }
 
+// OutputPage is the result of a cursor-based output read.
+type OutputPage struct {
+	// Chunks holds the buffered chunks with Seq greater than the cursor.
+	Chunks []OutputChunk
+	// NextCursor is the Seq of the newest chunk produced so far; pass it back
+	// as the cursor to receive only newer output.
+	NextCursor uint64
+	// Gap is true when chunks after the cursor were evicted from the ring
+	// buffer (or cleared) before they could be read.
+	Gap bool
+}
+
 // Credentials holds user credentials
 type Credentials struct {
 	AnthropicAPIKey string `json:"anthropicApiKey"`
@@ -54,77 +100,116 @@ type Session struct {
 	SessionID            string
 	UserID               string
 	WorkspacePath        string
+	WorkspaceType        string
+	Profile              string
+	Label                string // free-form name for history; see validateLabel
+	ResumedFrom          string // the terminated session this one continues; empty for new sessions
 	EncryptedCredentials EncryptedCredentials
 	Status               string
+	TerminationReason    string // set once when the session ends; see Reason* constants
 	PTY                  *os.File
-	Cmd                  *exec.Cmd
+	Cmd                  *exec.Cmd // nil when the agent runs under a supervisor
 	OutputBuffer         []OutputChunk
 	LastActivity         time.Time
 	Created              time.Time
 	lastCommandTime      time.Time
+	keysLimiter          *rate.Limiter // SendKeys rate limit; created on first use
 	mu                   sync.RWMutex
 	done                 chan struct{}
+	ended                chan struct{} // closed when readOutput stops other than by Cleanup or detach
 	encryptionKey        string
 	outputBufferSize     int
-	dbStore              *store.PostgresStore // nil when running in-memory only
+	lastSeq              uint64
+	outputReady          chan struct{} // closed and cleared on new output; nil when nobody waits
+	subscribers          map[chan OutputChunk]struct{}
+	screen               *terminal.Terminal // rendered screen fed from PTY output
+	recorder             *recorder          // asciicast recording; nil when recording is disabled
+	recordingDir         string
+	recordingDB          config.DatabaseConfig   // batching of recording writes to dbStore
+	recordingKey         string                  // master key encrypting new recordings; empty leaves them plaintext
+	redaction            *config.RedactionConfig // nil when redaction is disabled
+	redactor             *redact.Redactor        // created in Initialize from the raw credentials
+	redactFlush          *time.Timer             // releases output the redactor holds back
+	workspaceLock        *os.File                // held for persistent workspaces; nil otherwise
+	source               *workspaceSource        // template or repo to populate the workspace from; nil for empty
+	agent                config.AgentProfile
+	sandbox              *config.SandboxConfig // nil when sandboxing is disabled
+	basePath             string                // workspace root hidden from sandboxed agents
+	cgroup               *cgroup               // per-session cgroup; nil unless sandboxed with limits
+	killGrace            time.Duration         // SIGTERM-to-SIGKILL delay on cleanup
+	pid                  int                   // agent process group leader; 0 before start
+	exited               chan struct{}         // closed once the agent has been reaped; nil before start
+	exitCode             int
+	exitSignal           string
+	dbStore              store.Store     // nil when running in-memory only
+	persister            *outputWriter   // batches output into dbStore; nil without a store
+	supervisorDir        string          // where new agents' supervisors listen; empty runs them unsupervised
+	supervisor           *supervisorConn // nil unless the agent runs under a supervisor
+	supervisorSocket     string
+	detached             bool // released at shutdown with the agent left running
 }
 
 // Manager manages all active sessions
 type Manager struct {
 	sessions map[string]*Session
 	config   *config.Config
-	store    *store.PostgresStore // nil when running in-memory only
+	store    store.Store // nil when running in-memory only
 	mu       sync.RWMutex
+
+	// pending holds sessions still initializing outside the lock. They count
+	// towards the user's limit but are not visible until initialized.
+	pending map[string]*Session
 }
 
 // NewManager creates a new session manager.
 // The store parameter is optional; pass nil to use in-memory only.
-func NewManager(cfg *config.Config, pgStore *store.PostgresStore) *Manager {
+func NewManager(cfg *config.Config, sessionStore store.Store) *Manager {
 	return &Manager{
 		sessions: make(map[string]*Session),
+		pending:  make(map[string]*Session),
 		config:   cfg,
-		store:    pgStore,
+		store:    sessionStore,
 	}
 }
 
 // CreateSession creates a new Claude Code CLI session
 func (m *Manager) CreateSession(userID string, credentials Credentials, workspaceType string) (*Session, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	return m.CreateSessionWithOptions(userID, credentials, SessionOptions{WorkspaceType: workspaceType})
+}
 
+// CreateSessionWithOptions creates a new Claude Code CLI session with optional
+// per-session settings. On ErrInitFailed the failed session is returned too,
+// for its status and termination reason.
+func (m *Manager) CreateSessionWithOptions(userID string, credentials Credentials, opts SessionOptions) (*Session, error) {
 	// C4: Validate userID is alphanumeric/hyphens/underscores only
 	if !validIDPattern.MatchString(userID) {
 		return nil, fmt.Errorf("invalid userID: must be alphanumeric, hyphens, or underscores")
 	}
 
-	// Check user session limit
-	userSessions := m.getUserSessions(userID)
-	activeSessions := 0
-	for _, s := range userSessions {
-		if s.Status == "active" || s.Status == "initializing" {
-			activeSessions++
-		}
+	if err := validateLabel(opts.Label); err != nil {
+		return nil, err
 	}
 
-	if activeSessions >= m.config.Session.MaxPerUser {
-		return nil, fmt.Errorf("maximum sessions per user (%d) reached", m.config.Session.MaxPerUser)
+	wsType, err := resolveWorkspaceType(opts.WorkspaceType, m.config.Workspace.Type)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create session
-	sessionID := uuid.New().String()
-	workspacePath := filepath.Join(m.config.Workspace.BasePath, userID, sessionID)
-
-	// C4: Verify workspace path resolves under BasePath
-	absWorkspace, err := filepath.Abs(workspacePath)
+	source, err := resolveWorkspaceSource(m.config.Workspace, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve workspace path: %w", err)
+		return nil, err
 	}
-	absBase, err := filepath.Abs(m.config.Workspace.BasePath)
+
+	agent, err := m.config.Session.Profile(opts.Profile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve base path: %w", err)
+		return nil, err
+	}
+	if agent.Command == "" {
+		agent = defaultAgent
 	}
-	if !strings.HasPrefix(absWorkspace, absBase+string(filepath.Separator)) {
-		return nil, fmt.Errorf("workspace path traversal detected")
+	profile := opts.Profile
+	if profile == "" {
+		profile = config.DefaultProfile
 	}
 
 	// C6: Encrypt credentials at rest
@@ -151,33 +236,64 @@ func (m *Manager) CreateSession(userID string, credentials Credentials, workspac
 		encCreds.GitHubToken = credentials.GitHubToken
 	}
 
-	session := &Session{
-		SessionID:            sessionID,
-		UserID:               userID,
-		WorkspacePath:        absWorkspace,
-		EncryptedCredentials: encCreds,
-		Status:               "initializing",
-		OutputBuffer:         make([]OutputChunk, 0),
-		LastActivity:         time.Now(),
-		Created:              time.Now(),
-		done:                 make(chan struct{}),
-		encryptionKey:        encKey,
-		outputBufferSize:     m.config.Session.OutputBufferSize,
-		dbStore:              m.store,
+	// Create session
+	sessionID := uuid.New().String()
+
+	// C4: Workspace path is verified to resolve under BasePath
+	absWorkspace, err := workspacePath(m.config.Workspace.BasePath, userID, sessionID, wsType, opts.WorkspaceName)
+	if err != nil {
+		return nil, err
 	}
 
-	// Initialize session - pass raw credentials for env setup
-	if err := session.Initialize(credentials); err != nil {
-		return nil, fmt.Errorf("failed to initialize session: %w", err)
+	m.mu.Lock()
+	if err := m.checkUserLimit(userID); err != nil {
+		m.mu.Unlock()
+		return nil, err
 	}
 
-	m.sessions[sessionID] = session
+	// Persistent workspaces outlive sessions, so guard them against
+	// concurrent use. Isolated workspaces are unique per session.
+	var wsLock *os.File
+	if wsType == WorkspacePersistent {
+		wsLock, err = lockWorkspace(absWorkspace)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+	}
 
-	// Persist to PostgreSQL (async, non-blocking).
-	if m.store != nil {
-		go m.saveSessionToDB(session)
+	session := m.newSession(sessionID, userID, absWorkspace, wsType, profile)
+	session.Label = opts.Label
+	session.EncryptedCredentials = encCreds
+	session.workspaceLock = wsLock
+	session.source = source
+	session.agent = agent
+	m.pending[sessionID] = session
+	m.mu.Unlock()
+
+	// Initialize session - pass raw credentials for env setup. Populating the
+	// workspace can take minutes, so this runs without the manager lock.
+	err = session.Initialize(credentials)
+	m.admit(session, err == nil)
+	if err != nil {
+		session.persister.close()
+		releaseWorkspaceLock(wsLock)
+		if wsType == WorkspaceIsolated {
+			os.RemoveAll(absWorkspace)
+		}
+		m.recordFailedInit(session)
+		return session, fmt.Errorf("%w: %v", ErrInitFailed, err)
 	}
 
+	// Persist to the store (async, non-blocking), then watch for the agent
+	// ending on its own.
+	go func() {
+		if m.store != nil {
+			m.saveSessionToDB(session)
+		}
+		m.reapWhenEnded(session)
+	}()
+
 	log.WithFields(log.Fields{
 		"session_id": sessionID,
 		"user_id":    userID,
@@ -186,6 +302,47 @@ func (m *Manager) CreateSession(userID string, credentials Credentials, workspac
 	return session, nil
 }
 
+// newSession builds a session carrying the manager's per-session settings.
+func (m *Manager) newSession(sessionID, userID, workspace, wsType, profile string) *Session {
+	session := &Session{
+		SessionID:        sessionID,
+		UserID:           userID,
+		WorkspacePath:    workspace,
+		WorkspaceType:    wsType,
+		Profile:          profile,
+		Status:           "initializing",
+		OutputBuffer:     make([]OutputChunk, 0),
+		LastActivity:     time.Now(),
+		Created:          time.Now(),
+		done:             make(chan struct{}),
+		ended:            make(chan struct{}),
+		encryptionKey:    m.config.Security.EncryptionKey,
+		outputBufferSize: m.config.Session.OutputBufferSize,
+		screen:           terminal.New(terminal.DefaultCols, terminal.DefaultRows, m.config.Session.ScrollbackLines),
+		killGrace:        time.Duration(m.config.Session.KillGraceSeconds) * time.Second,
+		basePath:         m.config.Workspace.BasePath,
+		supervisorDir:    m.config.Session.SupervisorDir,
+		dbStore:          m.store,
+	}
+	if m.config.Sandbox.Enabled {
+		session.sandbox = &m.config.Sandbox
+	}
+	if m.config.Recording.Enabled {
+		session.recordingDir = m.config.Recording.Path
+		session.recordingDB = m.config.Database
+		if m.config.Database.EncryptOutput {
+			session.recordingKey = m.config.Security.EncryptionKey
+		}
+	}
+	if m.config.Redaction.Enabled {
+		session.redaction = &m.config.Redaction
+	}
+	if m.store != nil {
+		session.persister = newOutputWriter(m.store, sessionID, m.config.Database)
+	}
+	return session
+}
+
 // GetSession retrieves a session by ID, optionally verifying ownership.
 func (m *Manager) GetSession(sessionID string) (*Session, error) {
 	m.mu.RLock()
@@ -193,7 +350,7 @@ func (m *Manager) GetSession(sessionID string) (*Session, error) {
 
 	session, exists := m.sessions[sessionID]
 	if !exists {
-		return nil, fmt.Errorf("session not found")
+		return nil, ErrSessionNotFound
 	}
 
 	return session, nil
@@ -206,11 +363,11 @@ func (m *Manager) GetSessionForUser(sessionID, userID string) (*Session, error)
 
 	session, exists := m.sessions[sessionID]
 	if !exists {
-		return nil, fmt.Errorf("session not found")
+		return nil, ErrSessionNotFound
 	}
 
 	if session.UserID != userID {
-		return nil, fmt.Errorf("session not found")
+		return nil, ErrSessionNotFound
 	}
 
 	return session, nil
@@ -227,87 +384,140 @@ func (m *Manager) ListSessionsForUser(userID string) []*Session {
 // TerminateSession terminates and cleans up a session
 func (m *Manager) TerminateSession(sessionID string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	session, exists := m.sessions[sessionID]
 	if !exists {
-		return fmt.Errorf("session not found")
-	}
-
-	if err := session.Cleanup(); err != nil {
-		log.WithFields(log.Fields{
-			"session_id": sessionID,
-			"error":      err,
-		}).Error("Error cleaning up session")
+		m.mu.Unlock()
+		return ErrSessionNotFound
 	}
-
 	delete(m.sessions, sessionID)
+	m.mu.Unlock()
 
-	// Update status in DB then delete the record.
-	if m.store != nil {
-		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			if err := m.store.UpdateSessionStatus(ctx, sessionID, "terminated"); err != nil {
-				log.WithError(err).WithField("session_id", sessionID).Warn("Failed to update session status in DB")
-			}
-			if err := m.store.DeleteSession(ctx, sessionID); err != nil {
-				log.WithError(err).WithField("session_id", sessionID).Warn("Failed to delete session from DB")
-			}
-		}()
-	}
-
-	log.WithFields(log.Fields{
-		"session_id": sessionID,
-	}).Info("Session terminated")
-
+	m.finishTermination(session, ReasonUserTerminated)
 	return nil
 }
 
 // TerminateSessionForUser terminates a session after verifying ownership (H1).
 func (m *Manager) TerminateSessionForUser(sessionID, userID string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	session, exists := m.sessions[sessionID]
-	if !exists {
-		return fmt.Errorf("session not found")
+	if !exists || session.UserID != userID {
+		m.mu.Unlock()
+		return ErrSessionNotFound
 	}
+	delete(m.sessions, sessionID)
+	m.mu.Unlock()
 
-	if session.UserID != userID {
-		return fmt.Errorf("session not found")
-	}
+	m.finishTermination(session, ReasonUserTerminated)
+	return nil
+}
+
+// finishTermination cleans up a session already removed from the map. It runs
+// without the manager lock because stopping the process can take up to the
+// kill grace period.
+func (m *Manager) finishTermination(session *Session, reason string) {
+	sessionID := session.SessionID
 
-	if err := session.Cleanup(); err != nil {
+	if err := session.Terminate(reason); err != nil {
 		log.WithFields(log.Fields{
 			"session_id": sessionID,
 			"error":      err,
 		}).Error("Error cleaning up session")
 	}
 
-	delete(m.sessions, sessionID)
-
-	// Update status in DB then delete the record.
 	if m.store != nil {
-		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			if err := m.store.UpdateSessionStatus(ctx, sessionID, "terminated"); err != nil {
-				log.WithError(err).WithField("session_id", sessionID).Warn("Failed to update session status in DB")
-			}
-			if err := m.store.DeleteSession(ctx, sessionID); err != nil {
-				log.WithError(err).WithField("session_id", sessionID).Warn("Failed to delete session from DB")
-			}
-		}()
+		go m.recordEnd(session)
 	}
 
 	log.WithFields(log.Fields{
 		"session_id": sessionID,
 	}).Info("Session terminated")
+}
+
+// reapWhenEnded waits for a live session's agent to end on its own, then
+// removes the session and cleans it up like a terminated one, releasing its
+// workspace lock, recording and output writer. It returns early when the
+// session is terminated or detached instead.
+func (m *Manager) reapWhenEnded(session *Session) {
+	select {
+	case <-session.ended:
+	case <-session.done:
+		return
+	}
+
+	m.mu.Lock()
+	if m.sessions[session.SessionID] != session {
+		// Already taken out by whoever is terminating it.
+		m.mu.Unlock()
+		return
+	}
+	delete(m.sessions, session.SessionID)
+	m.mu.Unlock()
+
+	m.finishTermination(session, ReasonProcessExited)
+}
+
+// recordEnd records a session's termination in the DB. The record and its
+// output are kept for SESSION_RETENTION_DAYS and purged by the store janitor;
+// with no retention they are deleted now.
+func (m *Manager) recordEnd(session *Session) {
+	session.persistTermination()
+	if m.config.Retention.Days > 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.store.DeleteSession(ctx, session.SessionID); err != nil {
+		log.WithError(err).WithField("session_id", session.SessionID).Warn("Failed to delete session from DB")
+	}
+}
+
+// recordFailedInit stores a session that failed to initialize as failed with
+// reason init_failed, so it shows in the user's history like any ended
+// session. Like them it is kept for SESSION_RETENTION_DAYS, so with no
+// retention it is deleted right away and never shows.
+func (m *Manager) recordFailedInit(session *Session) {
+	if m.store == nil {
+		return
+	}
+	go func() {
+		m.saveSessionToDB(session)
+		m.recordEnd(session)
+	}()
+}
 
+// checkUserLimit fails when userID already has the maximum number of live
+// sessions (must be called with lock held).
+func (m *Manager) checkUserLimit(userID string) error {
+	activeSessions := 0
+	for _, s := range m.getUserSessions(userID) {
+		if s.Status == "active" || s.Status == "initializing" {
+			activeSessions++
+		}
+	}
+	for _, s := range m.pending {
+		if s.UserID == userID {
+			activeSessions++
+		}
+	}
+
+	if activeSessions >= m.config.Session.MaxPerUser {
+		return fmt.Errorf("maximum sessions per user (%d) reached", m.config.Session.MaxPerUser)
+	}
 	return nil
 }
 
+// admit moves a session reserved in m.pending into m.sessions once it has
+// initialized, or drops it when initialization failed.
+func (m *Manager) admit(session *Session, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.pending, session.SessionID)
+	if ok {
+		m.sessions[session.SessionID] = session
+	}
+}
+
 // getUserSessions returns all sessions for a specific user (must be called with lock held)
 func (m *Manager) getUserSessions(userID string) []*Session {
 	var userSessions []*Session
@@ -319,21 +529,34 @@ func (m *Manager) getUserSessions(userID string) []*Session {
 	return userSessions
 }
 
-// CleanupAll cleans up all sessions
+// CleanupAll cleans up all sessions. Supervised sessions are detached, leaving
+// their agents running for the next start to recover; the rest are stopped in
+// parallel so shutdown waits for at most one kill grace period.
 func (m *Manager) CleanupAll() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	for sessionID, session := range m.sessions {
-		if err := session.Cleanup(); err != nil {
-			log.WithFields(log.Fields{
-				"session_id": sessionID,
-				"error":      err,
-			}).Error("Error cleaning up session")
-		}
+	sessions := m.sessions
+	m.sessions = make(map[string]*Session)
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for sessionID, session := range sessions {
+		wg.Add(1)
+		go func(sessionID string, session *Session) {
+			defer wg.Done()
+			if session.detach() {
+				return
+			}
+			if err := session.Terminate(ReasonServerShutdown); err != nil {
+				log.WithFields(log.Fields{
+					"session_id": sessionID,
+					"error":      err,
+				}).Error("Error cleaning up session")
+			}
+			session.persistTermination()
+		}(sessionID, session)
 	}
+	wg.Wait()
 
-	m.sessions = make(map[string]*Session)
 	log.Info("All sessions cleaned up")
 }
 
@@ -363,12 +586,12 @@ func (m *Manager) StartTimeoutChecker(ctx context.Context) {
 }
 
 func (m *Manager) checkTimeouts() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	now := time.Now()
 	timeoutDuration := time.Duration(m.config.Session.TimeoutMinutes) * time.Minute
 
+	// Collect under the lock, clean up outside it.
+	m.mu.Lock()
+	var expired []*Session
 	for sessionID, session := range m.sessions {
 		if session.Status == "active" && now.Sub(session.LastActivity) > timeoutDuration {
 			log.WithFields(log.Fields{
@@ -377,27 +600,22 @@ func (m *Manager) checkTimeouts() {
 				"idle_time":  now.Sub(session.LastActivity),
 			}).Info("Session timed out")
 
-			if err := session.Cleanup(); err != nil {
-				log.WithFields(log.Fields{
-					"session_id": sessionID,
-					"error":      err,
-				}).Error("Error cleaning up timed out session")
-			}
-
+			expired = append(expired, session)
 			delete(m.sessions, sessionID)
+		}
+	}
+	m.mu.Unlock()
 
-			// Update DB status for timed-out session.
-			if m.store != nil {
-				sid := sessionID
-				go func() {
-					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-					defer cancel()
-					if err := m.store.UpdateSessionStatus(ctx, sid, "terminated"); err != nil {
-						log.WithError(err).WithField("session_id", sid).Warn("Failed to update timed-out session status in DB")
-					}
-				}()
-			}
+	for _, session := range expired {
+		if err := session.Terminate(ReasonIdleTimeout); err != nil {
+			log.WithFields(log.Fields{
+				"session_id": session.SessionID,
+				"error":      err,
+			}).Error("Error cleaning up timed out session")
 		}
+
+		// Update DB status for timed-out session.
+		go session.persistTermination()
 	}
 }
 
@@ -414,15 +632,53 @@ func (s *Session) Initialize(creds Credentials) error {
 
 	// Create workspace directory
 	if err := os.MkdirAll(s.WorkspacePath, 0755); err != nil {
+		s.Status = "failed"
+		s.TerminationReason = ReasonInitFailed
 		return fmt.Errorf("failed to create workspace: %w", err)
 	}
 
-	// Set up command
-	cmd := exec.Command("claude", "code")
+	// Populate from a template or git repository before the agent starts.
+	if s.source != nil {
+		if err := s.source.populate(s.WorkspacePath, creds.GitHubToken); err != nil {
+			s.Status = "failed"
+			s.TerminationReason = ReasonInitFailed
+			return err
+		}
+	}
+
+	// Set up command from the selected agent profile, wrapped in namespaces
+	// and a cgroup when sandboxing is enabled.
+	var cmd *exec.Cmd
+	if s.sandbox != nil {
+		var cg *cgroup
+		var err error
+		cmd, cg, err = sandboxCommand(*s.sandbox, s.agent, s.basePath, s.WorkspacePath, s.SessionID)
+		if err != nil {
+			s.Status = "failed"
+			s.TerminationReason = ReasonInitFailed
+			return fmt.Errorf("failed to prepare sandbox: %w", err)
+		}
+		s.cgroup = cg
+	} else {
+		cmd = exec.Command(s.agent.Command, s.agent.Args...)
+	}
 	cmd.Dir = s.WorkspacePath
 
-	// Set up environment using raw credentials (not stored)
-	cmd.Env = append(os.Environ(),
+	// Run the agent as leader of its own session and process group, with the
+	// PTY as its controlling terminal, so cleanup can signal the whole tree.
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+
+	// Set up environment: profile extras first so the session's own values
+	// below always take precedence. Raw credentials are not stored.
+	cmd.Env = os.Environ()
+	for key, value := range s.agent.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	}
+	cmd.Env = append(cmd.Env,
 		fmt.Sprintf("ANTHROPIC_API_KEY=%s", creds.AnthropicAPIKey),
 		fmt.Sprintf("HOME=%s", s.WorkspacePath),
 		fmt.Sprintf("PWD=%s", s.WorkspacePath),
@@ -432,18 +688,85 @@ func (s *Session) Initialize(creds Credentials) error {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("GITHUB_TOKEN=%s", creds.GitHubToken))
 	}
 
-	// Start the command with a PTY
-	ptmx, err := pty.Start(cmd)
+	// Mask the session's own credentials, should the agent echo them, along
+	// with the configured secret patterns.
+	if s.redaction != nil {
+		s.redactor = redact.New(s.redaction.Patterns, map[string]string{
+			"anthropic_api_key": creds.AnthropicAPIKey,
+			"github_token":      creds.GitHubToken,
+		}, s.redaction.WindowBytes)
+	}
+
+	// Start the command with a PTY sized like the screen emulator, so the
+	// agent lays out its output for the grid we render.
+	cols, rows := terminal.DefaultCols, terminal.DefaultRows
+	if s.screen != nil {
+		cols, rows = s.screen.Size()
+	}
+
+	// Sessions are only started with a working audit recording.
+	if s.recordingDir != "" {
+		rec, err := newRecorder(s.recordingDir, s.SessionID, s.UserID, cols, rows, s.recordingKey, s.dbStore, s.recordingDB)
+		if err != nil {
+			s.Status = "failed"
+			s.TerminationReason = ReasonInitFailed
+			if s.cgroup != nil {
+				_ = s.cgroup.remove()
+				s.cgroup = nil
+			}
+			return err
+		}
+		s.recorder = rec
+	}
+
+	// A supervised agent is started by its supervisor, which keeps it and the
+	// PTY across service restarts.
+	size := &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)}
+	var ptmx *os.File
+	var sc *supervisorConn
+	var err error
+	if s.supervisorDir != "" {
+		socket := filepath.Join(s.supervisorDir, s.SessionID+".sock")
+		sc, err = startSupervised(cmd, s.cgroup, socket, size)
+		if err == nil {
+			ptmx, s.pid = sc.pty, sc.hello.Pid
+			s.supervisor, s.supervisorSocket = sc, socket
+		}
+	} else {
+		ptmx, err = pty.StartWithSize(cmd, size)
+		if err == nil {
+			s.pid = cmd.Process.Pid
+			s.Cmd = cmd
+		}
+	}
 	if err != nil {
+		s.Status = "failed"
+		s.TerminationReason = ReasonInitFailed
+		if s.cgroup != nil {
+			_ = s.cgroup.remove()
+			s.cgroup = nil
+		}
+		if s.recorder != nil {
+			s.recorder.discard()
+			s.recorder = nil
+		}
 		return fmt.Errorf("failed to start PTY: %w", err)
 	}
+	if s.cgroup != nil {
+		s.cgroup.started()
+	}
 
 	s.PTY = ptmx
-	s.Cmd = cmd
 	s.Status = "active"
+	s.exited = make(chan struct{})
 
 	// H2: Start output reader with done channel for clean exit
 	go s.readOutput()
+	if sc != nil {
+		go s.watchSupervisor(sc, s.exited)
+	} else {
+		go s.waitProcess(cmd, s.exited)
+	}
 
 	log.WithFields(log.Fields{
 		"session_id": s.SessionID,
@@ -465,7 +788,7 @@ func (s *Session) readOutput() {
 
 		n, err := s.PTY.Read(buffer)
 		if err != nil {
-			if err != io.EOF {
+			if err != io.EOF && !s.isDetached() {
 				log.WithFields(log.Fields{
 					"session_id": s.SessionID,
 					"error":      err,
@@ -479,30 +802,192 @@ func (s *Session) readOutput() {
 		}
 	}
 
+	// A detached agent is still running; the next start takes over.
+	if s.isDetached() {
+		return
+	}
+
+	// The PTY normally hits EOF as the agent exits; wait briefly for the exit
+	// status so the status and termination reason change together.
+	s.mu.RLock()
+	exited := s.exited
+	s.mu.RUnlock()
+	if exited != nil {
+		select {
+		case <-exited:
+		case <-time.After(processReapTimeout):
+		}
+	}
+
 	s.mu.Lock()
+	s.flushRedactor()
 	s.Status = "terminated"
+	s.notifyOutput()
+	s.closeSubscribers()
 	s.mu.Unlock()
+	close(s.ended)
 
 	log.WithFields(log.Fields{
 		"session_id": s.SessionID,
 	}).Info("Output reader terminated")
 }
 
-// handleOutput processes output from the PTY
+// waitProcess reaps the agent and records how it exited, then closes exited.
+func (s *Session) waitProcess(cmd *exec.Cmd, exited chan struct{}) {
+	_ = cmd.Wait()
+	code, signal := exitStatus(cmd.ProcessState)
+	s.recordExit(code, signal, exited)
+}
+
+// watchSupervisor waits for the supervisor to report the agent's exit, then
+// records it and closes exited. Losing the supervisor counts as an exit,
+// unless the session was detached on purpose.
+func (s *Session) watchSupervisor(sc *supervisorConn, exited chan struct{}) {
+	msg := sc.hello
+	for !msg.Exited {
+		var err error
+		if msg, err = sc.next(); err != nil {
+			if s.isDetached() {
+				return
+			}
+			log.WithError(err).WithField("session_id", s.SessionID).Warn("Lost connection to session supervisor")
+			msg = supervisorMsg{Exited: true, ExitCode: -1}
+		}
+	}
+	s.recordExit(msg.ExitCode, msg.ExitSignal, exited)
+}
+
+// recordExit records how the agent exited and closes exited.
+func (s *Session) recordExit(code int, signal string, exited chan struct{}) {
+	// A reason is already set when the exit was caused by terminating the
+	// session; otherwise the agent ended on its own.
+	s.mu.Lock()
+	s.exitCode = code
+	s.exitSignal = signal
+	selfExited := s.TerminationReason == ""
+	if signal != "" {
+		s.setTerminationReason(ReasonKilledBySignal)
+	} else {
+		s.setTerminationReason(ReasonProcessExited)
+	}
+	reason := s.TerminationReason
+	s.mu.Unlock()
+	close(exited)
+
+	log.WithFields(log.Fields{
+		"session_id":  s.SessionID,
+		"exit_code":   code,
+		"exit_signal": signal,
+		"reason":      reason,
+	}).Info("Session process exited")
+
+	if selfExited {
+		s.persistTermination()
+	}
+}
+
+// isDetached reports whether detach released the session.
+func (s *Session) isDetached() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.detached
+}
+
+// setTerminationReason records why the session ended; the first reason set
+// wins (must be called with lock held).
+func (s *Session) setTerminationReason(reason string) {
+	if s.TerminationReason == "" {
+		s.TerminationReason = reason
+	}
+}
+
+// hasExited reports whether the agent has been reaped (must be called with
+// lock held).
+func (s *Session) hasExited() bool {
+	if s.exited == nil {
+		return false
+	}
+	select {
+	case <-s.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// addTerminationFields adds the termination reason and exit status, once
+// known, to a status map (must be called with lock held).
+func (s *Session) addTerminationFields(fields map[string]interface{}) {
+	if s.TerminationReason != "" {
+		fields["termination_reason"] = s.TerminationReason
+	}
+	if s.hasExited() {
+		fields["exit_code"] = s.exitCode
+		if s.exitSignal != "" {
+			fields["exit_signal"] = s.exitSignal
+		}
+	}
+}
+
+// exitStatus returns the exit code, or -1 and the signal name when the
+// process was killed by a signal.
+func exitStatus(state *os.ProcessState) (int, string) {
+	if state == nil {
+		return -1, ""
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return -1, unix.SignalName(ws.Signal())
+	}
+	return state.ExitCode(), ""
+}
+
+// ExitStatus reports the agent's exit code and terminating signal (empty if
+// it exited normally). exited is false while the process is still running.
+func (s *Session) ExitStatus() (code int, signal string, exited bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.hasExited() {
+		return 0, "", false
+	}
+	return s.exitCode, s.exitSignal, true
+}
+
+// handleOutput processes output from the PTY. Secrets are masked before the
+// output reaches any buffer, recording, subscriber or the DB.
 func (s *Session) handleOutput(data string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.LastActivity = time.Now()
+
+	if s.redactor != nil {
+		data = s.redactOutput(data)
+		if data == "" {
+			return
+		}
+	}
+	s.appendOutput(data)
+}
+
+// appendOutput delivers a chunk of (redacted) output (must be called with
+// lock held).
+func (s *Session) appendOutput(data string) {
 	now := time.Now()
-	s.LastActivity = now
 
 	// Add to output buffer
+	s.lastSeq++
 	chunk := OutputChunk{
+		Seq:       s.lastSeq,
 		Timestamp: now.Format(time.RFC3339),
 		Data:      data,
 	}
 
 	s.OutputBuffer = append(s.OutputBuffer, chunk)
+	if s.screen != nil {
+		s.screen.Write([]byte(data))
+	}
+	s.recorder.output(data)
 
 	// H8: Use configurable buffer size instead of hardcoded 100
 	maxSize := s.outputBufferSize
@@ -513,19 +998,21 @@ func (s *Session) handleOutput(data string) {
 		s.OutputBuffer = s.OutputBuffer[len(s.OutputBuffer)-maxSize:]
 	}
 
-	// Persist output chunk to DB (async, never block PTY).
-	if s.dbStore != nil {
-		sid := s.SessionID
-		ts := now
-		d := data
-		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-			defer cancel()
-			if err := s.dbStore.SaveOutputChunk(ctx, sid, ts, d); err != nil {
-				log.WithError(err).WithField("session_id", sid).Warn("Failed to save output chunk to DB")
-			}
-		}()
+	// Wake long-poll waiters, then fan out to live subscribers without ever
+	// blocking the PTY reader.
+	s.notifyOutput()
+	for ch := range s.subscribers {
+		select {
+		case ch <- chunk:
+		default:
+			log.WithField("session_id", s.SessionID).Warn("Output subscriber too slow; disconnecting")
+			delete(s.subscribers, ch)
+			close(ch)
+		}
 	}
+
+	// Persist to the DB in batches; never blocks the PTY reader.
+	s.persister.add(store.OutputChunk{Timestamp: now, Data: data})
 }
 
 // sanitizeCommand filters dangerous control characters from input (C3).
@@ -571,6 +1058,7 @@ func (s *Session) SendCommand(command string) error {
 	if err != nil {
 		return fmt.Errorf("failed to write command: %w", err)
 	}
+	s.recorder.input(command)
 
 	// Persist last activity to DB (async, never block command path).
 	if s.dbStore != nil {
@@ -608,6 +1096,127 @@ func (s *Session) GetOutput(clear bool) []OutputChunk {
 	return output
 }
 
+// GetOutputAfter returns the buffered chunks newer than the given cursor
+// without modifying the buffer, so multiple consumers can read independently.
+func (s *Session) GetOutputAfter(after uint64) OutputPage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.outputAfter(after)
+}
+
+// outputAfter builds an OutputPage for the cursor (must be called with lock held).
+func (s *Session) outputAfter(after uint64) OutputPage {
+	// Seq of the oldest chunk still available to readers.
+	oldest := s.lastSeq + 1
+	if len(s.OutputBuffer) > 0 {
+		oldest = s.OutputBuffer[0].Seq
+	}
+
+	page := OutputPage{
+		Chunks:     make([]OutputChunk, 0),
+		NextCursor: s.lastSeq,
+		Gap:        after+1 < oldest,
+	}
+
+	// A cursor from before a service restart can be ahead of the newest
+	// chunk of the recovered session when output was not stored.
+	if after > s.lastSeq {
+		after = 0
+		page.Gap = true
+	}
+
+	// Chunks are ordered by Seq, so skip straight past the cursor.
+	start := 0
+	if after >= oldest {
+		start = int(after - oldest + 1)
+	}
+	if start < len(s.OutputBuffer) {
+		page.Chunks = append(page.Chunks, s.OutputBuffer[start:]...)
+	}
+
+	return page
+}
+
+// WaitForOutput blocks until output newer than the cursor is available, the
+// session terminates, or ctx is done, then returns the same page as
+// GetOutputAfter. It returns immediately when output is already available or
+// the cursor has fallen behind the ring buffer.
+func (s *Session) WaitForOutput(ctx context.Context, after uint64) OutputPage {
+	for {
+		s.mu.Lock()
+		page := s.outputAfter(after)
+		if len(page.Chunks) > 0 || page.Gap || s.Status == "terminated" {
+			s.mu.Unlock()
+			return page
+		}
+		if s.outputReady == nil {
+			s.outputReady = make(chan struct{})
+		}
+		ready := s.outputReady
+		done := s.done
+		s.mu.Unlock()
+
+		select {
+		case <-ready:
+			// New output or termination; re-check under the lock.
+		case <-done:
+			return s.GetOutputAfter(after)
+		case <-ctx.Done():
+			return s.GetOutputAfter(after)
+		}
+	}
+}
+
+// notifyOutput wakes every WaitForOutput caller (must be called with lock held).
+func (s *Session) notifyOutput() {
+	if s.outputReady != nil {
+		close(s.outputReady)
+		s.outputReady = nil
+	}
+}
+
+// Subscribe registers a live output subscriber. It returns the buffered output
+// newer than the given cursor, a channel that receives every subsequent chunk,
+// and a function that must be called to unsubscribe. The channel is closed
+// when the session terminates or the subscriber falls too far behind.
+func (s *Session) Subscribe(after uint64) (OutputPage, <-chan OutputChunk, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backlog := s.outputAfter(after)
+
+	ch := make(chan OutputChunk, subscriberBufferSize)
+	if s.Status == "terminated" {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan OutputChunk]struct{})
+	}
+	s.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return backlog, ch, unsubscribe
+}
+
+// closeSubscribers closes every live output subscriber (must be called with lock held).
+func (s *Session) closeSubscribers() {
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
 // Resize resizes the PTY
 func (s *Session) Resize(cols, rows int) error {
 	s.mu.Lock()
@@ -617,36 +1226,104 @@ func (s *Session) Resize(cols, rows int) error {
 		return fmt.Errorf("PTY not initialized")
 	}
 
+	if cols < 1 || cols > terminal.MaxCols || rows < 1 || rows > terminal.MaxRows {
+		return fmt.Errorf("invalid size %dx%d (max %dx%d)", cols, rows, terminal.MaxCols, terminal.MaxRows)
+	}
+
 	if err := pty.Setsize(s.PTY, &pty.Winsize{
 		Rows: uint16(rows),
 		Cols: uint16(cols),
 	}); err != nil {
 		return fmt.Errorf("failed to resize PTY: %w", err)
 	}
+	if s.screen != nil {
+		s.screen.Resize(cols, rows)
+	}
+	s.recorder.resize(cols, rows)
 
 	return nil
 }
 
+// Screen returns a rendered snapshot of the session's terminal.
+func (s *Session) Screen(opts terminal.SnapshotOptions) terminal.Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.screen == nil {
+		return terminal.New(terminal.DefaultCols, terminal.DefaultRows, 0).Snapshot(opts)
+	}
+	return s.screen.Snapshot(opts)
+}
+
 // GetStatus returns the current session status
 func (s *Session) GetStatus() map[string]interface{} {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return map[string]interface{}{
+	status := map[string]interface{}{
 		"session_id":         s.SessionID,
 		"user_id":            s.UserID,
 		"status":             s.Status,
 		"workspace_path":     s.WorkspacePath,
+		"workspace_type":     s.WorkspaceType,
+		"profile":            s.Profile,
 		"last_activity":      s.LastActivity.Format(time.RFC3339),
 		"created":            s.Created.Format(time.RFC3339),
 		"output_buffer_size": len(s.OutputBuffer),
 	}
+	if s.Label != "" {
+		status["label"] = s.Label
+	}
+	if s.ResumedFrom != "" {
+		status["resumed_from"] = s.ResumedFrom
+	}
+	if s.persister != nil {
+		status["output_unpersisted"] = s.persister.lostChunks()
+	}
+	if s.redactor != nil {
+		status["redaction_count"] = s.redactor.Total()
+		status["redactions"] = s.redactor.Counts()
+	}
+	s.addTerminationFields(status)
+	return status
+}
+
+// Terminate records why the session is ending and cleans it up.
+func (s *Session) Terminate(reason string) error {
+	s.mu.Lock()
+	s.setTerminationReason(reason)
+	s.mu.Unlock()
+
+	return s.Cleanup()
+}
+
+// persistTermination records the final status, termination reason and exit
+// status in the DB. Errors are logged, never returned.
+func (s *Session) persistTermination() {
+	if s.dbStore == nil {
+		return
+	}
+
+	s.mu.RLock()
+	reason := s.TerminationReason
+	var exitCode *int
+	if s.hasExited() && s.exitSignal == "" {
+		code := s.exitCode
+		exitCode = &code
+	}
+	exitSignal := s.exitSignal
+	s.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.dbStore.MarkSessionTerminated(ctx, s.SessionID, reason, exitCode, exitSignal); err != nil {
+		log.WithError(err).WithField("session_id", s.SessionID).Warn("Failed to record session termination in DB")
+	}
 }
 
 // Cleanup cleans up the session resources. H2: signals done channel to stop readOutput.
 func (s *Session) Cleanup() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
 	log.WithFields(log.Fields{
 		"session_id": s.SessionID,
@@ -660,15 +1337,18 @@ func (s *Session) Cleanup() error {
 		close(s.done)
 	}
 
-	// Kill the process
-	if s.Cmd != nil && s.Cmd.Process != nil {
-		if err := s.Cmd.Process.Kill(); err != nil {
-			log.WithFields(log.Fields{
-				"session_id": s.SessionID,
-				"error":      err,
-			}).Warn("Error killing process")
-		}
-	}
+	pid, exited, cg, grace := s.pid, s.exited, s.cgroup, s.killGrace
+	s.mu.Unlock()
+
+	// Stop the process tree without holding the lock: waitProcess needs it
+	// to record the exit status.
+	s.terminateProcess(pid, exited, cg, grace)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The supervisor exits once it has reported the agent's exit.
+	s.supervisor.close()
 
 	// Close PTY
 	if s.PTY != nil {
@@ -680,35 +1360,162 @@ func (s *Session) Cleanup() error {
 		}
 	}
 
-	// Clean up workspace (if isolated type)
-	if err := os.RemoveAll(s.WorkspacePath); err != nil {
+	// Clean up workspace (if isolated type); persistent workspaces are kept
+	// for the user's next session and only unlocked.
+	if s.WorkspaceType == WorkspacePersistent {
+		releaseWorkspaceLock(s.workspaceLock)
+		s.workspaceLock = nil
+	} else if err := os.RemoveAll(s.WorkspacePath); err != nil {
 		log.WithFields(log.Fields{
 			"session_id": s.SessionID,
 			"error":      err,
 		}).Warn("Error removing workspace")
 	}
 
+	s.flushRedactor()
+	s.recorder.close()
+	s.persister.close()
+
+	if s.cgroup != nil {
+		if err := s.cgroup.remove(); err != nil {
+			log.WithFields(log.Fields{
+				"session_id": s.SessionID,
+				"error":      err,
+			}).Warn("Error removing session cgroup")
+		}
+		s.cgroup = nil
+	}
+
 	s.Status = "terminated"
+	s.notifyOutput()
+	s.closeSubscribers()
 
 	return nil
 }
 
+// terminateProcess stops the agent and everything it spawned. The process
+// group gets SIGTERM and grace to exit, then SIGKILL. Sandboxed sessions also
+// kill their cgroup, which catches processes that left the group (setsid).
+func (s *Session) terminateProcess(pgid int, exited <-chan struct{}, cg *cgroup, grace time.Duration) {
+	if pgid == 0 {
+		return
+	}
+	if exited == nil {
+		// Not started through Initialize; nothing is reaping it.
+		_ = syscall.Kill(pgid, syscall.SIGKILL)
+		return
+	}
+	// Setsid makes the agent its own process group leader.
+
+	select {
+	case <-exited:
+		// Already reaped; still sweep up anything left in its group.
+	default:
+		if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+			log.WithFields(log.Fields{
+				"session_id": s.SessionID,
+				"error":      err,
+			}).Warn("Error sending SIGTERM to process group")
+		}
+		timer := time.NewTimer(grace)
+		select {
+		case <-exited:
+		case <-timer.C:
+			log.WithFields(log.Fields{
+				"session_id": s.SessionID,
+				"grace":      grace,
+			}).Warn("Session process did not exit after SIGTERM; killing")
+		}
+		timer.Stop()
+	}
+
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		log.WithFields(log.Fields{
+			"session_id": s.SessionID,
+			"error":      err,
+		}).Warn("Error killing process group")
+	}
+	if cg != nil {
+		if err := cg.kill(); err != nil {
+			log.WithFields(log.Fields{
+				"session_id": s.SessionID,
+				"error":      err,
+			}).Warn("Error killing session cgroup")
+		}
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(processReapTimeout):
+		log.WithField("session_id", s.SessionID).Warn("Timed out waiting for session process to be reaped")
+	}
+}
+
+// detach releases the session at shutdown without stopping a supervised
+// agent: its output is flushed and the PTY, recording and workspace lock are
+// let go, while the workspace and the active DB record stay for
+// RecoverSessions. It reports false, doing nothing, for sessions that have to
+// be terminated instead.
+func (s *Session) detach() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.supervisor == nil || s.hasExited() {
+		return false
+	}
+	s.detached = true
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+
+	if s.PTY != nil {
+		s.PTY.Close()
+	}
+	s.supervisor.close()
+	releaseWorkspaceLock(s.workspaceLock)
+	s.workspaceLock = nil
+
+	s.flushRedactor()
+	s.recorder.close()
+	s.persister.close()
+	s.notifyOutput()
+	s.closeSubscribers()
+
+	log.WithFields(log.Fields{
+		"session_id": s.SessionID,
+		"pid":        s.pid,
+	}).Info("Session detached; agent left running under its supervisor")
+	return true
+}
+
 // MarshalJSON implements json.Marshaler
 func (s *Session) MarshalJSON() ([]byte, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return json.Marshal(map[string]interface{}{
+	fields := map[string]interface{}{
 		"session_id":     s.SessionID,
 		"user_id":        s.UserID,
 		"workspace_path": s.WorkspacePath,
+		"workspace_type": s.WorkspaceType,
+		"profile":        s.Profile,
 		"status":         s.Status,
 		"last_activity":  s.LastActivity.Format(time.RFC3339),
 		"created":        s.Created.Format(time.RFC3339),
-	})
+	}
+	if s.Label != "" {
+		fields["label"] = s.Label
+	}
+	if s.ResumedFrom != "" {
+		fields["resumed_from"] = s.ResumedFrom
+	}
+	s.addTerminationFields(fields)
+	return json.Marshal(fields)
 }
 
-// saveSessionToDB persists a session record to PostgreSQL.
+// saveSessionToDB persists a session record to the store.
 // Called asynchronously; errors are logged, never returned to callers.
 func (m *Manager) saveSessionToDB(s *Session) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -725,8 +1532,13 @@ func (m *Manager) saveSessionToDB(s *Session) {
 		SessionID:            s.SessionID,
 		UserID:               s.UserID,
 		WorkspacePath:        s.WorkspacePath,
+		WorkspaceType:        s.WorkspaceType,
+		Profile:              s.Profile,
+		Label:                s.Label,
 		Status:               s.Status,
 		EncryptedCredentials: credsJSON,
+		SupervisorSocket:     s.supervisorSocket,
+		ResumedFrom:          s.ResumedFrom,
 		LastActivity:         s.LastActivity,
 		CreatedAt:            s.Created,
 	}
@@ -736,23 +1548,3 @@ func (m *Manager) saveSessionToDB(s *Session) {
 		log.WithError(err).WithField("session_id", rec.SessionID).Warn("Failed to save session to DB")
 	}
 }
-
-// RecoverSessions marks stale sessions as terminated on startup.
-// This should be called once during initialization.
-func (m *Manager) RecoverSessions(ctx context.Context) {
-	if m.store == nil {
-		return
-	}
-
-	count, err := m.store.MarkStaleSessionsTerminated(ctx)
-	if err != nil {
-		log.WithError(err).Error("Failed to mark stale sessions as terminated")
-		return
-	}
-
-	if count > 0 {
-		log.WithField("count", count).Info("Marked stale sessions as terminated on startup")
-	} else {
-		log.Info("No stale sessions found on startup")
-	}
-}
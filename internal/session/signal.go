@@ -0,0 +1,85 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnknownSignal is returned by SendSignal for names outside the allowlist.
+var ErrUnknownSignal = errors.New("unknown signal")
+
+// signalKeys maps named keys to the single control byte written to the PTY,
+// exactly as if typed. The line discipline turns interrupt and suspend into
+// SIGINT/SIGTSTP for the foreground job; the agent reads eof and escape itself.
+var signalKeys = map[string]byte{
+	"interrupt": 0x03, // Ctrl-C
+	"eof":       0x04, // Ctrl-D
+	"suspend":   0x1a, // Ctrl-Z
+	"escape":    0x1b, // Esc
+}
+
+// processSignals are sent directly to the agent's process group, for agents
+// that have put the terminal in raw mode and ignore the keys above.
+var processSignals = map[string]syscall.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGKILL": syscall.SIGKILL,
+}
+
+// SignalNames returns the names accepted by SendSignal, sorted.
+func SignalNames() []string {
+	names := make([]string, 0, len(signalKeys)+len(processSignals))
+	for name := range signalKeys {
+		names = append(names, name)
+	}
+	for name := range processSignals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SendSignal delivers a named key through the PTY or a signal to the agent's
+// process group. Only the fixed bytes above are ever written, so this does not
+// widen the control character filtering applied to SendCommand (C3).
+func (s *Session) SendSignal(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Status != "active" {
+		return fmt.Errorf("session is not active (status: %s)", s.Status)
+	}
+
+	if key, ok := signalKeys[strings.ToLower(name)]; ok {
+		if _, err := s.PTY.Write([]byte{key}); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		s.recorder.input(string(key))
+	} else if sig, ok := processSignals[strings.ToUpper(name)]; ok {
+		if s.pid == 0 {
+			return fmt.Errorf("session has no running process")
+		}
+		// Setsid makes the agent its own process group leader.
+		if err := syscall.Kill(-s.pid, sig); err != nil {
+			return fmt.Errorf("failed to send %s: %w", name, err)
+		}
+	} else {
+		return fmt.Errorf("%w %q: must be one of %s", ErrUnknownSignal, name, strings.Join(SignalNames(), ", "))
+	}
+
+	s.LastActivity = time.Now()
+
+	log.WithFields(log.Fields{
+		"session_id": s.SessionID,
+		"signal":     name,
+	}).Info("Signal sent to session")
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// supervisorHelloTimeout bounds how long attaching waits for a supervisor to
+// answer.
+const supervisorHelloTimeout = 5 * time.Second
+
+// supervisorMsg is sent by a supervisor to the attached service: once on
+// connect, carrying the PTY master, and again when the agent exits.
+// Supervisors outlive the service binary that started them, so fields may
+// only ever be added.
+type supervisorMsg struct {
+	Pid        int    `json:"pid,omitempty"`
+	Exited     bool   `json:"exited,omitempty"`
+	ExitCode   int    `json:"exit_code,omitempty"`
+	ExitSignal string `json:"exit_signal,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// supervisorConn is the service's connection to a session supervisor.
+type supervisorConn struct {
+	conn  *net.UnixConn
+	pty   *os.File      // PTY master received in the hello
+	hello supervisorMsg // agent state when attached
+}
+
+// attachSupervisor connects to the supervisor listening on socket and
+// receives the agent's PTY. The socket's directory must be private to the
+// service, and the hello is only trusted from a supervisor running as the
+// service's uid that is the parent of the agent it names.
+func attachSupervisor(socket string) (*supervisorConn, error) {
+	if err := checkPrivateDir(filepath.Dir(socket)); err != nil {
+		return nil, fmt.Errorf("refusing supervisor socket: %w", err)
+	}
+	conn, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: socket, Net: "unixpacket"})
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &supervisorConn{conn: conn}
+	conn.SetReadDeadline(time.Now().Add(supervisorHelloTimeout))
+	hello, fds, err := sc.read()
+	conn.SetReadDeadline(time.Time{})
+	if err == nil && len(fds) != 1 {
+		err = errors.New("supervisor sent no PTY")
+	}
+	if err == nil && hello.Error != "" {
+		err = errors.New(hello.Error)
+	}
+	if err == nil {
+		err = verifySupervisor(conn, hello)
+	}
+	if err != nil {
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+		conn.Close()
+		return nil, fmt.Errorf("failed to attach to supervisor: %w", err)
+	}
+
+	// Non-blocking, the PTY joins the runtime poller, so closing it
+	// interrupts a pending Read.
+	if err := unix.SetNonblock(fds[0], true); err != nil {
+		unix.Close(fds[0])
+		conn.Close()
+		return nil, fmt.Errorf("failed to attach to supervisor: %w", err)
+	}
+	sc.hello = hello
+	sc.pty = os.NewFile(uintptr(fds[0]), "/dev/ptmx")
+	return sc, nil
+}
+
+// next waits for the supervisor's next message.
+func (sc *supervisorConn) next() (supervisorMsg, error) {
+	msg, fds, err := sc.read()
+	for _, fd := range fds {
+		unix.Close(fd)
+	}
+	return msg, err
+}
+
+// read receives one message and any file descriptors sent with it.
+func (sc *supervisorConn) read() (supervisorMsg, []int, error) {
+	var msg supervisorMsg
+	buf := make([]byte, 4096)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := sc.conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return msg, nil, err
+	}
+	if n == 0 {
+		return msg, nil, net.ErrClosed
+	}
+
+	var fds []int
+	if oobn > 0 {
+		cmsgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return msg, nil, err
+		}
+		for _, cmsg := range cmsgs {
+			if rights, err := unix.ParseUnixRights(&cmsg); err == nil {
+				fds = append(fds, rights...)
+			}
+		}
+	}
+	return msg, fds, json.Unmarshal(buf[:n], &msg)
+}
+
+// close disconnects from the supervisor. The agent keeps running.
+func (sc *supervisorConn) close() {
+	if sc != nil {
+		sc.conn.Close()
+	}
+}
@@ -0,0 +1,388 @@
+//go:build linux
+
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// supervisorName is the argv[0] the service re-executes itself with to run a
+// session supervisor (see runSupervisor).
+const supervisorName = "claude-terminal-supervisor"
+
+// File descriptors a supervisor inherits from startSupervised.
+const (
+	supervisorSpecFD   = 3 // pipe carrying the supervisorSpec
+	supervisorPTYFD    = 4 // PTY master
+	supervisorTTYFD    = 5 // PTY slave, the agent's terminal
+	supervisorCgroupFD = 6 // session cgroup directory, when sandboxed with limits
+)
+
+// supervisorStartTimeout bounds how long the service waits for a new
+// supervisor to report that the agent started.
+const supervisorStartTimeout = 10 * time.Second
+
+// supervisorOrphanTimeout is how long a supervisor keeps its agent running
+// with no service attached before killing it.
+const supervisorOrphanTimeout = time.Hour
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == supervisorName {
+		runSupervisor()
+	}
+}
+
+// supervisorSpec is the agent command a supervisor starts. It travels over a
+// pipe rather than argv so the credentials in Env never show up in the
+// process list.
+type supervisorSpec struct {
+	Socket string               `json:"socket"`
+	Path   string               `json:"path"`
+	Args   []string             `json:"args"`
+	Env    []string             `json:"env"`
+	Dir    string               `json:"dir"`
+	Attr   *syscall.SysProcAttr `json:"attr"`
+}
+
+// startSupervised starts cmd on a new PTY of the given size under a session
+// supervisor listening on socket, and attaches to it. The supervisor is the
+// agent's parent and holds the PTY master, so the agent keeps running when
+// the service exits and a restarted service can attach again. The caller
+// still owns cg.
+func startSupervised(cmd *exec.Cmd, cg *cgroup, socket string, size *pty.Winsize) (*supervisorConn, error) {
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	if err := ensurePrivateDir(filepath.Dir(socket)); err != nil {
+		return nil, fmt.Errorf("failed to create supervisor directory: %w", err)
+	}
+
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer ptmx.Close()
+	defer tty.Close()
+	if err := pty.Setsize(ptmx, size); err != nil {
+		return nil, err
+	}
+
+	specR, specW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer specR.Close()
+	defer specW.Close()
+	statusR, statusW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer statusR.Close()
+	defer statusW.Close()
+
+	files := []*os.File{specR, ptmx, tty}
+	if cg != nil && cg.dir != nil {
+		files = append(files, cg.dir)
+	}
+	sup := &exec.Cmd{
+		Path:       "/proc/self/exe",
+		Args:       []string{supervisorName},
+		ExtraFiles: files,
+		Stdout:     statusW,
+		// A session of its own keeps signals aimed at the service's
+		// process group away from the supervisor.
+		SysProcAttr: &syscall.SysProcAttr{Setsid: true},
+	}
+	if err := sup.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start supervisor: %w", err)
+	}
+	// Reap it should it exit while the service is still running.
+	go sup.Wait()
+	specR.Close()
+	statusW.Close()
+
+	err = json.NewEncoder(specW).Encode(supervisorSpec{
+		Socket: socket,
+		Path:   cmd.Path,
+		Args:   cmd.Args,
+		Env:    cmd.Env,
+		Dir:    cmd.Dir,
+		Attr:   cmd.SysProcAttr,
+	})
+	specW.Close()
+	if err != nil {
+		_ = sup.Process.Kill()
+		return nil, fmt.Errorf("failed to send supervisor spec: %w", err)
+	}
+
+	var started supervisorMsg
+	statusR.SetReadDeadline(time.Now().Add(supervisorStartTimeout))
+	if err := json.NewDecoder(statusR).Decode(&started); err != nil {
+		_ = sup.Process.Kill()
+		return nil, fmt.Errorf("supervisor did not start: %w", err)
+	}
+	if started.Error != "" {
+		return nil, errors.New(started.Error)
+	}
+
+	sc, err := attachSupervisor(socket)
+	if err == nil && sc.hello.Pid != started.Pid {
+		sc.pty.Close()
+		sc.close()
+		err = fmt.Errorf("supervisor socket reports agent pid %d, not %d", sc.hello.Pid, started.Pid)
+	}
+	if err != nil {
+		if started.Pid > 1 {
+			_ = syscall.Kill(-started.Pid, syscall.SIGKILL)
+		}
+		_ = sup.Process.Kill()
+		return nil, err
+	}
+	return sc, nil
+}
+
+// verifySupervisor checks that the peer on conn may speak for the agent its
+// hello names before the service signals that pid: the peer must run as the
+// service's uid and, while the agent runs, be its parent. Pids 0 and 1 are
+// never accepted, since signalling their "group" would reach every process.
+func verifySupervisor(conn *net.UnixConn, hello supervisorMsg) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if credErr != nil {
+		return fmt.Errorf("failed to identify supervisor: %w", credErr)
+	}
+	if int(cred.Uid) != os.Geteuid() {
+		return fmt.Errorf("supervisor runs as uid %d, not the service's uid %d", cred.Uid, os.Geteuid())
+	}
+	if hello.Pid <= 1 {
+		return fmt.Errorf("supervisor reported invalid agent pid %d", hello.Pid)
+	}
+	// An agent that has exited has been reaped, and only its exit status
+	// is used.
+	if hello.Exited {
+		return nil
+	}
+	ppid, err := parentPid(hello.Pid)
+	if err != nil {
+		return fmt.Errorf("failed to check agent pid %d: %w", hello.Pid, err)
+	}
+	if ppid != int(cred.Pid) {
+		return fmt.Errorf("agent pid %d is not a child of supervisor pid %d", hello.Pid, cred.Pid)
+	}
+	return nil
+}
+
+// parentPid reads pid's parent from /proc/<pid>/stat. The command name in
+// the second field may hold spaces and parentheses, so parsing starts after
+// its last ')'.
+func parentPid(pid int) (int, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return 0, errors.New("malformed stat")
+	}
+	// After the name come the state and then the parent pid.
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 2 {
+		return 0, errors.New("malformed stat")
+	}
+	return strconv.Atoi(fields[1])
+}
+
+// runSupervisor starts the agent described on the spec pipe, reports its pid
+// (or the failure) on stdout and then supervises it. It never returns.
+func runSupervisor() {
+	fail := func(err error) {
+		_ = json.NewEncoder(os.Stdout).Encode(supervisorMsg{Error: "supervisor: " + err.Error()})
+		os.Exit(1)
+	}
+
+	// Inherited descriptors must not leak into the agent.
+	for fd := supervisorSpecFD; fd <= supervisorCgroupFD; fd++ {
+		unix.CloseOnExec(fd)
+	}
+
+	specFile := os.NewFile(supervisorSpecFD, "spec")
+	var spec supervisorSpec
+	if err := json.NewDecoder(specFile).Decode(&spec); err != nil {
+		fail(fmt.Errorf("invalid spec: %w", err))
+	}
+	specFile.Close()
+	tty := os.NewFile(supervisorTTYFD, "/dev/tty")
+
+	// Hangups and interrupts are meant for the agent, not its supervisor.
+	signal.Ignore(syscall.SIGHUP, syscall.SIGINT)
+
+	_ = os.Remove(spec.Socket)
+	ln, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: spec.Socket, Net: "unixpacket"})
+	if err != nil {
+		fail(err)
+	}
+	if err := os.Chmod(spec.Socket, 0600); err != nil {
+		ln.Close()
+		fail(err)
+	}
+
+	// Run the agent as leader of its own session and process group, with the
+	// PTY as its controlling terminal, exactly as an unsupervised agent.
+	attr := spec.Attr
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	attr.Setsid = true
+	attr.Setctty = true
+	attr.Ctty = 0
+	if attr.UseCgroupFD {
+		attr.CgroupFD = supervisorCgroupFD
+	}
+	cmd := &exec.Cmd{
+		Path:        spec.Path,
+		Args:        spec.Args,
+		Env:         spec.Env,
+		Dir:         spec.Dir,
+		Stdin:       tty,
+		Stdout:      tty,
+		Stderr:      tty,
+		SysProcAttr: attr,
+	}
+	if err := cmd.Start(); err != nil {
+		ln.Close()
+		fail(err)
+	}
+	tty.Close()
+	if attr.UseCgroupFD {
+		unix.Close(supervisorCgroupFD)
+	}
+
+	// Report the start, then let go of the pipe so the service sees EOF.
+	_ = json.NewEncoder(os.Stdout).Encode(supervisorMsg{Pid: cmd.Process.Pid})
+	if devNull, err := os.Open(os.DevNull); err == nil {
+		_ = unix.Dup3(int(devNull.Fd()), 1, 0)
+		devNull.Close()
+	}
+
+	superviseAgent(ln, supervisorPTYFD, cmd)
+	ln.Close()
+	os.Exit(0)
+}
+
+// superviseAgent hands the PTY master to each service that connects and
+// tells the attached service when the agent exits. It returns once the exit
+// status has been delivered, or after supervisorOrphanTimeout without a
+// service, killing the agent if it is still running.
+func superviseAgent(ln *net.UnixListener, ptmx int, cmd *exec.Cmd) {
+	pid := cmd.Process.Pid
+
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	accepted := make(chan *net.UnixConn)
+	go func() {
+		for {
+			conn, err := ln.AcceptUnix()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	var client *net.UnixConn
+	gone := make(chan *net.UnixConn)
+	var final *supervisorMsg // the exit status, once the agent has exited
+	orphan := time.NewTimer(supervisorOrphanTimeout)
+
+	send := func(conn *net.UnixConn, msg supervisorMsg, oob []byte) error {
+		data, _ := json.Marshal(msg)
+		_, _, err := conn.WriteMsgUnix(data, oob, nil)
+		return err
+	}
+
+	for {
+		select {
+		case conn := <-accepted:
+			// One service at a time: a new connection replaces the old.
+			if client != nil {
+				client.Close()
+			}
+			client = conn
+			orphan.Stop()
+
+			hello := supervisorMsg{Pid: pid}
+			if final != nil {
+				hello = *final
+			}
+			if err := send(conn, hello, unix.UnixRights(ptmx)); err != nil {
+				conn.Close()
+				client = nil
+				orphan.Reset(supervisorOrphanTimeout)
+				continue
+			}
+			if final != nil {
+				conn.Close()
+				return
+			}
+			go func() {
+				buf := make([]byte, 1)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						gone <- conn
+						return
+					}
+				}
+			}()
+
+		case conn := <-gone:
+			if conn == client {
+				client = nil
+				orphan.Reset(supervisorOrphanTimeout)
+			}
+
+		case <-exited:
+			exited = nil
+			code, signal := exitStatus(cmd.ProcessState)
+			final = &supervisorMsg{Pid: pid, Exited: true, ExitCode: code, ExitSignal: signal}
+			if client != nil {
+				_ = send(client, *final, nil)
+				client.Close()
+				return
+			}
+
+		case <-orphan.C:
+			if final == nil {
+				_ = syscall.Kill(-pid, syscall.SIGKILL)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,314 @@
+//go:build linux
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+	"github.com/servicenow/claude-terminal-mid-service/internal/store"
+)
+
+// supervisedConfig returns the fake agent config with session supervision
+// enabled. Socket paths are limited to ~100 bytes, so the directory is kept
+// short.
+func supervisedConfig(t *testing.T) *config.Config {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "sup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	cfg := fakeAgentConfig(t)
+	cfg.Session.SupervisorDir = dir
+	cfg.Session.KillGraceSeconds = 1
+	return cfg
+}
+
+// startSupervisedSession creates a session and waits until its record,
+// including the supervisor socket, is in the store.
+func startSupervisedSession(t *testing.T, m *Manager, db store.Store) *Session {
+	t.Helper()
+	sess, err := m.CreateSession("test-user", Credentials{AnthropicAPIKey: "test-key"}, "")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	waitForOutput(t, sess, "fake-agent-ready")
+	// Should the test fail midway, stop the agent, then attach once so its
+	// supervisor can deliver the exit and quit.
+	t.Cleanup(func() {
+		syscall.Kill(-sess.pid, syscall.SIGKILL)
+		if sc, err := attachSupervisor(sess.supervisorSocket); err == nil {
+			sc.pty.Close()
+			sc.close()
+		}
+	})
+	eventually(t, "session saved", func() bool {
+		rec, err := db.GetSession(context.Background(), sess.SessionID)
+		return err == nil && rec.SupervisorSocket != ""
+	})
+	return sess
+}
+
+func processExists(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+func TestSupervisedSessionSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemoryStore()
+	cfg := supervisedConfig(t)
+
+	first := NewManager(cfg, db)
+	sess := startSupervisedSession(t, first, db)
+	id, pid := sess.SessionID, sess.pid
+	cursor := sess.GetOutputAfter(0).NextCursor
+
+	// Shutting down detaches: the agent, its workspace and the active
+	// record are left for the next start.
+	first.CleanupAll()
+	if !processExists(pid) {
+		t.Fatal("agent stopped with the service")
+	}
+	if rec, _ := db.GetSession(ctx, id); rec.Status != "active" {
+		t.Fatalf("record after shutdown = %+v, want active", rec)
+	}
+	if _, err := os.Stat(sess.WorkspacePath); err != nil {
+		t.Fatalf("workspace removed on shutdown: %v", err)
+	}
+
+	second := NewManager(cfg, db)
+	second.RecoverSessions(ctx)
+	recovered, err := second.GetSessionForUser(id, "test-user")
+	if err != nil {
+		t.Fatalf("session not recovered: %v", err)
+	}
+	// The stored output is back in the buffer, numbered on from the first
+	// run, so a client's cursor still falls before new output.
+	page := recovered.GetOutputAfter(0)
+	if len(page.Chunks) == 0 || !strings.Contains(page.Chunks[0].Data, "fake-agent-ready") {
+		t.Errorf("recovered output = %+v, want the first run's output", page.Chunks)
+	}
+	if page.NextCursor < cursor {
+		t.Errorf("recovered cursor = %d, want at least %d", page.NextCursor, cursor)
+	}
+	if err := recovered.SendCommand("after-restart\n"); err != nil {
+		t.Fatalf("SendCommand failed: %v", err)
+	}
+	waitForOutput(t, recovered, "after-restart")
+	var after strings.Builder
+	for _, c := range recovered.GetOutputAfter(cursor).Chunks {
+		after.WriteString(c.Data)
+	}
+	if !strings.Contains(after.String(), "after-restart") {
+		t.Errorf("output after the first run's cursor = %q, want the new output", after.String())
+	}
+
+	if err := second.TerminateSession(id); err != nil {
+		t.Fatalf("TerminateSession failed: %v", err)
+	}
+	if _, signal, exited := recovered.ExitStatus(); !exited || signal != "SIGTERM" {
+		t.Errorf("exit status = %q, %v; want SIGTERM", signal, exited)
+	}
+	eventually(t, "agent and supervisor gone", func() bool {
+		_, err := os.Stat(filepath.Join(cfg.Session.SupervisorDir, id+".sock"))
+		return !processExists(pid) && os.IsNotExist(err)
+	})
+}
+
+func TestRecoverSessionsEnforcesUserLimit(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemoryStore()
+	cfg := supervisedConfig(t)
+	cfg.Retention.Days = 30 // keep records to inspect
+
+	first := NewManager(cfg, db)
+	older := startSupervisedSession(t, first, db)
+	newer := startSupervisedSession(t, first, db)
+	first.CleanupAll()
+	db.UpdateLastActivity(ctx, older.SessionID, time.Now().Add(-time.Hour))
+
+	// The limit was lowered while the service was down: the most recently
+	// active session is kept.
+	limited := *cfg
+	limited.Session.MaxPerUser = 1
+	second := NewManager(&limited, db)
+	second.RecoverSessions(ctx)
+	if n := second.ActiveSessionCount(); n != 1 {
+		t.Fatalf("recovered %d sessions, want 1", n)
+	}
+	if _, err := second.GetSessionForUser(newer.SessionID, "test-user"); err != nil {
+		t.Errorf("most recent session not recovered: %v", err)
+	}
+	if rec, _ := db.GetSession(ctx, older.SessionID); rec.Status != "terminated" || rec.TerminationReason != ReasonSessionLimit {
+		t.Errorf("session over the limit = %+v, want terminated with %s", rec, ReasonSessionLimit)
+	}
+	second.TerminateSession(newer.SessionID)
+}
+
+func TestRecoverSessionsMarksEndedAgents(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemoryStore()
+	cfg := supervisedConfig(t)
+	cfg.Retention.Days = 30 // keep records to inspect
+
+	first := NewManager(cfg, db)
+	sess := startSupervisedSession(t, first, db)
+	first.CleanupAll()
+
+	// The agent dies while no service is attached; its supervisor keeps the
+	// exit status for the next start.
+	syscall.Kill(-sess.pid, syscall.SIGKILL)
+	eventually(t, "agent reaped", func() bool { return !processExists(sess.pid) })
+
+	db.SaveSession(ctx, store.SessionRecord{
+		SessionID: "gone", UserID: "test-user", Status: "active",
+		SupervisorSocket: filepath.Join(cfg.Session.SupervisorDir, "gone.sock"),
+	})
+
+	second := NewManager(cfg, db)
+	second.RecoverSessions(ctx)
+	if n := second.ActiveSessionCount(); n != 0 {
+		t.Errorf("recovered %d sessions, want none", n)
+	}
+
+	rec, _ := db.GetSession(ctx, sess.SessionID)
+	if rec.Status != "terminated" || rec.TerminationReason != ReasonKilledBySignal || rec.ExitSignal != "SIGKILL" {
+		t.Errorf("ended session = %+v, want killed by SIGKILL", rec)
+	}
+	if _, err := os.Stat(sess.WorkspacePath); !os.IsNotExist(err) {
+		t.Errorf("isolated workspace not removed: %v", err)
+	}
+	if rec, _ := db.GetSession(ctx, "gone"); rec.Status != "terminated" || rec.TerminationReason != ReasonServerShutdown {
+		t.Errorf("session with dead supervisor = %+v, want terminated by server shutdown", rec)
+	}
+}
+
+// fakeSupervisor listens on dir/fake.sock and answers each connection with
+// hello and a pipe standing in for the PTY.
+func fakeSupervisor(t *testing.T, dir string, hello supervisorMsg) string {
+	t.Helper()
+	socket := filepath.Join(dir, "fake.sock")
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: socket, Net: "unixpacket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	t.Cleanup(func() {
+		ln.Close()
+		<-stopped
+		r.Close()
+		w.Close()
+	})
+	go func() {
+		defer close(stopped)
+		for {
+			conn, err := ln.AcceptUnix()
+			if err != nil {
+				return
+			}
+			data, _ := json.Marshal(hello)
+			conn.WriteMsgUnix(data, unix.UnixRights(int(r.Fd())), nil)
+			conn.Close()
+		}
+	}()
+	return socket
+}
+
+func TestAttachSupervisorChecksPeer(t *testing.T) {
+	// The test process stands in for the supervisor, so only its own
+	// children pass as the agent.
+	child := exec.Command("sleep", "30")
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { child.Process.Kill(); child.Wait() })
+
+	tests := []struct {
+		name  string
+		hello supervisorMsg
+		ok    bool
+	}{
+		{"child", supervisorMsg{Pid: child.Process.Pid}, true},
+		{"init", supervisorMsg{Pid: 1}, false},
+		{"no pid", supervisorMsg{}, false},
+		{"not a child", supervisorMsg{Pid: os.Getppid()}, false},
+		{"self", supervisorMsg{Pid: os.Getpid()}, false},
+		{"exited", supervisorMsg{Pid: 1 << 22, Exited: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "sup")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			sc, err := attachSupervisor(fakeSupervisor(t, dir, tt.hello))
+			if (err == nil) != tt.ok {
+				t.Fatalf("attachSupervisor error = %v, want ok %v", err, tt.ok)
+			}
+			if sc != nil {
+				sc.pty.Close()
+				sc.close()
+			}
+		})
+	}
+}
+
+func TestAttachSupervisorChecksDirectory(t *testing.T) {
+	child := exec.Command("sleep", "30")
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { child.Process.Kill(); child.Wait() })
+
+	dir, err := os.MkdirTemp("", "sup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := fakeSupervisor(t, dir, supervisorMsg{Pid: child.Process.Pid})
+
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := attachSupervisor(socket); err == nil {
+		t.Error("attached through a directory with mode 0755")
+	}
+	os.Chmod(dir, 0700)
+
+	if os.Geteuid() == 0 {
+		if err := os.Chown(dir, 65534, 65534); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := attachSupervisor(socket); err == nil {
+			t.Error("attached through a directory owned by another user")
+		}
+		os.Chown(dir, 0, 0)
+	}
+
+	link := dir + "-link"
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(link)
+	if _, err := attachSupervisor(filepath.Join(link, "fake.sock")); err == nil {
+		t.Error("attached through a symlinked directory")
+	}
+}
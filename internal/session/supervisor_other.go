@@ -0,0 +1,19 @@
+//go:build !linux
+
+package session
+
+import (
+	"errors"
+	"net"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+func startSupervised(cmd *exec.Cmd, cg *cgroup, socket string, size *pty.Winsize) (*supervisorConn, error) {
+	return nil, errors.New("session supervision is only supported on Linux")
+}
+
+func verifySupervisor(conn *net.UnixConn, hello supervisorMsg) error {
+	return errors.New("session supervision is only supported on Linux")
+}
@@ -0,0 +1,436 @@
+package session
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+)
+
+// Workspace types accepted by CreateSession and WORKSPACE_TYPE.
+const (
+	WorkspaceIsolated   = "isolated"
+	WorkspacePersistent = "persistent"
+)
+
+// ErrWorkspaceLocked is returned when a persistent workspace is already in use
+// by another session (in this process or another replica sharing the volume).
+var ErrWorkspaceLocked = errors.New("workspace is in use by another session")
+
+// ErrInitFailed marks errors raised while preparing the workspace or starting
+// the session process, as opposed to invalid requests or capacity limits.
+var ErrInitFailed = errors.New("session initialization failed")
+
+// gitRefPattern restricts refs to branch/tag/commit-like names; refs starting
+// with '-' are rejected separately so they can't be parsed as git options.
+var gitRefPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+// gitCloneTimeout bounds how long a clone may delay session start.
+const gitCloneTimeout = 2 * time.Minute
+
+// SessionOptions holds optional per-session settings for CreateSessionWithOptions.
+type SessionOptions struct {
+	// WorkspaceType is "isolated" (removed on cleanup) or "persistent"
+	// (kept across sessions). Empty uses the configured default.
+	WorkspaceType string
+	// WorkspaceName selects a named persistent workspace. Empty uses the
+	// user's default persistent workspace.
+	WorkspaceName string
+	// Template names a directory under WORKSPACE_TEMPLATES_PATH whose
+	// contents are copied into a new workspace.
+	Template string
+	// GitRepo is either a path relative to WORKSPACE_REPOS_PATH (a local bare
+	// repo) or an https:// URL on an allowed host, cloned into a new workspace.
+	GitRepo string
+	// GitRef optionally selects the branch, tag or commit to check out.
+	GitRef string
+	// Profile selects an admin-defined agent profile. Empty uses the
+	// configured default agent.
+	Profile string
+	// Label is a free-form name for finding the session in history.
+	Label string
+}
+
+// workspaceSource describes how to populate an empty workspace before the
+// agent starts. It is validated up front so bad requests fail fast.
+type workspaceSource struct {
+	templateDir string
+	repo        string // local path or https URL
+	remote      bool
+	ref         string
+}
+
+// resolveWorkspaceSource validates template and git options against config.
+// It returns nil when the workspace should start empty.
+func resolveWorkspaceSource(cfg config.WorkspaceConfig, opts SessionOptions) (*workspaceSource, error) {
+	if opts.Template != "" && opts.GitRepo != "" {
+		return nil, fmt.Errorf("template and gitRepo cannot be combined")
+	}
+	if opts.GitRef != "" && opts.GitRepo == "" {
+		return nil, fmt.Errorf("gitRef requires gitRepo")
+	}
+
+	switch {
+	case opts.Template != "":
+		if cfg.TemplatesPath == "" {
+			return nil, fmt.Errorf("workspace templates are not enabled")
+		}
+		if !validIDPattern.MatchString(opts.Template) {
+			return nil, fmt.Errorf("invalid template: must be alphanumeric, hyphens, or underscores")
+		}
+		dir := filepath.Join(cfg.TemplatesPath, opts.Template)
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("unknown template %q", opts.Template)
+		}
+		dir, err = resolveSourceDir(cfg.TemplatesPath, dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %q: %w", opts.Template, err)
+		}
+		return &workspaceSource{templateDir: dir}, nil
+
+	case opts.GitRepo != "":
+		if opts.GitRef != "" && (!gitRefPattern.MatchString(opts.GitRef) || strings.HasPrefix(opts.GitRef, "-")) {
+			return nil, fmt.Errorf("invalid gitRef")
+		}
+
+		if strings.Contains(opts.GitRepo, "://") {
+			u, err := url.Parse(opts.GitRepo)
+			if err != nil || u.Scheme != "https" || u.User != nil {
+				return nil, fmt.Errorf("invalid gitRepo: only https URLs without embedded credentials are supported")
+			}
+			allowed := false
+			for _, host := range cfg.GitAllowedHosts {
+				if strings.EqualFold(u.Hostname(), host) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return nil, fmt.Errorf("gitRepo host %q is not allowed", u.Hostname())
+			}
+			return &workspaceSource{repo: u.String(), remote: true, ref: opts.GitRef}, nil
+		}
+
+		if cfg.ReposPath == "" {
+			return nil, fmt.Errorf("local git repositories are not enabled")
+		}
+		absRoot, err := filepath.Abs(cfg.ReposPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve repos path: %w", err)
+		}
+		repo := filepath.Join(absRoot, opts.GitRepo)
+		if !strings.HasPrefix(repo, absRoot+string(filepath.Separator)) {
+			return nil, fmt.Errorf("gitRepo path traversal detected")
+		}
+		if info, err := os.Stat(repo); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("unknown gitRepo %q", opts.GitRepo)
+		}
+		repo, err = resolveSourceDir(absRoot, repo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gitRepo %q: %w", opts.GitRepo, err)
+		}
+		return &workspaceSource{repo: repo, ref: opts.GitRef}, nil
+	}
+
+	return nil, nil
+}
+
+// resolveSourceDir returns dir with symlinks resolved, provided it still lies
+// under root: a template or repository must not link out of its directory.
+func resolveSourceDir(root, dir string) (string, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(realDir, realRoot+string(filepath.Separator)) {
+		return "", fmt.Errorf("path resolves outside %s", root)
+	}
+	return realDir, nil
+}
+
+// symlinkEscapes reports whether the symlink at path with target link
+// resolves outside realRoot, which must itself have its symlinks resolved.
+// Absolute targets always escape, since the link is copied as-is. Otherwise
+// the whole chain is followed, so links through other links (up -> ..,
+// x -> up/..) are caught. Links that do not resolve, dangling or looping,
+// count as escaping: where they lead cannot be checked.
+func symlinkEscapes(realRoot, path, link string) bool {
+	if filepath.IsAbs(link) {
+		return true
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return true
+	}
+	return real != realRoot && !strings.HasPrefix(real, realRoot+string(filepath.Separator))
+}
+
+// checkSymlinks fails if any symlink under root points outside it, so a
+// cloned repository cannot hand the agent links into the host.
+func checkSymlinks(root string) error {
+	root = filepath.Clean(root)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if symlinkEscapes(realRoot, path, link) {
+			rel, _ := filepath.Rel(root, path)
+			return fmt.Errorf("symlink %s points outside the workspace", rel)
+		}
+		return nil
+	})
+}
+
+// populate fills dest from the template or repository. Persistent
+// workspaces that already have content are left untouched.
+func (src *workspaceSource) populate(dest, githubToken string) error {
+	entries, err := os.ReadDir(dest)
+	if err != nil {
+		return fmt.Errorf("failed to read workspace: %w", err)
+	}
+	if len(entries) > 0 {
+		log.WithField("workspace", dest).Info("Workspace not empty; skipping bootstrap")
+		return nil
+	}
+
+	if src.templateDir != "" {
+		if err := copyTree(src.templateDir, dest); err != nil {
+			return fmt.Errorf("failed to copy template: %w", err)
+		}
+		return nil
+	}
+
+	return src.clone(dest, githubToken)
+}
+
+// clone clones the repository into dest and checks out the requested ref.
+// For remote repos the GitHub token is passed as an HTTP header scoped to the
+// repository host via GIT_CONFIG_* so it never appears in argv or .git/config.
+func (src *workspaceSource) clone(dest, githubToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), gitCloneTimeout)
+	defer cancel()
+
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if src.remote && githubToken != "" {
+		u, _ := url.Parse(src.repo)
+		auth := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + githubToken))
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			fmt.Sprintf("GIT_CONFIG_KEY_0=http.%s://%s/.extraheader", u.Scheme, u.Host),
+			"GIT_CONFIG_VALUE_0=AUTHORIZATION: basic "+auth,
+		)
+	}
+
+	args := []string{"clone", "--quiet"}
+	if !src.remote {
+		// Copy objects rather than hardlinking into the shared bare repo.
+		args = append(args, "--no-hardlinks")
+	}
+	args = append(args, "--", src.repo, dest)
+
+	if out, err := runGit(ctx, env, "", args...); err != nil {
+		return fmt.Errorf("git clone failed: %w: %s", err, out)
+	}
+
+	if src.ref != "" {
+		if out, err := runGit(ctx, env, dest, "checkout", "--quiet", src.ref, "--"); err != nil {
+			return fmt.Errorf("git checkout %s failed: %w: %s", src.ref, err, out)
+		}
+	}
+
+	return checkSymlinks(dest)
+}
+
+func runGit(ctx context.Context, env []string, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = env
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// copyTree recursively copies the contents of src into dest, preserving file
+// modes and recreating symlinks as-is. Symlinks pointing outside src are
+// rejected.
+func copyTree(src, dest string) error {
+	src = filepath.Clean(src)
+	realSrc, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if symlinkEscapes(realSrc, path, link) {
+				return fmt.Errorf("symlink %s points outside the template", rel)
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			// Skip devices, sockets and pipes.
+			return nil
+		}
+	})
+}
+
+func copyFile(src, dest string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// resolveWorkspaceType applies the configured default and validates the type.
+func resolveWorkspaceType(requested, configured string) (string, error) {
+	wsType := requested
+	if wsType == "" {
+		wsType = configured
+	}
+	if wsType == "" {
+		wsType = WorkspaceIsolated
+	}
+
+	switch wsType {
+	case WorkspaceIsolated, WorkspacePersistent:
+		return wsType, nil
+	default:
+		return "", fmt.Errorf("invalid workspaceType %q: must be %q or %q", wsType, WorkspaceIsolated, WorkspacePersistent)
+	}
+}
+
+// workspacePath returns the absolute workspace directory for a session.
+// Isolated workspaces are <base>/<user>/<sessionID>; persistent ones are
+// <base>/<user>/persistent or <base>/<user>/persistent-<name>.
+// C4: the result is verified to resolve under basePath.
+func workspacePath(basePath, userID, sessionID, wsType, name string) (string, error) {
+	var dir string
+	switch wsType {
+	case WorkspacePersistent:
+		if name == "" {
+			dir = filepath.Join(basePath, userID, "persistent")
+		} else {
+			if !validIDPattern.MatchString(name) {
+				return "", fmt.Errorf("invalid workspaceName: must be alphanumeric, hyphens, or underscores")
+			}
+			dir = filepath.Join(basePath, userID, "persistent-"+name)
+		}
+	default:
+		if name != "" {
+			return "", fmt.Errorf("workspaceName is only supported for persistent workspaces")
+		}
+		dir = filepath.Join(basePath, userID, sessionID)
+	}
+
+	return resolveUnderBase(basePath, dir)
+}
+
+// resolveUnderBase returns the absolute form of dir, which must lie under
+// basePath (C4).
+func resolveUnderBase(basePath, dir string) (string, error) {
+	absWorkspace, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve workspace path: %w", err)
+	}
+	absBase, err := filepath.Abs(basePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve base path: %w", err)
+	}
+	if !strings.HasPrefix(absWorkspace, absBase+string(filepath.Separator)) {
+		return "", fmt.Errorf("workspace path traversal detected")
+	}
+
+	return absWorkspace, nil
+}
+
+// lockWorkspace takes an exclusive, non-blocking flock on a sibling lock file
+// so two sessions can never share a persistent workspace. The lock lives next
+// to the workspace rather than inside it to keep the user's tree clean, and is
+// released when the returned file is closed (or the process dies).
+func lockWorkspace(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspace parent: %w", err)
+	}
+
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workspace lock: %w", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrWorkspaceLocked
+		}
+		return nil, fmt.Errorf("failed to lock workspace: %w", err)
+	}
+
+	return f, nil
+}
+
+// releaseWorkspaceLock drops a lock taken by lockWorkspace. Safe on nil.
+func releaseWorkspaceLock(f *os.File) {
+	if f == nil {
+		return
+	}
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
@@ -0,0 +1,274 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/crypto"
+)
+
+// ErrSearchUnavailable is returned by SearchOutput when stored output is
+// encrypted and so cannot be indexed.
+var ErrSearchUnavailable = errors.New("output search is unavailable while stored output is encrypted")
+
+// An encrypted chunk is stored as a crypto frame. Compaction concatenates
+// chunks, so a stored row may hold several frames, and plaintext written
+// before encryption was enabled.
+
+// EncryptedStore is a Store that encrypts session output and recordings at
+// rest (C6). Each session gets a random AES-256 data key, stored with the
+// session wrapped by the master key (ENCRYPTION_KEY); chunks are encrypted
+// with it on write and decrypted on read. Recordings outlive their session,
+// so each gets a key of its own, stored with the recording, for its events;
+// the header is not encrypted. Data stored before encryption was enabled
+// reads back unchanged.
+type EncryptedStore struct {
+	Store
+	masterKey string
+
+	mu            sync.Mutex
+	keys          map[string]string // session ID -> hex data key
+	recordingKeys map[string]string // session ID -> hex recording data key
+}
+
+var _ Store = (*EncryptedStore)(nil)
+
+// NewEncryptedStore wraps inner so that output is encrypted under keys
+// wrapped by masterKey, a hex-encoded 32-byte key.
+func NewEncryptedStore(inner Store, masterKey string) (*EncryptedStore, error) {
+	if _, err := crypto.Encrypt(nil, masterKey); err != nil {
+		return nil, fmt.Errorf("invalid output encryption key: %w", err)
+	}
+	return &EncryptedStore{
+		Store:         inner,
+		masterKey:     masterKey,
+		keys:          make(map[string]string),
+		recordingKeys: make(map[string]string),
+	}, nil
+}
+
+// dataKey returns a session's hex data key. Without one, it creates one when
+// create is set and otherwise returns "".
+func (s *EncryptedStore) dataKey(ctx context.Context, sessionID string, create bool) (string, error) {
+	s.mu.Lock()
+	key, ok := s.keys[sessionID]
+	s.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	rec, err := s.Store.GetSession(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	wrapped := rec.OutputKey
+	if wrapped == "" {
+		if !create {
+			return "", nil
+		}
+		if wrapped, err = s.newKey(); err != nil {
+			return "", err
+		}
+		// Another writer may have got there first; its key wins.
+		if wrapped, err = s.Store.SetOutputKey(ctx, sessionID, wrapped); err != nil {
+			return "", err
+		}
+	}
+
+	if key, err = s.unwrapKey(wrapped); err != nil {
+		return "", fmt.Errorf("failed to unwrap output key of session %s: %w", sessionID, err)
+	}
+	s.mu.Lock()
+	s.keys[sessionID] = key
+	s.mu.Unlock()
+	return key, nil
+}
+
+// recordingKey returns the hex data key of a session's recording, creating
+// one when it has none.
+func (s *EncryptedStore) recordingKey(ctx context.Context, sessionID string) (string, error) {
+	s.mu.Lock()
+	key, ok := s.recordingKeys[sessionID]
+	s.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	wrapped, err := s.newKey()
+	if err != nil {
+		return "", err
+	}
+	// A recording keeps the first key it is given.
+	if wrapped, err = s.Store.SetRecordingKey(ctx, sessionID, wrapped); err != nil {
+		return "", err
+	}
+	if key, err = s.unwrapKey(wrapped); err != nil {
+		return "", fmt.Errorf("failed to unwrap recording key of session %s: %w", sessionID, err)
+	}
+	s.mu.Lock()
+	s.recordingKeys[sessionID] = key
+	s.mu.Unlock()
+	return key, nil
+}
+
+// newKey generates a random data key and returns it wrapped by the master
+// key.
+func (s *EncryptedStore) newKey() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return crypto.Encrypt(raw, s.masterKey)
+}
+
+// unwrapKey returns the hex data key wrapped by the master key.
+func (s *EncryptedStore) unwrapKey(wrapped string) (string, error) {
+	raw, err := crypto.Decrypt(wrapped, s.masterKey)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// SaveOutputChunks encrypts each chunk under the session's data key before
+// saving the batch.
+func (s *EncryptedStore) SaveOutputChunks(ctx context.Context, sessionID string, chunks []OutputChunk) error {
+	if len(chunks) == 0 {
+		return s.Store.SaveOutputChunks(ctx, sessionID, chunks)
+	}
+	key, err := s.dataKey(ctx, sessionID, true)
+	if err != nil {
+		return fmt.Errorf("SaveOutputChunks: %w", err)
+	}
+
+	sealed := make([]OutputChunk, len(chunks))
+	for i, c := range chunks {
+		if c.Data, err = crypto.SealFrame(c.Data, key); err != nil {
+			return fmt.Errorf("SaveOutputChunks: %w", err)
+		}
+		sealed[i] = c
+	}
+	return s.Store.SaveOutputChunks(ctx, sessionID, sealed)
+}
+
+// GetOutputChunks returns a session's most recent output chunks, decrypted.
+func (s *EncryptedStore) GetOutputChunks(ctx context.Context, sessionID string, limit int) ([]OutputChunk, error) {
+	chunks, err := s.Store.GetOutputChunks(ctx, sessionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(ctx, sessionID, chunks)
+}
+
+// GetOutputChunksAfter returns a session's output chunks after afterID,
+// decrypted.
+func (s *EncryptedStore) GetOutputChunksAfter(ctx context.Context, sessionID string, afterID int64, limit int) ([]OutputChunk, error) {
+	chunks, err := s.Store.GetOutputChunksAfter(ctx, sessionID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(ctx, sessionID, chunks)
+}
+
+// open decrypts chunks read from the inner store in place.
+func (s *EncryptedStore) open(ctx context.Context, sessionID string, chunks []OutputChunk) ([]OutputChunk, error) {
+	if len(chunks) == 0 {
+		return chunks, nil
+	}
+	key, err := s.dataKey(ctx, sessionID, false)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		// Never encrypted.
+		return chunks, nil
+	}
+	for i := range chunks {
+		chunks[i].Data = crypto.OpenFrames(chunks[i].Data, key)
+	}
+	return chunks, nil
+}
+
+// SaveRecordingEvents encrypts each event's data under the recording's data
+// key before saving the batch. The recording's header must be saved first.
+func (s *EncryptedStore) SaveRecordingEvents(ctx context.Context, sessionID string, events []RecordingEvent) error {
+	if len(events) == 0 {
+		return s.Store.SaveRecordingEvents(ctx, sessionID, events)
+	}
+	key, err := s.recordingKey(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("SaveRecordingEvents: %w", err)
+	}
+
+	sealed := make([]RecordingEvent, len(events))
+	for i, ev := range events {
+		if ev.Data, err = crypto.SealFrame(ev.Data, key); err != nil {
+			return fmt.Errorf("SaveRecordingEvents: %w", err)
+		}
+		sealed[i] = ev
+	}
+	return s.Store.SaveRecordingEvents(ctx, sessionID, sealed)
+}
+
+// GetRecording returns a recording's header and events, decrypted.
+func (s *EncryptedStore) GetRecording(ctx context.Context, sessionID string) (*RecordingRecord, []RecordingEvent, error) {
+	rec, events, err := s.Store.GetRecording(ctx, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rec.DataKey == "" {
+		// Never encrypted.
+		return rec, events, nil
+	}
+	key, err := s.unwrapKey(rec.DataKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unwrap recording key of session %s: %w", sessionID, err)
+	}
+	for i := range events {
+		events[i].Data = crypto.OpenFrames(events[i].Data, key)
+	}
+	return rec, events, nil
+}
+
+// SearchOutput always fails: the database only sees ciphertext.
+func (s *EncryptedStore) SearchOutput(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	return nil, ErrSearchUnavailable
+}
+
+// DeleteSession deletes a session along with its data key.
+func (s *EncryptedStore) DeleteSession(ctx context.Context, sessionID string) error {
+	err := s.Store.DeleteSession(ctx, sessionID)
+	s.mu.Lock()
+	delete(s.keys, sessionID)
+	s.mu.Unlock()
+	return err
+}
+
+// PurgeTerminatedSessions purges ended sessions and forgets the cached data
+// keys, which are reloaded on demand.
+func (s *EncryptedStore) PurgeTerminatedSessions(ctx context.Context, endedBefore time.Time) (int64, error) {
+	n, err := s.Store.PurgeTerminatedSessions(ctx, endedBefore)
+	if n > 0 {
+		s.mu.Lock()
+		s.keys = make(map[string]string)
+		s.mu.Unlock()
+	}
+	return n, err
+}
+
+// PurgeRecordings purges expired recordings and forgets the cached recording
+// keys, which are reloaded on demand.
+func (s *EncryptedStore) PurgeRecordings(ctx context.Context, startedBefore time.Time) (int64, error) {
+	n, err := s.Store.PurgeRecordings(ctx, startedBefore)
+	if n > 0 {
+		s.mu.Lock()
+		s.recordingKeys = make(map[string]string)
+		s.mu.Unlock()
+	}
+	return n, err
+}
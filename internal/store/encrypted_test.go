@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/crypto"
+)
+
+const (
+	encID          = "55555555-5555-5555-5555-555555555555"
+	plainID        = "66666666-6666-6666-6666-666666666666"
+	testMasterKey  = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	otherMasterKey = "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+)
+
+// testEncryptedStore checks that an EncryptedStore over inner keeps output
+// encrypted in inner and reads it back decrypted.
+func testEncryptedStore(t *testing.T, inner Store) {
+	ctx := context.Background()
+	s, err := NewEncryptedStore(inner, testMasterKey)
+	if err != nil {
+		t.Fatalf("NewEncryptedStore failed: %v", err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, id := range []string{encID, plainID} {
+		if err := s.SaveSession(ctx, SessionRecord{SessionID: id, UserID: "alice", Status: "active", LastActivity: old, CreatedAt: old}); err != nil {
+			t.Fatalf("SaveSession failed: %v", err)
+		}
+	}
+	// Output written before encryption was enabled stays readable.
+	if err := inner.SaveOutputChunks(ctx, plainID, []OutputChunk{{Timestamp: old, Data: "legacy\r\n"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.SaveOutputChunks(ctx, encID, []OutputChunk{{Timestamp: old, Data: "before "}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"password: hunter2\r\n", "\x1b[1mdone\x1b[0m\r\n"} {
+		if err := s.SaveOutputChunks(ctx, encID, []OutputChunk{{Timestamp: old, Data: data}}); err != nil {
+			t.Fatalf("SaveOutputChunks failed: %v", err)
+		}
+	}
+	if err := s.SaveOutputChunks(ctx, "missing", []OutputChunk{{Timestamp: old, Data: "x"}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SaveOutputChunks(missing) = %v, want ErrNotFound", err)
+	}
+
+	want := "before password: hunter2\r\n\x1b[1mdone\x1b[0m\r\n"
+	read := func(s Store) string {
+		t.Helper()
+		chunks, err := s.GetOutputChunksAfter(ctx, encID, 0, 10)
+		if err != nil {
+			t.Fatalf("GetOutputChunksAfter failed: %v", err)
+		}
+		var b strings.Builder
+		for _, c := range chunks {
+			b.WriteString(c.Data)
+		}
+		return b.String()
+	}
+	if got := read(s); got != want {
+		t.Errorf("decrypted output = %q, want %q", got, want)
+	}
+	if raw := read(inner); strings.Contains(raw, "hunter2") || !strings.HasPrefix(raw, "before "+crypto.FrameStart) {
+		t.Errorf("stored output = %q, want ciphertext", raw)
+	}
+	if chunks, err := s.GetOutputChunks(ctx, plainID, 10); err != nil || len(chunks) != 1 || chunks[0].Data != "legacy\r\n" {
+		t.Errorf("GetOutputChunks(plain) = %+v, %v", chunks, err)
+	}
+	if chunks, err := s.GetOutputChunks(ctx, encID, 1); err != nil || len(chunks) != 1 || chunks[0].Data != "\x1b[1mdone\x1b[0m\r\n" {
+		t.Errorf("GetOutputChunks(enc, 1) = %+v, %v", chunks, err)
+	}
+
+	// Compaction merges plaintext and frames into one row.
+	if _, err := s.CompactOutput(ctx, CompactionPolicy{MergeBefore: time.Now(), MergeMaxBytes: 1 << 20}); err != nil {
+		t.Fatalf("CompactOutput failed: %v", err)
+	}
+	if chunks, _ := inner.GetOutputChunks(ctx, encID, 10); len(chunks) != 1 {
+		t.Fatalf("stored chunks after compaction = %d, want 1", len(chunks))
+	}
+	if got := read(s); got != want {
+		t.Errorf("output after compaction = %q, want %q", got, want)
+	}
+
+	// Each session has its own key, wrapped by the master key.
+	rec, _ := inner.GetSession(ctx, encID)
+	if rec.OutputKey == "" {
+		t.Fatal("no output key stored")
+	}
+	if rec, _ := inner.GetSession(ctx, plainID); rec.OutputKey != "" {
+		t.Errorf("session without encrypted output got a key")
+	}
+	wrong, _ := NewEncryptedStore(inner, otherMasterKey)
+	if _, err := wrong.GetOutputChunks(ctx, encID, 10); err == nil {
+		t.Error("Expected reading with another master key to fail")
+	}
+
+	if _, err := s.SearchOutput(ctx, SearchQuery{Query: "hunter2"}); !errors.Is(err, ErrSearchUnavailable) {
+		t.Errorf("SearchOutput = %v, want ErrSearchUnavailable", err)
+	}
+
+	// Recording events are encrypted under a key of the recording's own,
+	// which outlives the session; recordings stored earlier read as they are.
+	for _, id := range []string{encID, plainID} {
+		if err := s.SaveRecording(ctx, RecordingRecord{SessionID: id, UserID: "alice", Header: `{"version":2}`, StartedAt: old}); err != nil {
+			t.Fatalf("SaveRecording failed: %v", err)
+		}
+	}
+	if err := inner.SaveRecordingEvents(ctx, plainID, []RecordingEvent{{Seq: 1, Kind: "o", Data: "legacy"}}); err != nil {
+		t.Fatal(err)
+	}
+	events := []RecordingEvent{{Seq: 1, Kind: "i", Data: "hunter2\r"}, {Seq: 2, Kind: "o", Data: "ok\r\n"}}
+	if err := s.SaveRecordingEvents(ctx, encID, events); err != nil {
+		t.Fatalf("SaveRecordingEvents failed: %v", err)
+	}
+	if err := s.SaveRecordingEvents(ctx, "missing", events); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SaveRecordingEvents(missing) = %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteSession(ctx, encID); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	fresh, _ := NewEncryptedStore(inner, testMasterKey)
+	rec2, got, err := fresh.GetRecording(ctx, encID)
+	if err != nil || len(got) != 2 || got[0].Data != "hunter2\r" || got[1].Data != "ok\r\n" || rec2.Header != `{"version":2}` {
+		t.Errorf("GetRecording = %+v, %+v, %v; want the events decrypted", rec2, got, err)
+	}
+	if stored, raw, _ := inner.GetRecording(ctx, encID); stored.DataKey == "" || strings.Contains(raw[0].Data, "hunter2") {
+		t.Errorf("stored recording = %+v, %+v; want a key and ciphertext", stored, raw)
+	}
+	if _, got, err := s.GetRecording(ctx, plainID); err != nil || len(got) != 1 || got[0].Data != "legacy" {
+		t.Errorf("GetRecording(plain) = %+v, %v", got, err)
+	}
+	if _, _, err := wrong.GetRecording(ctx, encID); err == nil {
+		t.Error("Expected reading a recording with another master key to fail")
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	testEncryptedStore(t, NewMemoryStore())
+
+	sqlite, err := NewSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "encrypted.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	defer sqlite.Close()
+	testEncryptedStore(t, sqlite)
+
+	if _, err := NewEncryptedStore(NewMemoryStore(), "not-hex"); err == nil {
+		t.Error("Expected an invalid master key to be rejected")
+	}
+}
@@ -0,0 +1,437 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process Store. Nothing survives a restart; it lets
+// tests and single-process setups exercise the persistence paths.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	sessions   map[string]SessionRecord
+	output     map[string][]OutputChunk
+	compacted  map[string]int64 // session ID -> ID its output is settled through
+	nextID     int64
+	recordings map[string]RecordingRecord
+	events     map[string]map[int64]RecordingEvent
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions:   make(map[string]SessionRecord),
+		output:     make(map[string][]OutputChunk),
+		compacted:  make(map[string]int64),
+		recordings: make(map[string]RecordingRecord),
+		events:     make(map[string]map[int64]RecordingEvent),
+	}
+}
+
+// SaveSession inserts or updates (upserts) a session record. Like the SQL
+// stores it keeps the termination fields and output key of an existing
+// record.
+func (s *MemoryStore) SaveSession(ctx context.Context, rec SessionRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.sessions[rec.SessionID]; ok {
+		rec.TerminationReason = old.TerminationReason
+		rec.ExitCode = old.ExitCode
+		rec.ExitSignal = old.ExitSignal
+		rec.TerminatedAt = old.TerminatedAt
+		rec.OutputKey = old.OutputKey
+	} else {
+		rec.TerminationReason, rec.ExitCode, rec.ExitSignal, rec.TerminatedAt = "", nil, "", nil
+		rec.OutputKey = ""
+	}
+	rec.UpdatedAt = time.Now()
+	s.sessions[rec.SessionID] = rec
+	return nil
+}
+
+// GetSession retrieves a single session by ID.
+func (s *MemoryStore) GetSession(ctx context.Context, sessionID string) (*SessionRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &rec, nil
+}
+
+// SetOutputKey stores a session's wrapped output data key unless it already
+// has one.
+func (s *MemoryStore) SetOutputKey(ctx context.Context, sessionID, wrappedKey string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.sessions[sessionID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if rec.OutputKey == "" {
+		rec.OutputKey = wrappedKey
+		s.sessions[sessionID] = rec
+	}
+	return rec.OutputKey, nil
+}
+
+// GetSessionsForUser returns the sessions belonging to a user that match f.
+func (s *MemoryStore) GetSessionsForUser(ctx context.Context, userID string, f SessionFilter) ([]SessionRecord, error) {
+	records := s.filterSessions(func(rec SessionRecord) bool { return rec.UserID == userID && f.matches(rec) })
+	if f.Offset > 0 {
+		records = records[min(f.Offset, len(records)):]
+	}
+	if f.Limit > 0 && len(records) > f.Limit {
+		records = records[:f.Limit]
+	}
+	return records, nil
+}
+
+// GetActiveSessions returns all sessions with active or initializing status.
+func (s *MemoryStore) GetActiveSessions(ctx context.Context) ([]SessionRecord, error) {
+	return s.filterSessions(isLive), nil
+}
+
+// filterSessions returns the sessions matching keep, newest first.
+func (s *MemoryStore) filterSessions(keep func(SessionRecord) bool) []SessionRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []SessionRecord
+	for _, rec := range s.sessions {
+		if keep(rec) {
+			records = append(records, rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].CreatedAt.After(records[j].CreatedAt)
+		}
+		return records[i].SessionID < records[j].SessionID
+	})
+	return records
+}
+
+func isLive(rec SessionRecord) bool {
+	return rec.Status == "active" || rec.Status == "initializing"
+}
+
+// update applies fn to a session, if it exists.
+func (s *MemoryStore) update(sessionID string, fn func(*SessionRecord)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.sessions[sessionID]; ok {
+		fn(&rec)
+		rec.UpdatedAt = time.Now()
+		s.sessions[sessionID] = rec
+	}
+}
+
+// UpdateSessionStatus sets the status for a session.
+func (s *MemoryStore) UpdateSessionStatus(ctx context.Context, sessionID, status string) error {
+	s.update(sessionID, func(rec *SessionRecord) { rec.Status = status })
+	return nil
+}
+
+// MarkSessionTerminated sets status='terminated', keeping 'failed' for a
+// session that never started, and records why the session ended.
+func (s *MemoryStore) MarkSessionTerminated(ctx context.Context, sessionID, reason string, exitCode *int, exitSignal string) error {
+	s.update(sessionID, func(rec *SessionRecord) {
+		now := time.Now()
+		if rec.Status != "failed" {
+			rec.Status = "terminated"
+		}
+		rec.TerminationReason = reason
+		rec.ExitCode = exitCode
+		rec.ExitSignal = exitSignal
+		rec.TerminatedAt = &now
+	})
+	return nil
+}
+
+// MarkStaleSessionsTerminated terminates sessions left active or
+// initializing, recording a server shutdown as the reason.
+func (s *MemoryStore) MarkStaleSessionsTerminated(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	now := time.Now()
+	for id, rec := range s.sessions {
+		if !isLive(rec) {
+			continue
+		}
+		rec.Status = "terminated"
+		if rec.TerminationReason == "" {
+			rec.TerminationReason = "server_shutdown"
+		}
+		rec.TerminatedAt = &now
+		rec.UpdatedAt = now
+		s.sessions[id] = rec
+		n++
+	}
+	return n, nil
+}
+
+// UpdateLastActivity bumps the last activity timestamp.
+func (s *MemoryStore) UpdateLastActivity(ctx context.Context, sessionID string, t time.Time) error {
+	s.update(sessionID, func(rec *SessionRecord) { rec.LastActivity = t })
+	return nil
+}
+
+// DeleteSession removes a session and its output.
+func (s *MemoryStore) DeleteSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, sessionID)
+	delete(s.output, sessionID)
+	delete(s.compacted, sessionID)
+	return nil
+}
+
+// PurgeTerminatedSessions deletes sessions, with their output, that ended
+// before endedBefore.
+func (s *MemoryStore) PurgeTerminatedSessions(ctx context.Context, endedBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, rec := range s.sessions {
+		if isLive(rec) {
+			continue
+		}
+		ended := rec.UpdatedAt
+		if rec.TerminatedAt != nil {
+			ended = *rec.TerminatedAt
+		}
+		if ended.Before(endedBefore) {
+			delete(s.sessions, id)
+			delete(s.output, id)
+			delete(s.compacted, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// SaveOutputChunks appends a batch of output chunks for a session. Like the
+// SQL stores' foreign key, it fails for an unknown session.
+func (s *MemoryStore) SaveOutputChunks(ctx context.Context, sessionID string, chunks []OutputChunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[sessionID]; !ok {
+		return ErrNotFound
+	}
+	for _, c := range chunks {
+		s.nextID++
+		s.output[sessionID] = append(s.output[sessionID], OutputChunk{ID: s.nextID, SessionID: sessionID, Timestamp: c.Timestamp, Data: c.Data})
+	}
+	return nil
+}
+
+// GetOutputChunks returns the most recent output chunks for a session.
+func (s *MemoryStore) GetOutputChunks(ctx context.Context, sessionID string, limit int) ([]OutputChunk, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chunks := s.output[sessionID]
+	if len(chunks) > limit {
+		chunks = chunks[len(chunks)-limit:]
+	}
+	return append([]OutputChunk(nil), chunks...), nil
+}
+
+// GetOutputChunksAfter returns up to limit output chunks with ID greater
+// than afterID, oldest first.
+func (s *MemoryStore) GetOutputChunksAfter(ctx context.Context, sessionID string, afterID int64, limit int) ([]OutputChunk, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chunks := s.output[sessionID]
+	i := sort.Search(len(chunks), func(i int) bool { return chunks[i].ID > afterID })
+	chunks = chunks[i:]
+	if len(chunks) > limit {
+		chunks = chunks[:limit]
+	}
+	return append([]OutputChunk(nil), chunks...), nil
+}
+
+// SearchOutput returns the chunks containing every word of q.Query, newest
+// first among equally ranked ones.
+func (s *MemoryStore) SearchOutput(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	terms := searchTerms(q.Query)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var hits []SearchHit
+	for id, chunks := range s.output {
+		rec := s.sessions[id]
+		if q.UserID != "" && rec.UserID != q.UserID {
+			continue
+		}
+		for i, c := range chunks {
+			ok, rank, snippet := matchOutput(c.Data, terms)
+			if !ok {
+				continue
+			}
+			hits = append(hits, SearchHit{
+				SessionID:  id,
+				UserID:     rec.UserID,
+				Label:      rec.Label,
+				ChunkID:    c.ID,
+				ChunkIndex: int64(i),
+				Timestamp:  c.Timestamp,
+				Snippet:    snippet,
+				Rank:       rank,
+			})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Rank != hits[j].Rank {
+			return hits[i].Rank > hits[j].Rank
+		}
+		return hits[i].ChunkID > hits[j].ChunkID
+	})
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
+
+// CompactOutput applies p to the output of every session with chunks
+// written since its output was last settled.
+func (s *MemoryStore) CompactOutput(ctx context.Context, p CompactionPolicy) (CompactionResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res CompactionResult
+	for id, chunks := range s.output {
+		if len(chunks) == 0 || chunks[len(chunks)-1].ID <= s.compacted[id] {
+			continue
+		}
+		res.Scanned++
+		edit := planCompaction(chunks, p)
+		s.compacted[id] = max(s.compacted[id], edit.through)
+		if edit.empty() {
+			continue
+		}
+		removed := make(map[int64]bool, len(edit.remove))
+		for _, rid := range edit.remove {
+			removed[rid] = true
+		}
+		updated := make(map[int64]string, len(edit.update))
+		for _, c := range edit.update {
+			updated[c.ID] = c.Data
+		}
+
+		kept := make([]OutputChunk, 0, len(chunks)-len(edit.remove))
+		for _, c := range chunks {
+			if removed[c.ID] {
+				continue
+			}
+			if data, ok := updated[c.ID]; ok {
+				c.Data = data
+			}
+			kept = append(kept, c)
+		}
+		s.output[id] = kept
+		res.Sessions++
+		res.MergedChunks += edit.merged
+		res.RemovedChunks += edit.capped
+	}
+	return res, nil
+}
+
+// SaveRecording stores the header of a session recording.
+func (s *MemoryStore) SaveRecording(ctx context.Context, rec RecordingRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.recordings[rec.SessionID]; !ok {
+		rec.DataKey = ""
+		s.recordings[rec.SessionID] = rec
+	}
+	return nil
+}
+
+// SetRecordingKey stores a recording's wrapped data key unless it already
+// has one.
+func (s *MemoryStore) SetRecordingKey(ctx context.Context, sessionID, wrappedKey string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.recordings[sessionID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if rec.DataKey == "" {
+		rec.DataKey = wrappedKey
+		s.recordings[sessionID] = rec
+	}
+	return rec.DataKey, nil
+}
+
+// SaveRecordingEvents appends a batch of events to a session recording.
+func (s *MemoryStore) SaveRecordingEvents(ctx context.Context, sessionID string, events []RecordingEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.events[sessionID] == nil {
+		s.events[sessionID] = make(map[int64]RecordingEvent)
+	}
+	for _, ev := range events {
+		s.events[sessionID][ev.Seq] = ev
+	}
+	return nil
+}
+
+// GetRecording returns a session recording's header and events in order.
+func (s *MemoryStore) GetRecording(ctx context.Context, sessionID string) (*RecordingRecord, []RecordingEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.recordings[sessionID]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	events := make([]RecordingEvent, 0, len(s.events[sessionID]))
+	for _, ev := range s.events[sessionID] {
+		events = append(events, ev)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	return &rec, events, nil
+}
+
+// PurgeRecordings deletes recordings that started before startedBefore,
+// except those of live sessions.
+func (s *MemoryStore) PurgeRecordings(ctx context.Context, startedBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, rec := range s.recordings {
+		if sess, ok := s.sessions[id]; ok && isLive(sess) {
+			continue
+		}
+		if rec.StartedAt.Before(startedBefore) {
+			delete(s.recordings, id)
+			delete(s.events, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Close is a no-op.
+func (s *MemoryStore) Close() {}
@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// postgresMigrations holds the PostgreSQL schema as ordered up/down pairs
+// named NNNN_description.up.sql and NNNN_description.down.sql. Applied
+// migrations must never be edited; add a new one instead.
+//
+// A file whose first line is noTransactionMarker runs outside a transaction,
+// which e.g. CREATE INDEX CONCURRENTLY requires. It must be a single
+// statement that is safe to run again: the migration is recorded only after
+// it succeeds.
+//
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+
+	UpNoTransaction   bool
+	DownNoTransaction bool
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time // nil while pending
+}
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+const noTransactionMarker = "-- migrate:no-transaction"
+
+// loadMigrations reads the migrations in dir, ordered by version. Every
+// version needs both an up and a down file.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		m := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration version %d has two names: %s and %s", version, mig.Name, m[2])
+		}
+		noTx := strings.HasPrefix(string(data), noTransactionMarker+"\n")
+		if m[3] == "up" {
+			mig.Up, mig.UpNoTransaction = string(data), noTx
+		} else {
+			mig.Down, mig.DownNoTransaction = string(data), noTx
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrationTarget is a database that migrations are applied to.
+type migrationTarget interface {
+	// lock serializes migrations across processes until unlock is called.
+	lock(ctx context.Context) (unlock func(), err error)
+	// applied returns the applied versions and when they were applied.
+	applied(ctx context.Context) (map[int]time.Time, error)
+	// run executes a migration's up or down SQL and records the change in
+	// schema_migrations, atomically unless the script runs outside a
+	// transaction.
+	run(ctx context.Context, m Migration, up bool) error
+}
+
+// migrateUp applies pending migrations in order and returns them.
+func migrateUp(ctx context.Context, t migrationTarget, migrations []Migration) ([]Migration, error) {
+	unlock, err := t.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := t.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	warnUnknownMigrations(applied, migrations)
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := t.run(ctx, m, true); err != nil {
+			return done, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		log.WithFields(log.Fields{"version": m.Version, "name": m.Name}).Info("Applied database migration")
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// migrateDown rolls back the most recently applied migration and returns
+// it, or nil when none is applied.
+func migrateDown(ctx context.Context, t migrationTarget, migrations []Migration) (*Migration, error) {
+	unlock, err := t.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := t.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	latest := -1
+	for version := range applied {
+		if version > latest {
+			latest = version
+		}
+	}
+	if latest < 0 {
+		return nil, nil
+	}
+
+	for _, m := range migrations {
+		if m.Version != latest {
+			continue
+		}
+		if err := t.run(ctx, m, false); err != nil {
+			return nil, fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		log.WithFields(log.Fields{"version": m.Version, "name": m.Name}).Info("Rolled back database migration")
+		return &m, nil
+	}
+	return nil, fmt.Errorf("applied migration %d is unknown to this build; roll back with the build that applied it", latest)
+}
+
+// migrationStatus lists every known migration and when it was applied.
+func migrationStatus(ctx context.Context, t migrationTarget, migrations []Migration) ([]MigrationStatus, error) {
+	applied, err := t.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	warnUnknownMigrations(applied, migrations)
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		status[i].Migration = m
+		if at, ok := applied[m.Version]; ok {
+			status[i].AppliedAt = &at
+		}
+	}
+	return status, nil
+}
+
+// warnUnknownMigrations logs applied versions this build does not know,
+// e.g. while an older replica runs during a rolling upgrade.
+func warnUnknownMigrations(applied map[int]time.Time, migrations []Migration) {
+	known := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	for version := range applied {
+		if !known[version] {
+			log.WithField("version", version).Warn("Database has a migration unknown to this build")
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+)
+
+// fakeTarget records applied migrations in memory.
+type fakeTarget struct {
+	versions map[int]time.Time
+	locked   bool
+	ran      []string
+	failOn   int
+}
+
+func (f *fakeTarget) lock(ctx context.Context) (func(), error) {
+	if f.locked {
+		return nil, errors.New("already locked")
+	}
+	f.locked = true
+	return func() { f.locked = false }, nil
+}
+
+func (f *fakeTarget) applied(ctx context.Context) (map[int]time.Time, error) {
+	out := make(map[int]time.Time, len(f.versions))
+	for v, at := range f.versions {
+		out[v] = at
+	}
+	return out, nil
+}
+
+func (f *fakeTarget) run(ctx context.Context, m Migration, up bool) error {
+	if !f.locked {
+		return errors.New("run without lock")
+	}
+	if m.Version == f.failOn {
+		return errors.New("syntax error")
+	}
+	if up {
+		f.versions[m.Version] = time.Now()
+		f.ran = append(f.ran, m.Up)
+	} else {
+		delete(f.versions, m.Version)
+		f.ran = append(f.ran, m.Down)
+	}
+	return nil
+}
+
+var testMigrations = fstest.MapFS{
+	"m/0002_add_column.up.sql":     {Data: []byte("up 2")},
+	"m/0002_add_column.down.sql":   {Data: []byte("down 2")},
+	"m/0001_create_table.up.sql":   {Data: []byte("up 1")},
+	"m/0001_create_table.down.sql": {Data: []byte("down 1")},
+	"m/0010_add_index.up.sql":      {Data: []byte("-- migrate:no-transaction\nup 10")},
+	"m/0010_add_index.down.sql":    {Data: []byte("down 10")},
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(testMigrations, "m")
+	if err != nil {
+		t.Fatalf("loadMigrations failed: %v", err)
+	}
+	var got []string
+	for _, m := range migrations {
+		got = append(got, m.Name+":"+m.Up+":"+m.Down)
+	}
+	want := "create_table:up 1:down 1,add_column:up 2:down 2,add_index:-- migrate:no-transaction\nup 10:down 10"
+	if strings.Join(got, ",") != want {
+		t.Errorf("migrations = %v, want %s", got, want)
+	}
+	if migrations[1].UpNoTransaction || !migrations[2].UpNoTransaction || migrations[2].DownNoTransaction {
+		t.Errorf("only the up file of add_index should run outside a transaction: %+v", migrations)
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"missing down": {"m/0001_a.up.sql": {Data: []byte("x")}},
+		"bad name":     {"m/1-a.sql": {Data: []byte("x")}},
+		"two names": {
+			"m/0001_a.up.sql": {Data: []byte("x")}, "m/0001_b.down.sql": {Data: []byte("x")},
+		},
+	}
+	for name, fsys := range invalid {
+		if _, err := loadMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPostgresMigrationsEmbedded(t *testing.T) {
+	migrations, err := loadMigrations(postgresMigrations, "migrations/postgres")
+	if err != nil {
+		t.Fatalf("loadMigrations failed: %v", err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d; versions must be sequential", i, m.Version)
+		}
+		// CONCURRENTLY fails inside a transaction.
+		if strings.Contains(m.Up, "CONCURRENTLY") && !m.UpNoTransaction ||
+			strings.Contains(m.Down, "CONCURRENTLY") && !m.DownNoTransaction {
+			t.Errorf("migration %04d_%s uses CONCURRENTLY inside a transaction", m.Version, m.Name)
+		}
+	}
+	if len(migrations) == 0 || !strings.Contains(migrations[0].Up, "CREATE TABLE IF NOT EXISTS sessions") {
+		t.Errorf("first migration should create the sessions table")
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	migrations, _ := loadMigrations(testMigrations, "m")
+	target := &fakeTarget{versions: map[int]time.Time{}}
+
+	applied, err := migrateUp(ctx, target, migrations)
+	if err != nil || len(applied) != 3 {
+		t.Fatalf("migrateUp = %v, %v; want 3 applied", applied, err)
+	}
+	if strings.Join(target.ran, ",") != "up 1,up 2,-- migrate:no-transaction\nup 10" || target.locked {
+		t.Errorf("ran %v (locked %v)", target.ran, target.locked)
+	}
+
+	// Up to date: nothing to do.
+	if applied, err := migrateUp(ctx, target, migrations); err != nil || len(applied) != 0 {
+		t.Errorf("second migrateUp = %v, %v; want none", applied, err)
+	}
+
+	m, err := migrateDown(ctx, target, migrations)
+	if err != nil || m == nil || m.Version != 10 {
+		t.Fatalf("migrateDown = %+v, %v; want version 10", m, err)
+	}
+	status, err := migrationStatus(ctx, target, migrations)
+	if err != nil || len(status) != 3 || status[1].AppliedAt == nil || status[2].AppliedAt != nil {
+		t.Errorf("status after rollback = %+v, %v", status, err)
+	}
+
+	// Roll back everything, then once more with nothing applied.
+	migrateDown(ctx, target, migrations)
+	migrateDown(ctx, target, migrations)
+	if m, err := migrateDown(ctx, target, migrations); m != nil || err != nil {
+		t.Errorf("migrateDown with nothing applied = %+v, %v", m, err)
+	}
+}
+
+func TestMigrateUpStopsOnFailure(t *testing.T) {
+	ctx := context.Background()
+	migrations, _ := loadMigrations(testMigrations, "m")
+	target := &fakeTarget{versions: map[int]time.Time{}, failOn: 2}
+
+	applied, err := migrateUp(ctx, target, migrations)
+	if err == nil || !strings.Contains(err.Error(), "0002_add_column") {
+		t.Errorf("Expected failure naming 0002_add_column, got %v", err)
+	}
+	if len(applied) != 1 || len(target.versions) != 1 || target.locked {
+		t.Errorf("applied %v, versions %v, locked %v; want only 0001 and the lock released", applied, target.versions, target.locked)
+	}
+}
+
+func TestMigrateDownUnknownVersion(t *testing.T) {
+	migrations, _ := loadMigrations(testMigrations, "m")
+	target := &fakeTarget{versions: map[int]time.Time{1: time.Now(), 99: time.Now()}}
+
+	if _, err := migrateDown(context.Background(), target, migrations); err == nil {
+		t.Error("Expected error rolling back a migration this build does not know")
+	}
+}
+
+// TestPostgresMigrations runs the embedded migrations against a real
+// database; set TEST_DB_HOST (and DB_* as needed) to enable it.
+func TestPostgresMigrations(t *testing.T) {
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("TEST_DB_HOST not set")
+	}
+	ctx := context.Background()
+	cfg := config.DatabaseConfig{
+		Host: host, Port: 5432, User: "postgres", Password: os.Getenv("DB_PASSWORD"),
+		DBName: "claude_terminal_test", SSLMode: "disable",
+	}
+
+	s, err := NewPostgresStore(ctx, cfg)
+	if err != nil {
+		t.Fatalf("NewPostgresStore failed: %v", err)
+	}
+	defer s.Close()
+
+	m, err := s.Rollback(ctx)
+	if err != nil || m == nil {
+		t.Fatalf("Rollback = %+v, %v", m, err)
+	}
+	if applied, err := s.Migrate(ctx); err != nil || len(applied) != 1 || applied[0].Version != m.Version {
+		t.Errorf("Migrate after rollback = %+v, %v; want %d reapplied", applied, err, m.Version)
+	}
+	status, err := s.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	for _, st := range status {
+		if st.AppliedAt == nil {
+			t.Errorf("migration %04d_%s pending after Migrate", st.Version, st.Name)
+		}
+	}
+	testStore(t, s)
+	testRetention(t, s)
+	testSearch(t, s)
+	testEncryptedStore(t, s)
+}
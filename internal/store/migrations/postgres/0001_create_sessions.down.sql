@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS session_output;
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS workspace_type VARCHAR(20) NOT NULL DEFAULT 'isolated';
@@ -0,0 +1,3 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS exit_signal;
+ALTER TABLE sessions DROP COLUMN IF EXISTS exit_code;
+ALTER TABLE sessions DROP COLUMN IF EXISTS termination_reason;
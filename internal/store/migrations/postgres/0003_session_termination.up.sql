@@ -0,0 +1,3 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS termination_reason VARCHAR(32);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS exit_code INTEGER;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS exit_signal VARCHAR(16);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS session_recording_events;
+DROP TABLE IF EXISTS session_recordings;
@@ -0,0 +1,19 @@
+-- Recordings are audit records: they deliberately do not reference sessions,
+-- whose rows are deleted when the session is terminated.
+CREATE TABLE IF NOT EXISTS session_recordings (
+    session_id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    header TEXT NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_recordings_user_id ON session_recordings(user_id);
+
+CREATE TABLE IF NOT EXISTS session_recording_events (
+    session_id VARCHAR(36) NOT NULL,
+    seq BIGINT NOT NULL,
+    elapsed DOUBLE PRECISION NOT NULL,
+    kind CHAR(1) NOT NULL,
+    data TEXT NOT NULL,
+    PRIMARY KEY (session_id, seq)
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_sessions_terminated_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS terminated_at;
@@ -0,0 +1,4 @@
+-- Ended sessions are kept until the retention janitor purges them.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS terminated_at TIMESTAMP WITH TIME ZONE;
+UPDATE sessions SET terminated_at = updated_at WHERE terminated_at IS NULL AND status NOT IN ('active', 'initializing');
+CREATE INDEX IF NOT EXISTS idx_sessions_terminated_at ON sessions(terminated_at);
@@ -0,0 +1,2 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS supervisor_socket;
+ALTER TABLE sessions DROP COLUMN IF EXISTS profile;
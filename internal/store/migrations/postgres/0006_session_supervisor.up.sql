@@ -0,0 +1,3 @@
+-- What is needed to reattach to a supervised session after a restart.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS profile VARCHAR(64);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS supervisor_socket TEXT;
@@ -0,0 +1,2 @@
+-- The terminated session a resumed session continues.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS resumed_from VARCHAR(36);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_sessions_user_created;
+ALTER TABLE sessions DROP COLUMN IF EXISTS label;
@@ -0,0 +1,3 @@
+-- Labels and the per-user history listing.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS label VARCHAR(100);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_created ON sessions(user_id, created_at DESC);
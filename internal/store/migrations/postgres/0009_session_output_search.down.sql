@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS session_output_search_vector ON session_output;
+DROP FUNCTION IF EXISTS session_output_search_vector();
+ALTER TABLE session_output DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS session_output_text(TEXT);
@@ -0,0 +1,24 @@
+-- Full-text search over session output. Terminal escape sequences become
+-- spaces first, so colour codes neither glue words together nor get indexed.
+CREATE OR REPLACE FUNCTION session_output_text(data TEXT) RETURNS TEXT
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE
+    AS $$ SELECT regexp_replace(data, '\x1b(\[[0-9;?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)?|.)', ' ', 'g') $$;
+
+-- A plain nullable column is added without rewriting the table; a trigger
+-- fills it for new and compacted chunks. Existing rows are backfilled in
+-- batches by 0011 and indexed concurrently by 0012.
+ALTER TABLE session_output ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+
+CREATE OR REPLACE FUNCTION session_output_search_vector() RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    NEW.search_vector := to_tsvector('english', session_output_text(NEW.data));
+    RETURN NEW;
+END
+$$;
+
+DROP TRIGGER IF EXISTS session_output_search_vector ON session_output;
+CREATE TRIGGER session_output_search_vector
+    BEFORE INSERT OR UPDATE OF data ON session_output
+    FOR EACH ROW EXECUTE FUNCTION session_output_search_vector();
@@ -0,0 +1,2 @@
+-- Per-session data keys for output encrypted at rest, wrapped by the master key.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS output_key TEXT;
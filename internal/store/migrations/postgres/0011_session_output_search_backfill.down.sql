@@ -0,0 +1 @@
+-- Nothing to undo: rolling back 0009 drops the column.
@@ -0,0 +1,22 @@
+-- migrate:no-transaction
+-- Fills search_vector for output written before 0009, committing every
+-- batch, so no lock is held on the table for longer than one batch. Rows
+-- already filled are skipped, so it can safely run again.
+DO $$
+DECLARE
+    done BIGINT := 0;
+    upto BIGINT;
+BEGIN
+    LOOP
+        SELECT MAX(id) INTO upto
+        FROM (SELECT id FROM session_output WHERE id > done ORDER BY id LIMIT 5000) batch;
+        EXIT WHEN upto IS NULL;
+
+        UPDATE session_output
+        SET search_vector = to_tsvector('english', session_output_text(data))
+        WHERE id > done AND id <= upto AND search_vector IS NULL;
+        COMMIT;
+        done := upto;
+    END LOOP;
+END
+$$;
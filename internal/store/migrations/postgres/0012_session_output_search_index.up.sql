@@ -0,0 +1,4 @@
+-- migrate:no-transaction
+-- Built without blocking writes. If the build fails it leaves an invalid
+-- index behind: drop it before running the migration again.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_session_output_search ON session_output USING GIN (search_vector);
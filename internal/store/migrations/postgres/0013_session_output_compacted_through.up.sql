@@ -0,0 +1,3 @@
+-- How far the retention janitor has settled a session's output: chunks up
+-- to this ID only change again once newer output is written.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS output_compacted_through BIGINT NOT NULL DEFAULT 0;
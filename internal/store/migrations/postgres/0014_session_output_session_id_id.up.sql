@@ -0,0 +1,5 @@
+-- migrate:no-transaction
+-- Finds a session's chunks after a given ID without reading the others. If
+-- the build fails it leaves an invalid index behind: drop it before running
+-- the migration again.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_session_output_session_id_id ON session_output(session_id, id);
@@ -0,0 +1 @@
+ALTER TABLE session_recordings DROP COLUMN IF EXISTS data_key;
@@ -0,0 +1,3 @@
+-- Per-recording data keys for recordings encrypted at rest, wrapped by the
+-- master key. Recordings outlive their session, so they cannot share its key.
+ALTER TABLE session_recordings ADD COLUMN IF NOT EXISTS data_key TEXT;
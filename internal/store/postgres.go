@@ -2,69 +2,45 @@ package store
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/servicenow/claude-terminal-mid-service/internal/config"
 )
 
-// SessionRecord represents a session row stored in PostgreSQL.
-type SessionRecord struct {
-	SessionID            string          `json:"session_id"`
-	UserID               string          `json:"user_id"`
-	WorkspacePath        string          `json:"workspace_path"`
-	Status               string          `json:"status"`
-	EncryptedCredentials json.RawMessage `json:"encrypted_credentials,omitempty"`
-	LastActivity         time.Time       `json:"last_activity"`
-	CreatedAt            time.Time       `json:"created_at"`
-	UpdatedAt            time.Time       `json:"updated_at"`
-}
-
-// OutputChunk represents a row in the session_output table.
-type OutputChunk struct {
-	ID        int64     `json:"id"`
-	SessionID string    `json:"session_id"`
-	Timestamp time.Time `json:"timestamp"`
-	Data      string    `json:"data"`
-}
-
 // PostgresStore implements persistent session storage backed by PostgreSQL.
 type PostgresStore struct {
 	pool *pgxpool.Pool
 }
 
-// migration DDL executed on startup.
-const migrationSQL = `
-CREATE TABLE IF NOT EXISTS sessions (
-    session_id VARCHAR(36) PRIMARY KEY,
-    user_id VARCHAR(255) NOT NULL,
-    workspace_path TEXT NOT NULL,
-    status VARCHAR(50) NOT NULL DEFAULT 'initializing',
-    encrypted_credentials JSONB,
-    last_activity TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
-    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
-    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
-);
-
-CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
-CREATE INDEX IF NOT EXISTS idx_sessions_status ON sessions(status);
-
-CREATE TABLE IF NOT EXISTS session_output (
-    id BIGSERIAL PRIMARY KEY,
-    session_id VARCHAR(36) NOT NULL REFERENCES sessions(session_id) ON DELETE CASCADE,
-    timestamp TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
-    data TEXT NOT NULL
-);
-
-CREATE INDEX IF NOT EXISTS idx_session_output_session_id ON session_output(session_id);
-`
-
-// NewPostgresStore creates a connection pool and runs migrations.
+var _ Store = (*PostgresStore)(nil)
+
+// NewPostgresStore creates a connection pool and applies pending migrations.
 func NewPostgresStore(ctx context.Context, dbCfg config.DatabaseConfig) (*PostgresStore, error) {
+	s, err := ConnectPostgres(ctx, dbCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.Migrate(ctx); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to run database migration: %w", err)
+	}
+
+	log.Info("PostgreSQL migration completed")
+
+	return s, nil
+}
+
+// ConnectPostgres creates a connection pool without touching the schema.
+func ConnectPostgres(ctx context.Context, dbCfg config.DatabaseConfig) (*PostgresStore, error) {
 	dsn := fmt.Sprintf(
 		"postgres://%s:%s@%s:%d/%s?sslmode=%s",
 		dbCfg.User, dbCfg.Password, dbCfg.Host, dbCfg.Port, dbCfg.DBName, dbCfg.SSLMode,
@@ -94,27 +70,193 @@ func NewPostgresStore(ctx context.Context, dbCfg config.DatabaseConfig) (*Postgr
 
 	log.Info("PostgreSQL connection pool established")
 
-	// Run migration.
-	if _, err := pool.Exec(ctx, migrationSQL); err != nil {
-		pool.Close()
-		return nil, fmt.Errorf("failed to run database migration: %w", err)
+	return &PostgresStore{pool: pool}, nil
+}
+
+// migrationLockKey is the pg_advisory_lock key that serializes migrations
+// between replicas starting at the same time.
+const migrationLockKey int64 = 0x636c6175_6465746d
+
+// Migrate applies pending schema migrations and returns them.
+func (s *PostgresStore) Migrate(ctx context.Context) ([]Migration, error) {
+	return s.withMigrationTarget(ctx, func(t migrationTarget, migrations []Migration) ([]Migration, error) {
+		return migrateUp(ctx, t, migrations)
+	})
+}
+
+// Rollback reverts the most recently applied migration and returns it, or
+// nil when no migration is applied.
+func (s *PostgresStore) Rollback(ctx context.Context) (*Migration, error) {
+	var rolledBack *Migration
+	_, err := s.withMigrationTarget(ctx, func(t migrationTarget, migrations []Migration) ([]Migration, error) {
+		m, err := migrateDown(ctx, t, migrations)
+		rolledBack = m
+		return nil, err
+	})
+	return rolledBack, err
+}
+
+// MigrationStatus lists every migration and when it was applied.
+func (s *PostgresStore) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	var status []MigrationStatus
+	_, err := s.withMigrationTarget(ctx, func(t migrationTarget, migrations []Migration) ([]Migration, error) {
+		st, err := migrationStatus(ctx, t, migrations)
+		status = st
+		return nil, err
+	})
+	return status, err
+}
+
+// withMigrationTarget runs fn on a dedicated connection, which session-level
+// advisory locks require.
+func (s *PostgresStore) withMigrationTarget(ctx context.Context, fn func(migrationTarget, []Migration) ([]Migration, error)) ([]Migration, error) {
+	migrations, err := loadMigrations(postgresMigrations, "migrations/postgres")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
 	}
+	defer conn.Release()
+	return fn(&pgMigrationTarget{conn: conn}, migrations)
+}
 
-	log.Info("PostgreSQL migration completed")
+// pgMigrationTarget applies migrations over a single PostgreSQL connection.
+type pgMigrationTarget struct {
+	conn *pgxpool.Conn
+}
 
-	return &PostgresStore{pool: pool}, nil
+func (t *pgMigrationTarget) lock(ctx context.Context) (func(), error) {
+	if _, err := t.conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	// schema_migrations is created under the lock: concurrent CREATE TABLE IF
+	// NOT EXISTS can still fail on the catalog's unique constraints.
+	_, err := t.conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	unlock := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := t.conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey); err != nil {
+			log.WithError(err).Warn("Failed to release migration lock")
+		}
+	}
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return unlock, nil
+}
+
+func (t *pgMigrationTarget) applied(ctx context.Context) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	rows, err := t.conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err == nil {
+		for rows.Next() {
+			var version int
+			var at time.Time
+			if err := rows.Scan(&version, &at); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+			}
+			applied[version] = at
+		}
+		rows.Close()
+		err = rows.Err()
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42P01" { // undefined_table
+		// Nothing has been migrated with versioning yet.
+		return applied, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	return applied, nil
+}
+
+func (t *pgMigrationTarget) run(ctx context.Context, m Migration, up bool) error {
+	script, noTx, record, args := m.Down, m.DownNoTransaction, `DELETE FROM schema_migrations WHERE version = $1`, []any{m.Version}
+	if up {
+		script, noTx, record, args = m.Up, m.UpNoTransaction, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, []any{m.Version, m.Name}
+	}
+	if noTx {
+		// A single statement sent on its own runs outside any transaction
+		// block. If recording it fails, the script runs again next time.
+		if _, err := t.conn.Exec(ctx, script); err != nil {
+			return err
+		}
+		_, err := t.conn.Exec(ctx, record, args...)
+		return err
+	}
+
+	tx, err := t.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Without arguments pgx uses the simple protocol, which allows several
+	// statements per script.
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// sessionColumns is the column list read by scanSession, in scan order.
+const sessionColumns = `session_id, user_id, workspace_path, workspace_type, COALESCE(profile, ''), COALESCE(label, ''), status, COALESCE(termination_reason, ''), exit_code, COALESCE(exit_signal, ''), terminated_at, encrypted_credentials, COALESCE(supervisor_socket, ''), COALESCE(resumed_from, ''), COALESCE(output_key, ''), last_activity, created_at, updated_at`
+
+// scanSession reads one sessions row selected with sessionColumns.
+func scanSession(row pgx.Row) (SessionRecord, error) {
+	var rec SessionRecord
+	err := row.Scan(
+		&rec.SessionID,
+		&rec.UserID,
+		&rec.WorkspacePath,
+		&rec.WorkspaceType,
+		&rec.Profile,
+		&rec.Label,
+		&rec.Status,
+		&rec.TerminationReason,
+		&rec.ExitCode,
+		&rec.ExitSignal,
+		&rec.TerminatedAt,
+		&rec.EncryptedCredentials,
+		&rec.SupervisorSocket,
+		&rec.ResumedFrom,
+		&rec.OutputKey,
+		&rec.LastActivity,
+		&rec.CreatedAt,
+		&rec.UpdatedAt,
+	)
+	return rec, err
 }
 
 // SaveSession inserts or updates (upserts) a session record.
 func (s *PostgresStore) SaveSession(ctx context.Context, rec SessionRecord) error {
 	query := `
-		INSERT INTO sessions (session_id, user_id, workspace_path, status, encrypted_credentials, last_activity, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		INSERT INTO sessions (session_id, user_id, workspace_path, workspace_type, profile, label, status, encrypted_credentials, supervisor_socket, resumed_from, last_activity, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11, $12, NOW())
 		ON CONFLICT (session_id) DO UPDATE SET
 			user_id = EXCLUDED.user_id,
 			workspace_path = EXCLUDED.workspace_path,
+			workspace_type = EXCLUDED.workspace_type,
+			profile = EXCLUDED.profile,
+			label = EXCLUDED.label,
 			status = EXCLUDED.status,
 			encrypted_credentials = EXCLUDED.encrypted_credentials,
+			supervisor_socket = EXCLUDED.supervisor_socket,
 			last_activity = EXCLUDED.last_activity,
 			updated_at = NOW()
 	`
@@ -122,8 +264,13 @@ func (s *PostgresStore) SaveSession(ctx context.Context, rec SessionRecord) erro
 		rec.SessionID,
 		rec.UserID,
 		rec.WorkspacePath,
+		rec.WorkspaceType,
+		rec.Profile,
+		rec.Label,
 		rec.Status,
 		rec.EncryptedCredentials,
+		rec.SupervisorSocket,
+		rec.ResumedFrom,
 		rec.LastActivity,
 		rec.CreatedAt,
 	)
@@ -136,37 +283,50 @@ func (s *PostgresStore) SaveSession(ctx context.Context, rec SessionRecord) erro
 // GetSession retrieves a single session by ID.
 func (s *PostgresStore) GetSession(ctx context.Context, sessionID string) (*SessionRecord, error) {
 	query := `
-		SELECT session_id, user_id, workspace_path, status, encrypted_credentials, last_activity, created_at, updated_at
+		SELECT ` + sessionColumns + `
 		FROM sessions
 		WHERE session_id = $1
 	`
 	row := s.pool.QueryRow(ctx, query, sessionID)
 
-	var rec SessionRecord
-	if err := row.Scan(
-		&rec.SessionID,
-		&rec.UserID,
-		&rec.WorkspacePath,
-		&rec.Status,
-		&rec.EncryptedCredentials,
-		&rec.LastActivity,
-		&rec.CreatedAt,
-		&rec.UpdatedAt,
-	); err != nil {
+	rec, err := scanSession(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
 		return nil, fmt.Errorf("GetSession: %w", err)
 	}
 	return &rec, nil
 }
 
-// GetSessionsForUser returns all sessions belonging to a user.
-func (s *PostgresStore) GetSessionsForUser(ctx context.Context, userID string) ([]SessionRecord, error) {
+// SetOutputKey stores a session's wrapped output data key unless it already
+// has one.
+func (s *PostgresStore) SetOutputKey(ctx context.Context, sessionID, wrappedKey string) (string, error) {
 	query := `
-		SELECT session_id, user_id, workspace_path, status, encrypted_credentials, last_activity, created_at, updated_at
-		FROM sessions
-		WHERE user_id = $1
-		ORDER BY created_at DESC
+		UPDATE sessions SET output_key = COALESCE(output_key, $2)
+		WHERE session_id = $1
+		RETURNING output_key
 	`
-	rows, err := s.pool.Query(ctx, query, userID)
+	var key string
+	err := s.pool.QueryRow(ctx, query, sessionID, wrappedKey).Scan(&key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("SetOutputKey: %w", err)
+	}
+	return key, nil
+}
+
+// GetSessionsForUser returns the sessions belonging to a user that match f.
+func (s *PostgresStore) GetSessionsForUser(ctx context.Context, userID string, f SessionFilter) ([]SessionRecord, error) {
+	cond, args := f.where([]any{userID}, func(n int) string { return fmt.Sprintf("$%d", n) })
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = $1` + cond + `
+		ORDER BY created_at DESC, session_id` + f.page()
+	rows, err := s.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("GetSessionsForUser: %w", err)
 	}
@@ -174,17 +334,8 @@ func (s *PostgresStore) GetSessionsForUser(ctx context.Context, userID string) (
 
 	var records []SessionRecord
 	for rows.Next() {
-		var rec SessionRecord
-		if err := rows.Scan(
-			&rec.SessionID,
-			&rec.UserID,
-			&rec.WorkspacePath,
-			&rec.Status,
-			&rec.EncryptedCredentials,
-			&rec.LastActivity,
-			&rec.CreatedAt,
-			&rec.UpdatedAt,
-		); err != nil {
+		rec, err := scanSession(rows)
+		if err != nil {
 			return nil, fmt.Errorf("GetSessionsForUser scan: %w", err)
 		}
 		records = append(records, rec)
@@ -202,6 +353,22 @@ func (s *PostgresStore) UpdateSessionStatus(ctx context.Context, sessionID, stat
 	return nil
 }
 
+// MarkSessionTerminated sets status='terminated', keeping 'failed' for a
+// session that never started, and records why the session ended. exitCode is
+// nil when the process did not exit normally.
+func (s *PostgresStore) MarkSessionTerminated(ctx context.Context, sessionID, reason string, exitCode *int, exitSignal string) error {
+	query := `
+		UPDATE sessions
+		SET status = CASE WHEN status = 'failed' THEN status ELSE 'terminated' END, termination_reason = NULLIF($1, ''), exit_code = $2, exit_signal = NULLIF($3, ''), terminated_at = NOW(), updated_at = NOW()
+		WHERE session_id = $4
+	`
+	_, err := s.pool.Exec(ctx, query, reason, exitCode, exitSignal, sessionID)
+	if err != nil {
+		return fmt.Errorf("MarkSessionTerminated: %w", err)
+	}
+	return nil
+}
+
 // UpdateLastActivity bumps the last_activity timestamp.
 func (s *PostgresStore) UpdateLastActivity(ctx context.Context, sessionID string, t time.Time) error {
 	query := `UPDATE sessions SET last_activity = $1, updated_at = NOW() WHERE session_id = $2`
@@ -212,12 +379,21 @@ func (s *PostgresStore) UpdateLastActivity(ctx context.Context, sessionID string
 	return nil
 }
 
-// SaveOutputChunk appends a terminal output chunk for a session.
-func (s *PostgresStore) SaveOutputChunk(ctx context.Context, sessionID string, timestamp time.Time, data string) error {
-	query := `INSERT INTO session_output (session_id, timestamp, data) VALUES ($1, $2, $3)`
-	_, err := s.pool.Exec(ctx, query, sessionID, timestamp, data)
+// SaveOutputChunks appends a batch of output chunks for a session with a
+// single COPY.
+func (s *PostgresStore) SaveOutputChunks(ctx context.Context, sessionID string, chunks []OutputChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	_, err := s.pool.CopyFrom(ctx,
+		pgx.Identifier{"session_output"},
+		[]string{"session_id", "timestamp", "data"},
+		pgx.CopyFromSlice(len(chunks), func(i int) ([]any, error) {
+			return []any{sessionID, chunks[i].Timestamp, chunks[i].Data}, nil
+		}),
+	)
 	if err != nil {
-		return fmt.Errorf("SaveOutputChunk: %w", err)
+		return fmt.Errorf("SaveOutputChunks: %w", err)
 	}
 	return nil
 }
@@ -254,6 +430,270 @@ func (s *PostgresStore) GetOutputChunks(ctx context.Context, sessionID string, l
 	return chunks, rows.Err()
 }
 
+// GetOutputChunksAfter returns up to limit output chunks with ID greater
+// than afterID, oldest first.
+func (s *PostgresStore) GetOutputChunksAfter(ctx context.Context, sessionID string, afterID int64, limit int) ([]OutputChunk, error) {
+	query := `
+		SELECT id, session_id, timestamp, data
+		FROM session_output
+		WHERE session_id = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3
+	`
+	rows, err := s.pool.Query(ctx, query, sessionID, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetOutputChunksAfter: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []OutputChunk
+	for rows.Next() {
+		var c OutputChunk
+		if err := rows.Scan(&c.ID, &c.SessionID, &c.Timestamp, &c.Data); err != nil {
+			return nil, fmt.Errorf("GetOutputChunksAfter scan: %w", err)
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// SearchOutput runs a full-text search over session_output.search_vector
+// (see migrations 0009, 0011 and 0012). Snippets come from ts_headline over
+// the HTML-escaped text, so only the <mark> tags it adds are markup.
+func (s *PostgresStore) SearchOutput(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	query := `
+		WITH q AS (SELECT websearch_to_tsquery('english', $1) AS query)
+		SELECT o.session_id, s.user_id, COALESCE(s.label, ''), o.id,
+			(SELECT COUNT(*) FROM session_output p WHERE p.session_id = o.session_id AND p.id < o.id),
+			o.timestamp,
+			ts_headline('english',
+				replace(replace(replace(session_output_text(o.data), '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+				q.query, 'StartSel=<mark>, StopSel=</mark>, MinWords=8, MaxWords=30, MaxFragments=2'),
+			ts_rank(o.search_vector, q.query) AS rank
+		FROM session_output o
+		JOIN sessions s ON s.session_id = o.session_id
+		CROSS JOIN q
+		WHERE o.search_vector @@ q.query
+			AND ($2 = '' OR s.user_id = $2)
+		ORDER BY rank DESC, o.id DESC
+		LIMIT $3
+	`
+	rows, err := s.pool.Query(ctx, query, q.Query, q.UserID, q.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("SearchOutput: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var h SearchHit
+		var rank float32
+		if err := rows.Scan(&h.SessionID, &h.UserID, &h.Label, &h.ChunkID, &h.ChunkIndex, &h.Timestamp, &h.Snippet, &rank); err != nil {
+			return nil, fmt.Errorf("SearchOutput scan: %w", err)
+		}
+		h.Rank = float64(rank)
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// CompactOutput applies p to the output of every session with chunks
+// written since sessions.output_compacted_through (migration 0013). Each
+// check is an index lookup (migration 0014), so a pass does not read the
+// output of sessions that have not changed.
+func (s *PostgresStore) CompactOutput(ctx context.Context, p CompactionPolicy) (CompactionResult, error) {
+	var res CompactionResult
+	rows, err := s.pool.Query(ctx, `
+		SELECT s.session_id
+		FROM sessions s
+		WHERE EXISTS (
+			SELECT 1 FROM session_output o
+			WHERE o.session_id = s.session_id AND o.id > s.output_compacted_through
+		)
+	`)
+	if err != nil {
+		return res, fmt.Errorf("CompactOutput: %w", err)
+	}
+	sessionIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return res, fmt.Errorf("CompactOutput: %w", err)
+	}
+
+	res.Scanned = len(sessionIDs)
+	for _, sessionID := range sessionIDs {
+		edit, err := s.compactSessionOutput(ctx, sessionID, p)
+		if err != nil {
+			return res, fmt.Errorf("CompactOutput %s: %w", sessionID, err)
+		}
+		if !edit.empty() {
+			res.Sessions++
+			res.MergedChunks += edit.merged
+			res.RemovedChunks += edit.capped
+		}
+	}
+	return res, nil
+}
+
+// compactSessionOutput plans and applies the compaction of one session's
+// output in a transaction, and records how far it is settled. Chunks written
+// meanwhile get higher IDs and are left alone.
+func (s *PostgresStore) compactSessionOutput(ctx context.Context, sessionID string, p CompactionPolicy) (outputEdit, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return outputEdit{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT id, session_id, timestamp, data FROM session_output WHERE session_id = $1 ORDER BY id`, sessionID)
+	if err != nil {
+		return outputEdit{}, err
+	}
+	chunks, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (OutputChunk, error) {
+		var c OutputChunk
+		err := row.Scan(&c.ID, &c.SessionID, &c.Timestamp, &c.Data)
+		return c, err
+	})
+	if err != nil {
+		return outputEdit{}, err
+	}
+
+	edit := planCompaction(chunks, p)
+	for _, c := range edit.update {
+		if _, err := tx.Exec(ctx, `UPDATE session_output SET data = $1 WHERE id = $2`, c.Data, c.ID); err != nil {
+			return outputEdit{}, err
+		}
+	}
+	if len(edit.remove) > 0 {
+		if _, err := tx.Exec(ctx, `DELETE FROM session_output WHERE id = ANY($1)`, edit.remove); err != nil {
+			return outputEdit{}, err
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE sessions SET output_compacted_through = $1
+		WHERE session_id = $2 AND output_compacted_through < $1
+	`, edit.through, sessionID); err != nil {
+		return outputEdit{}, err
+	}
+	return edit, tx.Commit(ctx)
+}
+
+// SaveRecording stores the header of a session recording.
+func (s *PostgresStore) SaveRecording(ctx context.Context, rec RecordingRecord) error {
+	query := `
+		INSERT INTO session_recordings (session_id, user_id, header, started_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (session_id) DO NOTHING
+	`
+	_, err := s.pool.Exec(ctx, query, rec.SessionID, rec.UserID, rec.Header, rec.StartedAt)
+	if err != nil {
+		return fmt.Errorf("SaveRecording: %w", err)
+	}
+	return nil
+}
+
+// SetRecordingKey stores a recording's wrapped data key unless it already
+// has one.
+func (s *PostgresStore) SetRecordingKey(ctx context.Context, sessionID, wrappedKey string) (string, error) {
+	query := `
+		UPDATE session_recordings SET data_key = COALESCE(data_key, $2)
+		WHERE session_id = $1
+		RETURNING data_key
+	`
+	var key string
+	err := s.pool.QueryRow(ctx, query, sessionID, wrappedKey).Scan(&key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("SetRecordingKey: %w", err)
+	}
+	return key, nil
+}
+
+// SaveRecordingEvents appends a batch of events to a session recording with
+// one multi-row insert. Batches may be written out of order; Seq restores
+// the order on read.
+func (s *PostgresStore) SaveRecordingEvents(ctx context.Context, sessionID string, events []RecordingEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	values := make([]string, len(events))
+	args := []any{sessionID}
+	for i, ev := range events {
+		n := len(args)
+		values[i] = fmt.Sprintf("($1, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4)
+		args = append(args, ev.Seq, ev.Elapsed, ev.Kind, ev.Data)
+	}
+	query := `
+		INSERT INTO session_recording_events (session_id, seq, elapsed, kind, data)
+		VALUES ` + strings.Join(values, ", ") + `
+		ON CONFLICT (session_id, seq) DO NOTHING`
+	_, err := s.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("SaveRecordingEvents: %w", err)
+	}
+	return nil
+}
+
+// GetRecording returns a session recording's header and events in order.
+// It returns ErrNotFound when there is no recording for the session.
+func (s *PostgresStore) GetRecording(ctx context.Context, sessionID string) (*RecordingRecord, []RecordingEvent, error) {
+	rec := RecordingRecord{SessionID: sessionID}
+	err := s.pool.QueryRow(ctx,
+		`SELECT user_id, header, started_at, COALESCE(data_key, '') FROM session_recordings WHERE session_id = $1`,
+		sessionID,
+	).Scan(&rec.UserID, &rec.Header, &rec.StartedAt, &rec.DataKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("GetRecording: %w", err)
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT seq, elapsed, kind, data
+		FROM session_recording_events
+		WHERE session_id = $1
+		ORDER BY seq
+	`, sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GetRecording events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []RecordingEvent
+	for rows.Next() {
+		var ev RecordingEvent
+		if err := rows.Scan(&ev.Seq, &ev.Elapsed, &ev.Kind, &ev.Data); err != nil {
+			return nil, nil, fmt.Errorf("GetRecording scan: %w", err)
+		}
+		events = append(events, ev)
+	}
+	return &rec, events, rows.Err()
+}
+
+// PurgeRecordings deletes recordings, with their events, that started before
+// startedBefore, except those of live sessions.
+func (s *PostgresStore) PurgeRecordings(ctx context.Context, startedBefore time.Time) (int64, error) {
+	query := `
+		WITH purged AS (
+			DELETE FROM session_recordings
+			WHERE started_at < $1
+			AND session_id NOT IN (SELECT session_id FROM sessions WHERE status IN ('active', 'initializing'))
+			RETURNING session_id
+		), purged_events AS (
+			DELETE FROM session_recording_events
+			WHERE session_id IN (SELECT session_id FROM purged)
+		)
+		SELECT COUNT(*) FROM purged
+	`
+	var n int64
+	if err := s.pool.QueryRow(ctx, query, startedBefore).Scan(&n); err != nil {
+		return 0, fmt.Errorf("PurgeRecordings: %w", err)
+	}
+	return n, nil
+}
+
 // DeleteSession removes a session and its output (cascade).
 func (s *PostgresStore) DeleteSession(ctx context.Context, sessionID string) error {
 	query := `DELETE FROM sessions WHERE session_id = $1`
@@ -264,10 +704,25 @@ func (s *PostgresStore) DeleteSession(ctx context.Context, sessionID string) err
 	return nil
 }
 
+// PurgeTerminatedSessions deletes sessions, with their output (cascade),
+// that ended before endedBefore.
+func (s *PostgresStore) PurgeTerminatedSessions(ctx context.Context, endedBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM sessions
+		WHERE status NOT IN ('active', 'initializing')
+		AND COALESCE(terminated_at, updated_at) < $1
+	`
+	tag, err := s.pool.Exec(ctx, query, endedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("PurgeTerminatedSessions: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
 // GetActiveSessions returns all sessions with active or initializing status.
 func (s *PostgresStore) GetActiveSessions(ctx context.Context) ([]SessionRecord, error) {
 	query := `
-		SELECT session_id, user_id, workspace_path, status, encrypted_credentials, last_activity, created_at, updated_at
+		SELECT ` + sessionColumns + `
 		FROM sessions
 		WHERE status IN ('active', 'initializing')
 		ORDER BY created_at DESC
@@ -280,17 +735,8 @@ func (s *PostgresStore) GetActiveSessions(ctx context.Context) ([]SessionRecord,
 
 	var records []SessionRecord
 	for rows.Next() {
-		var rec SessionRecord
-		if err := rows.Scan(
-			&rec.SessionID,
-			&rec.UserID,
-			&rec.WorkspacePath,
-			&rec.Status,
-			&rec.EncryptedCredentials,
-			&rec.LastActivity,
-			&rec.CreatedAt,
-			&rec.UpdatedAt,
-		); err != nil {
+		rec, err := scanSession(rows)
+		if err != nil {
 			return nil, fmt.Errorf("GetActiveSessions scan: %w", err)
 		}
 		records = append(records, rec)
@@ -300,10 +746,11 @@ func (s *PostgresStore) GetActiveSessions(ctx context.Context) ([]SessionRecord,
 
 // MarkStaleSessionsTerminated sets status='terminated' for sessions that were
 // active or initializing (i.e., they had no running process after a restart).
+// Their termination reason is recorded as a server shutdown.
 func (s *PostgresStore) MarkStaleSessionsTerminated(ctx context.Context) (int64, error) {
 	query := `
 		UPDATE sessions
-		SET status = 'terminated', updated_at = NOW()
+		SET status = 'terminated', termination_reason = COALESCE(termination_reason, 'server_shutdown'), terminated_at = NOW(), updated_at = NOW()
 		WHERE status IN ('active', 'initializing')
 	`
 	tag, err := s.pool.Exec(ctx, query)
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+)
+
+// CompactionPolicy bounds the output stored for each session.
+type CompactionPolicy struct {
+	MaxBytes      int64     // per session; the oldest output beyond it is removed. 0 means no cap
+	MergeBefore   time.Time // chunks written before this are merged
+	MergeMaxBytes int       // largest merged chunk
+}
+
+// CompactionResult reports what CompactOutput changed.
+type CompactionResult struct {
+	Scanned       int   `json:"scanned"`        // sessions whose output was read
+	Sessions      int   `json:"sessions"`       // sessions whose output changed
+	MergedChunks  int64 `json:"merged_chunks"`  // chunks folded into an earlier one
+	RemovedChunks int64 `json:"removed_chunks"` // chunks removed by the byte cap
+}
+
+// outputEdit is the change compaction makes to one session's output.
+type outputEdit struct {
+	update  []OutputChunk // rows, by ID, whose Data becomes a merged blob
+	remove  []int64       // rows deleted: merged into an update or over the cap
+	merged  int64
+	capped  int64
+	through int64 // chunks up to this ID are settled
+}
+
+func (e outputEdit) empty() bool {
+	return len(e.update) == 0 && len(e.remove) == 0
+}
+
+// planCompaction works out how to bring a session's chunks, oldest first,
+// within p. A merged blob keeps the ID and timestamp of its first chunk, so
+// reading in ID order still returns output in the order it was written.
+//
+// The chunks before the first recent one are settled: once the edit is
+// applied, only output written later can make them change again. Stores
+// remember edit.through and skip a session until it has newer chunks.
+func planCompaction(chunks []OutputChunk, p CompactionPolicy) outputEdit {
+	var edit outputEdit
+	for _, c := range chunks {
+		if !c.Timestamp.Before(p.MergeBefore) {
+			break
+		}
+		edit.through = c.ID
+	}
+
+	// Drop whole chunks, oldest first, until the rest fits the cap.
+	if p.MaxBytes > 0 {
+		var total int64
+		for _, c := range chunks {
+			total += int64(len(c.Data))
+		}
+		for len(chunks) > 0 && total > p.MaxBytes {
+			total -= int64(len(chunks[0].Data))
+			edit.remove = append(edit.remove, chunks[0].ID)
+			edit.capped++
+			chunks = chunks[1:]
+		}
+	}
+
+	// Merge runs of consecutive old chunks into blobs of at most
+	// MergeMaxBytes.
+	for i := 0; i < len(chunks); {
+		if !chunks[i].Timestamp.Before(p.MergeBefore) {
+			break
+		}
+		blob := chunks[i]
+		size := len(blob.Data)
+		j := i + 1
+		for ; j < len(chunks) && chunks[j].Timestamp.Before(p.MergeBefore); j++ {
+			if size+len(chunks[j].Data) > p.MergeMaxBytes {
+				break
+			}
+			size += len(chunks[j].Data)
+		}
+		if j-i > 1 {
+			data := make([]byte, 0, size)
+			for _, c := range chunks[i:j] {
+				data = append(data, c.Data...)
+			}
+			for _, c := range chunks[i+1 : j] {
+				edit.remove = append(edit.remove, c.ID)
+			}
+			blob.Data = string(data)
+			edit.update = append(edit.update, blob)
+			edit.merged += int64(j - i - 1)
+		}
+		i = j
+	}
+	return edit
+}
+
+// RetentionResult reports one janitor pass.
+type RetentionResult struct {
+	Purged           int64
+	PurgedRecordings int64
+	CompactionResult
+}
+
+// ApplyRetention purges sessions that ended more than cfg.Days ago and
+// recordings that started more than cfg.RecordingDays ago, then compacts the
+// output of the remaining sessions.
+func ApplyRetention(ctx context.Context, s Store, cfg config.RetentionConfig, now time.Time) (RetentionResult, error) {
+	var res RetentionResult
+	if cfg.Days > 0 {
+		n, err := s.PurgeTerminatedSessions(ctx, now.AddDate(0, 0, -cfg.Days))
+		if err != nil {
+			return res, err
+		}
+		res.Purged = n
+	}
+	if cfg.RecordingDays > 0 {
+		n, err := s.PurgeRecordings(ctx, now.AddDate(0, 0, -cfg.RecordingDays))
+		if err != nil {
+			return res, err
+		}
+		res.PurgedRecordings = n
+	}
+
+	compacted, err := s.CompactOutput(ctx, CompactionPolicy{
+		MaxBytes:      int64(cfg.MaxOutputBytes),
+		MergeBefore:   now.Add(-time.Duration(cfg.CompactAfterMinutes) * time.Minute),
+		MergeMaxBytes: cfg.CompactBlobBytes,
+	})
+	res.CompactionResult = compacted
+	return res, err
+}
+
+// StartJanitor applies the retention policy every cfg.JanitorIntervalMinutes
+// until ctx is cancelled.
+func StartJanitor(ctx context.Context, s Store, cfg config.RetentionConfig) {
+	interval := time.Duration(cfg.JanitorIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		runJanitor(ctx, s, cfg)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runJanitor(ctx context.Context, s Store, cfg config.RetentionConfig) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	res, err := ApplyRetention(ctx, s, cfg, time.Now())
+	fields := log.Fields{
+		"purged_sessions":   res.Purged,
+		"purged_recordings": res.PurgedRecordings,
+		"scanned_sessions":  res.Scanned,
+		"merged_chunks":     res.MergedChunks,
+		"removed_chunks":    res.RemovedChunks,
+	}
+	if err != nil {
+		log.WithError(err).WithFields(fields).Warn("Retention janitor failed")
+		return
+	}
+	if res.Purged > 0 || res.PurgedRecordings > 0 || res.Sessions > 0 {
+		log.WithFields(fields).Info("Applied output retention")
+	}
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+)
+
+func TestPlanCompaction(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	chunks := func(sizes ...int) []OutputChunk {
+		var out []OutputChunk
+		for i, n := range sizes {
+			data := make([]byte, n)
+			for j := range data {
+				data[j] = byte('a' + i)
+			}
+			out = append(out, OutputChunk{ID: int64(i + 1), Timestamp: old, Data: string(data)})
+		}
+		return out
+	}
+	policy := CompactionPolicy{MergeBefore: time.Now(), MergeMaxBytes: 10}
+
+	tests := []struct {
+		name    string
+		chunks  []OutputChunk
+		policy  CompactionPolicy
+		want    string // updated IDs:sizes, then removed IDs
+		through int64
+	}{
+		{"merges runs up to the blob size", chunks(3, 3, 3, 3, 3), policy, "[1:9 4:6] [2 3 5]", 5},
+		{"leaves large chunks alone", chunks(12, 2, 12), policy, "[] []", 3},
+		{"caps before merging", chunks(5, 5, 5), CompactionPolicy{MaxBytes: 10, MergeBefore: time.Now(), MergeMaxBytes: 10}, "[2:10] [1 3]", 3},
+		{"keeps recent chunks", chunks(1, 1), CompactionPolicy{MergeBefore: old, MergeMaxBytes: 10}, "[] []", 0},
+		{"merging disabled", chunks(1, 1), CompactionPolicy{MergeBefore: time.Now()}, "[] []", 2},
+	}
+	for _, tt := range tests {
+		edit := planCompaction(tt.chunks, tt.policy)
+		updated := []string{}
+		for _, c := range edit.update {
+			updated = append(updated, fmt.Sprintf("%d:%d", c.ID, len(c.Data)))
+		}
+		if got := fmt.Sprintf("%v %v", updated, edit.remove); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+		if edit.through != tt.through {
+			t.Errorf("%s: settled through %d, want %d", tt.name, edit.through, tt.through)
+		}
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	now := time.Now()
+	s.SaveSession(ctx, SessionRecord{SessionID: "ended", UserID: "alice", Status: "active", CreatedAt: now})
+	s.MarkSessionTerminated(ctx, "ended", "user_terminated", nil, "")
+
+	// Days 0 never purges: sessions are deleted when they terminate instead.
+	cfg := config.RetentionConfig{Days: 0, CompactAfterMinutes: 60, CompactBlobBytes: 1024}
+	if res, err := ApplyRetention(ctx, s, cfg, now.AddDate(1, 0, 0)); err != nil || res.Purged != 0 {
+		t.Errorf("ApplyRetention(days=0) = %+v, %v", res, err)
+	}
+
+	cfg.Days = 30
+	if res, err := ApplyRetention(ctx, s, cfg, now.AddDate(0, 0, 29)); err != nil || res.Purged != 0 {
+		t.Errorf("ApplyRetention within retention = %+v, %v", res, err)
+	}
+	if res, err := ApplyRetention(ctx, s, cfg, now.AddDate(0, 0, 31)); err != nil || res.Purged != 1 {
+		t.Errorf("ApplyRetention after retention = %+v, %v; want 1 purged", res, err)
+	}
+	if _, err := s.GetSession(ctx, "ended"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected session purged, got %v", err)
+	}
+
+	// Recordings have their own retention, which 0 turns off.
+	s.SaveRecording(ctx, RecordingRecord{SessionID: "ended", UserID: "alice", Header: "{}", StartedAt: now})
+	if res, err := ApplyRetention(ctx, s, cfg, now.AddDate(1, 0, 0)); err != nil || res.PurgedRecordings != 0 {
+		t.Errorf("ApplyRetention(recording days=0) = %+v, %v", res, err)
+	}
+	cfg.RecordingDays = 90
+	if res, err := ApplyRetention(ctx, s, cfg, now.AddDate(0, 0, 89)); err != nil || res.PurgedRecordings != 0 {
+		t.Errorf("ApplyRetention within recording retention = %+v, %v", res, err)
+	}
+	if res, err := ApplyRetention(ctx, s, cfg, now.AddDate(0, 0, 91)); err != nil || res.PurgedRecordings != 1 {
+		t.Errorf("ApplyRetention after recording retention = %+v, %v; want 1 purged", res, err)
+	}
+}
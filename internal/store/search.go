@@ -0,0 +1,138 @@
+package store
+
+import (
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/terminal"
+)
+
+// Snippets mark matched words with these tags; everything else in a snippet
+// is HTML-escaped, so snippets can be rendered as HTML.
+const (
+	snippetStart = "<mark>"
+	snippetStop  = "</mark>"
+)
+
+// snippetContext is how many runes of text a snippet keeps on either side of
+// the first match, for the stores without full-text search.
+const snippetContext = 60
+
+// SearchQuery selects the stored output to search.
+type SearchQuery struct {
+	// Query holds the words to find. PostgreSQL reads it as a web search
+	// (quoted phrases, "or", -word); the other stores require every word to
+	// appear, case-insensitively.
+	Query string
+	// UserID restricts the search to one user's sessions; empty searches all.
+	UserID string
+	// Limit caps the number of hits returned.
+	Limit int
+}
+
+// SearchHit is an output chunk that matches a search.
+type SearchHit struct {
+	SessionID string
+	UserID    string
+	Label     string
+	ChunkID   int64 // OutputChunk.ID, for GetOutputChunksAfter
+	// ChunkIndex is the chunk's 0-based position in the session's stored
+	// output, i.e. in its transcript.
+	ChunkIndex int64
+	Timestamp  time.Time
+	Snippet    string  // HTML-escaped text around the match, words in <mark>
+	Rank       float64 // higher is better; only comparable within one search
+}
+
+// searchTerms splits a query into the lowercase words the stores without
+// full-text search look for.
+func searchTerms(query string) []string {
+	var terms []string
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		word = strings.Trim(word, `"'`)
+		if word != "" {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// matchOutput reports whether a chunk's text, escape sequences removed,
+// contains every term. It returns the number of term occurrences as rank
+// and a snippet around the first match.
+func matchOutput(data string, terms []string) (bool, float64, string) {
+	text := terminal.NewConverter(terminal.FormatText).Convert(data)
+	lower := strings.ToLower(text)
+	// Lowercasing can change byte lengths; fall back to the original text's
+	// offsets only when they still line up.
+	if len(lower) != len(text) {
+		text = lower
+	}
+
+	first := -1
+	var rank float64
+	for _, term := range terms {
+		i := strings.Index(lower, term)
+		if i < 0 {
+			return false, 0, ""
+		}
+		if first < 0 || i < first {
+			first = i
+		}
+		rank += float64(strings.Count(lower, term))
+	}
+	if len(terms) == 0 {
+		return false, 0, ""
+	}
+	return true, rank, buildSnippet(text, lower, first, terms)
+}
+
+// buildSnippet cuts text around offset first and marks every term in it.
+func buildSnippet(text, lower string, first int, terms []string) string {
+	start := first
+	for n := 0; start > 0 && n < snippetContext; n++ {
+		start--
+		for start > 0 && !utf8.RuneStart(text[start]) {
+			start--
+		}
+	}
+	end := first
+	for n := 0; end < len(text) && n < 2*snippetContext; n++ {
+		end++
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	for i := start; i < end; {
+		matched := ""
+		for _, term := range terms {
+			if strings.HasPrefix(lower[i:], term) && len(term) > len(matched) {
+				matched = term
+			}
+		}
+		if matched != "" {
+			b.WriteString(snippetStart)
+			b.WriteString(html.EscapeString(text[i : i+len(matched)]))
+			b.WriteString(snippetStop)
+			i += len(matched)
+			continue
+		}
+		j := i + 1
+		for j < len(text) && !utf8.RuneStart(text[j]) {
+			j++
+		}
+		b.WriteString(html.EscapeString(text[i:j]))
+		i = j
+	}
+	if end < len(text) {
+		b.WriteString(" …")
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
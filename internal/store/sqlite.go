@@ -0,0 +1,723 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
+)
+
+// SQLiteStore implements persistent session storage in an embedded SQLite
+// database file, for single-host deployments without PostgreSQL.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+// sqliteSchema mirrors the PostgreSQL schema.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+    session_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    workspace_path TEXT NOT NULL,
+    workspace_type TEXT NOT NULL DEFAULT 'isolated',
+    status TEXT NOT NULL DEFAULT 'initializing',
+    termination_reason TEXT,
+    exit_code INTEGER,
+    exit_signal TEXT,
+    encrypted_credentials TEXT,
+    last_activity DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_status ON sessions(status);
+
+CREATE TABLE IF NOT EXISTS session_output (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id TEXT NOT NULL REFERENCES sessions(session_id) ON DELETE CASCADE,
+    timestamp DATETIME NOT NULL,
+    data TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_output_session_id ON session_output(session_id);
+
+-- Recordings are audit records: they deliberately do not reference sessions,
+-- whose rows are deleted when the session is terminated.
+CREATE TABLE IF NOT EXISTS session_recordings (
+    session_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    header TEXT NOT NULL,
+    started_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_recordings_user_id ON session_recordings(user_id);
+
+CREATE TABLE IF NOT EXISTS session_recording_events (
+    session_id TEXT NOT NULL,
+    seq INTEGER NOT NULL,
+    elapsed REAL NOT NULL,
+    kind TEXT NOT NULL,
+    data TEXT NOT NULL,
+    PRIMARY KEY (session_id, seq)
+);
+`
+
+// sqliteUpgrades change a database created from sqliteSchema, in order.
+// PRAGMA user_version counts those applied; only ever append to the list.
+var sqliteUpgrades = []string{
+	// 1: ended sessions are kept until the retention janitor purges them.
+	`ALTER TABLE sessions ADD COLUMN terminated_at DATETIME;
+	UPDATE sessions SET terminated_at = updated_at WHERE status NOT IN ('active', 'initializing');
+	CREATE INDEX IF NOT EXISTS idx_sessions_terminated_at ON sessions(terminated_at);`,
+	// 2: what is needed to reattach to a supervised session after a restart.
+	`ALTER TABLE sessions ADD COLUMN profile TEXT;
+	ALTER TABLE sessions ADD COLUMN supervisor_socket TEXT;`,
+	// 3: the terminated session a resumed session continues.
+	`ALTER TABLE sessions ADD COLUMN resumed_from TEXT;`,
+	// 4: labels and the per-user history listing.
+	`ALTER TABLE sessions ADD COLUMN label TEXT;
+	CREATE INDEX IF NOT EXISTS idx_sessions_user_created ON sessions(user_id, created_at DESC);`,
+	// 5: per-session data keys for output encrypted at rest.
+	`ALTER TABLE sessions ADD COLUMN output_key TEXT;`,
+	// 6: how far compaction has settled a session's output.
+	`ALTER TABLE sessions ADD COLUMN output_compacted_through INTEGER NOT NULL DEFAULT 0;`,
+	// 7: per-recording data keys for recordings encrypted at rest.
+	`ALTER TABLE session_recordings ADD COLUMN data_key TEXT;`,
+}
+
+// upgradeSQLite applies the sqliteUpgrades the database has not seen yet,
+// each in its own transaction.
+func upgradeSQLite(ctx context.Context, db *sql.DB) error {
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(sqliteUpgrades); version++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqliteUpgrades[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("upgrade %d: %w", version+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("upgrade %d: %w", version+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("upgrade %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+// NewSQLiteStore opens (creating if needed) the database at path and
+// creates its schema.
+func NewSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("SQLite database path is required")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	// Foreign keys are off by default in SQLite; WAL lets readers proceed
+	// while the PTY readers' async writes are committed.
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() +
+		"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	// SQLite allows one writer at a time; a single connection serializes
+	// writes instead of failing them with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to restrict SQLite database permissions: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to run database migration: %w", err)
+	}
+	if err := upgradeSQLite(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to run database migration: %w", err)
+	}
+
+	log.WithField("path", path).Info("SQLite store opened")
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// sqliteSessionColumns is the column list read by scanSQLiteSession.
+const sqliteSessionColumns = `session_id, user_id, workspace_path, workspace_type, COALESCE(profile, ''), COALESCE(label, ''), status, COALESCE(termination_reason, ''), exit_code, COALESCE(exit_signal, ''), terminated_at, encrypted_credentials, COALESCE(supervisor_socket, ''), COALESCE(resumed_from, ''), COALESCE(output_key, ''), last_activity, created_at, updated_at`
+
+// scanSQLiteSession reads one sessions row selected with
+// sqliteSessionColumns.
+func scanSQLiteSession(row interface{ Scan(...any) error }) (SessionRecord, error) {
+	var rec SessionRecord
+	var exitCode sql.NullInt64
+	var terminatedAt sql.NullTime
+	var creds sql.NullString
+	err := row.Scan(
+		&rec.SessionID,
+		&rec.UserID,
+		&rec.WorkspacePath,
+		&rec.WorkspaceType,
+		&rec.Profile,
+		&rec.Label,
+		&rec.Status,
+		&rec.TerminationReason,
+		&exitCode,
+		&rec.ExitSignal,
+		&terminatedAt,
+		&creds,
+		&rec.SupervisorSocket,
+		&rec.ResumedFrom,
+		&rec.OutputKey,
+		&rec.LastActivity,
+		&rec.CreatedAt,
+		&rec.UpdatedAt,
+	)
+	if exitCode.Valid {
+		code := int(exitCode.Int64)
+		rec.ExitCode = &code
+	}
+	if terminatedAt.Valid {
+		rec.TerminatedAt = &terminatedAt.Time
+	}
+	if creds.Valid {
+		rec.EncryptedCredentials = json.RawMessage(creds.String)
+	}
+	return rec, err
+}
+
+// querySessions runs a sessions query and scans every row.
+func (s *SQLiteStore) querySessions(ctx context.Context, op, where, page string, args ...any) ([]SessionRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqliteSessionColumns+` FROM sessions WHERE `+where+` ORDER BY created_at DESC, session_id`+page, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var records []SessionRecord
+	for rows.Next() {
+		rec, err := scanSQLiteSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s scan: %w", op, err)
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// exec runs a statement, wrapping errors with op.
+func (s *SQLiteStore) exec(ctx context.Context, op, query string, args ...any) (sql.Result, error) {
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// SaveSession inserts or updates (upserts) a session record.
+func (s *SQLiteStore) SaveSession(ctx context.Context, rec SessionRecord) error {
+	var creds sql.NullString
+	if len(rec.EncryptedCredentials) > 0 {
+		creds = sql.NullString{String: string(rec.EncryptedCredentials), Valid: true}
+	}
+	_, err := s.exec(ctx, "SaveSession", `
+		INSERT INTO sessions (session_id, user_id, workspace_path, workspace_type, profile, label, status, encrypted_credentials, supervisor_socket, resumed_from, last_activity, created_at, updated_at)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?)
+		ON CONFLICT (session_id) DO UPDATE SET
+			user_id = excluded.user_id,
+			workspace_path = excluded.workspace_path,
+			workspace_type = excluded.workspace_type,
+			profile = excluded.profile,
+			label = excluded.label,
+			status = excluded.status,
+			encrypted_credentials = excluded.encrypted_credentials,
+			supervisor_socket = excluded.supervisor_socket,
+			last_activity = excluded.last_activity,
+			updated_at = excluded.updated_at
+	`, rec.SessionID, rec.UserID, rec.WorkspacePath, rec.WorkspaceType, rec.Profile, rec.Label, rec.Status, creds, rec.SupervisorSocket, rec.ResumedFrom,
+		rec.LastActivity.UTC(), rec.CreatedAt.UTC(), time.Now().UTC())
+	return err
+}
+
+// GetSession retrieves a single session by ID.
+func (s *SQLiteStore) GetSession(ctx context.Context, sessionID string) (*SessionRecord, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+sqliteSessionColumns+` FROM sessions WHERE session_id = ?`, sessionID)
+	rec, err := scanSQLiteSession(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetSession: %w", err)
+	}
+	return &rec, nil
+}
+
+// SetOutputKey stores a session's wrapped output data key unless it already
+// has one.
+func (s *SQLiteStore) SetOutputKey(ctx context.Context, sessionID, wrappedKey string) (string, error) {
+	var key string
+	err := s.db.QueryRowContext(ctx, `UPDATE sessions SET output_key = COALESCE(output_key, ?) WHERE session_id = ? RETURNING output_key`,
+		wrappedKey, sessionID).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("SetOutputKey: %w", err)
+	}
+	return key, nil
+}
+
+// GetSessionsForUser returns the sessions belonging to a user that match f.
+func (s *SQLiteStore) GetSessionsForUser(ctx context.Context, userID string, f SessionFilter) ([]SessionRecord, error) {
+	cond, args := f.where([]any{userID}, func(int) string { return "?" })
+	return s.querySessions(ctx, "GetSessionsForUser", `user_id = ?`+cond, f.page(), args...)
+}
+
+// GetActiveSessions returns all sessions with active or initializing status.
+func (s *SQLiteStore) GetActiveSessions(ctx context.Context) ([]SessionRecord, error) {
+	return s.querySessions(ctx, "GetActiveSessions", `status IN ('active', 'initializing')`, "")
+}
+
+// UpdateSessionStatus sets the status column for a session.
+func (s *SQLiteStore) UpdateSessionStatus(ctx context.Context, sessionID, status string) error {
+	_, err := s.exec(ctx, "UpdateSessionStatus",
+		`UPDATE sessions SET status = ?, updated_at = ? WHERE session_id = ?`,
+		status, time.Now().UTC(), sessionID)
+	return err
+}
+
+// MarkSessionTerminated sets status='terminated', keeping 'failed' for a
+// session that never started, and records why the session ended. exitCode is
+// nil when the process did not exit normally.
+func (s *SQLiteStore) MarkSessionTerminated(ctx context.Context, sessionID, reason string, exitCode *int, exitSignal string) error {
+	now := time.Now().UTC()
+	_, err := s.exec(ctx, "MarkSessionTerminated", `
+		UPDATE sessions
+		SET status = CASE WHEN status = 'failed' THEN status ELSE 'terminated' END, termination_reason = NULLIF(?, ''), exit_code = ?, exit_signal = NULLIF(?, ''), terminated_at = ?, updated_at = ?
+		WHERE session_id = ?
+	`, reason, exitCode, exitSignal, now, now, sessionID)
+	return err
+}
+
+// MarkStaleSessionsTerminated sets status='terminated' for sessions that were
+// active or initializing, recording a server shutdown as the reason.
+func (s *SQLiteStore) MarkStaleSessionsTerminated(ctx context.Context) (int64, error) {
+	now := time.Now().UTC()
+	res, err := s.exec(ctx, "MarkStaleSessionsTerminated", `
+		UPDATE sessions
+		SET status = 'terminated', termination_reason = COALESCE(termination_reason, 'server_shutdown'), terminated_at = ?, updated_at = ?
+		WHERE status IN ('active', 'initializing')
+	`, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// UpdateLastActivity bumps the last_activity timestamp.
+func (s *SQLiteStore) UpdateLastActivity(ctx context.Context, sessionID string, t time.Time) error {
+	_, err := s.exec(ctx, "UpdateLastActivity",
+		`UPDATE sessions SET last_activity = ?, updated_at = ? WHERE session_id = ?`,
+		t.UTC(), time.Now().UTC(), sessionID)
+	return err
+}
+
+// DeleteSession removes a session and its output (cascade).
+func (s *SQLiteStore) DeleteSession(ctx context.Context, sessionID string) error {
+	_, err := s.exec(ctx, "DeleteSession", `DELETE FROM sessions WHERE session_id = ?`, sessionID)
+	return err
+}
+
+// PurgeTerminatedSessions deletes sessions, with their output (cascade),
+// that ended before endedBefore.
+func (s *SQLiteStore) PurgeTerminatedSessions(ctx context.Context, endedBefore time.Time) (int64, error) {
+	res, err := s.exec(ctx, "PurgeTerminatedSessions", `
+		DELETE FROM sessions
+		WHERE status NOT IN ('active', 'initializing')
+		AND COALESCE(terminated_at, updated_at) < ?
+	`, endedBefore.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SaveOutputChunks appends a batch of output chunks for a session in one
+// transaction.
+func (s *SQLiteStore) SaveOutputChunks(ctx context.Context, sessionID string, chunks []OutputChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("SaveOutputChunks: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO session_output (session_id, timestamp, data) VALUES (?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("SaveOutputChunks: %w", err)
+	}
+	defer stmt.Close()
+	for _, c := range chunks {
+		if _, err := stmt.ExecContext(ctx, sessionID, c.Timestamp.UTC(), c.Data); err != nil {
+			return fmt.Errorf("SaveOutputChunks: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("SaveOutputChunks: %w", err)
+	}
+	return nil
+}
+
+// GetOutputChunks returns the most recent output chunks for a session.
+func (s *SQLiteStore) GetOutputChunks(ctx context.Context, sessionID string, limit int) ([]OutputChunk, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, session_id, timestamp, data FROM (
+			SELECT id, session_id, timestamp, data
+			FROM session_output
+			WHERE session_id = ?
+			ORDER BY id DESC
+			LIMIT ?
+		) ORDER BY id
+	`, sessionID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetOutputChunks: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []OutputChunk
+	for rows.Next() {
+		var c OutputChunk
+		if err := rows.Scan(&c.ID, &c.SessionID, &c.Timestamp, &c.Data); err != nil {
+			return nil, fmt.Errorf("GetOutputChunks scan: %w", err)
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// GetOutputChunksAfter returns up to limit output chunks with ID greater
+// than afterID, oldest first.
+func (s *SQLiteStore) GetOutputChunksAfter(ctx context.Context, sessionID string, afterID int64, limit int) ([]OutputChunk, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, session_id, timestamp, data
+		FROM session_output
+		WHERE session_id = ? AND id > ?
+		ORDER BY id
+		LIMIT ?
+	`, sessionID, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetOutputChunksAfter: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []OutputChunk
+	for rows.Next() {
+		var c OutputChunk
+		if err := rows.Scan(&c.ID, &c.SessionID, &c.Timestamp, &c.Data); err != nil {
+			return nil, fmt.Errorf("GetOutputChunksAfter scan: %w", err)
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// sqliteSearchScan bounds how many candidate chunks SearchOutput ranks.
+const sqliteSearchScan = 5000
+
+// SearchOutput returns the chunks containing every word of q.Query. SQLite
+// has no full-text index here: candidates are found with LIKE, newest first,
+// and ranked by how often the words occur.
+func (s *SQLiteStore) SearchOutput(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	terms := searchTerms(q.Query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	where := `(? = '' OR s.user_id = ?)`
+	args := []any{q.UserID, q.UserID}
+	for _, term := range terms {
+		where += ` AND o.data LIKE ? ESCAPE '\'`
+		args = append(args, "%"+likeEscaper.Replace(term)+"%")
+	}
+	args = append(args, sqliteSearchScan)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT o.session_id, s.user_id, COALESCE(s.label, ''), o.id,
+			(SELECT COUNT(*) FROM session_output p WHERE p.session_id = o.session_id AND p.id < o.id),
+			o.timestamp, o.data
+		FROM session_output o
+		JOIN sessions s ON s.session_id = o.session_id
+		WHERE `+where+`
+		ORDER BY o.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("SearchOutput: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var h SearchHit
+		var data string
+		if err := rows.Scan(&h.SessionID, &h.UserID, &h.Label, &h.ChunkID, &h.ChunkIndex, &h.Timestamp, &data); err != nil {
+			return nil, fmt.Errorf("SearchOutput scan: %w", err)
+		}
+		// LIKE also matched inside escape sequences; check the text.
+		ok, rank, snippet := matchOutput(data, terms)
+		if !ok {
+			continue
+		}
+		h.Rank, h.Snippet = rank, snippet
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("SearchOutput: %w", err)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Rank > hits[j].Rank })
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
+
+// likeEscaper escapes LIKE wildcards for use with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// CompactOutput applies p to the output of every session with chunks
+// written since sessions.output_compacted_through.
+func (s *SQLiteStore) CompactOutput(ctx context.Context, p CompactionPolicy) (CompactionResult, error) {
+	var res CompactionResult
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.session_id
+		FROM sessions s
+		WHERE EXISTS (
+			SELECT 1 FROM session_output o
+			WHERE o.session_id = s.session_id AND o.id > s.output_compacted_through
+		)
+	`)
+	if err != nil {
+		return res, fmt.Errorf("CompactOutput: %w", err)
+	}
+	var sessionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return res, fmt.Errorf("CompactOutput scan: %w", err)
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return res, fmt.Errorf("CompactOutput: %w", err)
+	}
+
+	res.Scanned = len(sessionIDs)
+	for _, sessionID := range sessionIDs {
+		edit, err := s.compactSessionOutput(ctx, sessionID, p)
+		if err != nil {
+			return res, fmt.Errorf("CompactOutput %s: %w", sessionID, err)
+		}
+		if !edit.empty() {
+			res.Sessions++
+			res.MergedChunks += edit.merged
+			res.RemovedChunks += edit.capped
+		}
+	}
+	return res, nil
+}
+
+// compactSessionOutput plans and applies the compaction of one session's
+// output in a transaction, and records how far it is settled.
+func (s *SQLiteStore) compactSessionOutput(ctx context.Context, sessionID string, p CompactionPolicy) (outputEdit, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return outputEdit{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, session_id, timestamp, data FROM session_output WHERE session_id = ? ORDER BY id`, sessionID)
+	if err != nil {
+		return outputEdit{}, err
+	}
+	var chunks []OutputChunk
+	for rows.Next() {
+		var c OutputChunk
+		if err := rows.Scan(&c.ID, &c.SessionID, &c.Timestamp, &c.Data); err != nil {
+			rows.Close()
+			return outputEdit{}, err
+		}
+		chunks = append(chunks, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return outputEdit{}, err
+	}
+
+	edit := planCompaction(chunks, p)
+	for _, c := range edit.update {
+		if _, err := tx.ExecContext(ctx, `UPDATE session_output SET data = ? WHERE id = ?`, c.Data, c.ID); err != nil {
+			return outputEdit{}, err
+		}
+	}
+	for _, id := range edit.remove {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM session_output WHERE id = ?`, id); err != nil {
+			return outputEdit{}, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE sessions SET output_compacted_through = ?1
+		WHERE session_id = ?2 AND output_compacted_through < ?1
+	`, edit.through, sessionID); err != nil {
+		return outputEdit{}, err
+	}
+	return edit, tx.Commit()
+}
+
+// SaveRecording stores the header of a session recording.
+func (s *SQLiteStore) SaveRecording(ctx context.Context, rec RecordingRecord) error {
+	_, err := s.exec(ctx, "SaveRecording", `
+		INSERT INTO session_recordings (session_id, user_id, header, started_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (session_id) DO NOTHING
+	`, rec.SessionID, rec.UserID, rec.Header, rec.StartedAt.UTC())
+	return err
+}
+
+// SetRecordingKey stores a recording's wrapped data key unless it already
+// has one.
+func (s *SQLiteStore) SetRecordingKey(ctx context.Context, sessionID, wrappedKey string) (string, error) {
+	var key string
+	err := s.db.QueryRowContext(ctx, `UPDATE session_recordings SET data_key = COALESCE(data_key, ?) WHERE session_id = ? RETURNING data_key`,
+		wrappedKey, sessionID).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("SetRecordingKey: %w", err)
+	}
+	return key, nil
+}
+
+// SaveRecordingEvents appends a batch of events to a session recording with
+// one multi-row insert.
+func (s *SQLiteStore) SaveRecordingEvents(ctx context.Context, sessionID string, events []RecordingEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	values := make([]string, len(events))
+	args := make([]any, 0, 5*len(events))
+	for i, ev := range events {
+		values[i] = "(?, ?, ?, ?, ?)"
+		args = append(args, sessionID, ev.Seq, ev.Elapsed, ev.Kind, ev.Data)
+	}
+	_, err := s.exec(ctx, "SaveRecordingEvents", `
+		INSERT INTO session_recording_events (session_id, seq, elapsed, kind, data)
+		VALUES `+strings.Join(values, ", ")+`
+		ON CONFLICT (session_id, seq) DO NOTHING`, args...)
+	return err
+}
+
+// GetRecording returns a session recording's header and events in order.
+// It returns ErrNotFound when there is no recording for the session.
+func (s *SQLiteStore) GetRecording(ctx context.Context, sessionID string) (*RecordingRecord, []RecordingEvent, error) {
+	rec := RecordingRecord{SessionID: sessionID}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT user_id, header, started_at, COALESCE(data_key, '') FROM session_recordings WHERE session_id = ?`,
+		sessionID,
+	).Scan(&rec.UserID, &rec.Header, &rec.StartedAt, &rec.DataKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("GetRecording: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT seq, elapsed, kind, data
+		FROM session_recording_events
+		WHERE session_id = ?
+		ORDER BY seq
+	`, sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GetRecording events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []RecordingEvent
+	for rows.Next() {
+		var ev RecordingEvent
+		if err := rows.Scan(&ev.Seq, &ev.Elapsed, &ev.Kind, &ev.Data); err != nil {
+			return nil, nil, fmt.Errorf("GetRecording scan: %w", err)
+		}
+		events = append(events, ev)
+	}
+	return &rec, events, rows.Err()
+}
+
+// PurgeRecordings deletes recordings, with their events, that started before
+// startedBefore, except those of live sessions.
+func (s *SQLiteStore) PurgeRecordings(ctx context.Context, startedBefore time.Time) (int64, error) {
+	const expired = `
+		SELECT session_id FROM session_recordings
+		WHERE started_at < ?
+		AND session_id NOT IN (SELECT session_id FROM sessions WHERE status IN ('active', 'initializing'))
+	`
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("PurgeRecordings: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM session_recording_events WHERE session_id IN (`+expired+`)`, startedBefore.UTC()); err != nil {
+		return 0, fmt.Errorf("PurgeRecordings events: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM session_recordings WHERE session_id IN (`+expired+`)`, startedBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("PurgeRecordings: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("PurgeRecordings: %w", err)
+	}
+	return n, tx.Commit()
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() {
+	if err := s.db.Close(); err != nil {
+		log.WithError(err).Warn("Error closing SQLite store")
+		return
+	}
+	log.Info("SQLite store closed")
+}
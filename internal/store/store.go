@@ -0,0 +1,221 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+)
+
+// Store persists sessions, their output and their recordings. All methods
+// are safe for concurrent use.
+type Store interface {
+	// SaveSession inserts or updates (upserts) a session record. OutputKey
+	// is not saved.
+	SaveSession(ctx context.Context, rec SessionRecord) error
+	// GetSession retrieves a single session by ID, or ErrNotFound.
+	GetSession(ctx context.Context, sessionID string) (*SessionRecord, error)
+	// GetSessionsForUser returns a user's sessions matching f, newest first.
+	GetSessionsForUser(ctx context.Context, userID string, f SessionFilter) ([]SessionRecord, error)
+	// SetOutputKey stores a session's wrapped output data key unless it
+	// already has one, and returns the key the session ends up with, or
+	// ErrNotFound.
+	SetOutputKey(ctx context.Context, sessionID, wrappedKey string) (string, error)
+	// GetActiveSessions returns sessions with active or initializing status.
+	GetActiveSessions(ctx context.Context) ([]SessionRecord, error)
+	// UpdateSessionStatus sets a session's status.
+	UpdateSessionStatus(ctx context.Context, sessionID, status string) error
+	// MarkSessionTerminated sets status "terminated", unless the session
+	// failed to start, and records why it ended. exitCode is nil when the
+	// process did not exit normally.
+	MarkSessionTerminated(ctx context.Context, sessionID, reason string, exitCode *int, exitSignal string) error
+	// MarkStaleSessionsTerminated terminates sessions left active or
+	// initializing by a previous run and returns how many there were.
+	MarkStaleSessionsTerminated(ctx context.Context) (int64, error)
+	// UpdateLastActivity bumps a session's last activity time.
+	UpdateLastActivity(ctx context.Context, sessionID string, t time.Time) error
+	// DeleteSession removes a session and its output. Recordings are kept.
+	DeleteSession(ctx context.Context, sessionID string) error
+	// PurgeTerminatedSessions deletes sessions, with their output, that
+	// ended before the given time and returns how many there were.
+	PurgeTerminatedSessions(ctx context.Context, endedBefore time.Time) (int64, error)
+
+	// SaveOutputChunks appends a batch of terminal output chunks, in order,
+	// to a session. Only Timestamp and Data of each chunk are used; the batch
+	// is written atomically.
+	SaveOutputChunks(ctx context.Context, sessionID string, chunks []OutputChunk) error
+	// GetOutputChunks returns up to limit of a session's most recent output
+	// chunks, oldest first.
+	GetOutputChunks(ctx context.Context, sessionID string, limit int) ([]OutputChunk, error)
+	// GetOutputChunksAfter returns up to limit of a session's output chunks
+	// with ID greater than afterID, oldest first. Reading on from the last ID
+	// returned walks a session's whole output.
+	GetOutputChunksAfter(ctx context.Context, sessionID string, afterID int64, limit int) ([]OutputChunk, error)
+	// SearchOutput returns up to q.Limit stored output chunks matching
+	// q.Query, best match first.
+	SearchOutput(ctx context.Context, q SearchQuery) ([]SearchHit, error)
+	// CompactOutput applies p to the output of every session with chunks
+	// written since a previous pass settled it: the oldest output beyond the
+	// byte cap is removed, and old chunks are merged into blobs.
+	CompactOutput(ctx context.Context, p CompactionPolicy) (CompactionResult, error)
+
+	// SaveRecording stores the header of a session recording; a second
+	// header for the same session is ignored.
+	SaveRecording(ctx context.Context, rec RecordingRecord) error
+	// SetRecordingKey stores a recording's wrapped data key unless it
+	// already has one, and returns the key the recording ends up with, or
+	// ErrNotFound.
+	SetRecordingKey(ctx context.Context, sessionID, wrappedKey string) (string, error)
+	// SaveRecordingEvents appends a batch of events to a session recording.
+	// Batches may be written out of order; Seq restores the order on read.
+	// Events already stored are skipped, so a batch may be retried.
+	SaveRecordingEvents(ctx context.Context, sessionID string, events []RecordingEvent) error
+	// GetRecording returns a recording's header and events in order, or
+	// ErrNotFound.
+	GetRecording(ctx context.Context, sessionID string) (*RecordingRecord, []RecordingEvent, error)
+	// PurgeRecordings deletes recordings, with their events, that started
+	// before the given time, except those of sessions still active or
+	// initializing, and returns how many there were.
+	PurgeRecordings(ctx context.Context, startedBefore time.Time) (int64, error)
+
+	// Close releases the store's resources.
+	Close()
+}
+
+// Store drivers selectable with STORE_DRIVER.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+// Open connects to the store selected by cfg.Driver and prepares its
+// schema. It returns a nil Store when persistence is disabled.
+func Open(ctx context.Context, cfg config.DatabaseConfig) (Store, error) {
+	var s Store
+	var err error
+	switch cfg.Driver {
+	case "":
+		return nil, nil
+	case DriverPostgres:
+		s, err = NewPostgresStore(ctx, cfg)
+	case DriverSQLite:
+		s, err = NewSQLiteStore(ctx, cfg.SQLitePath)
+	case DriverMemory:
+		s = NewMemoryStore()
+	default:
+		return nil, fmt.Errorf("unknown store driver %q", cfg.Driver)
+	}
+	if err != nil {
+		// Never return a typed nil pointer as a non-nil Store.
+		return nil, err
+	}
+	return s, nil
+}
+
+// SessionRecord represents a persisted session.
+type SessionRecord struct {
+	SessionID            string          `json:"session_id"`
+	UserID               string          `json:"user_id"`
+	WorkspacePath        string          `json:"workspace_path"`
+	WorkspaceType        string          `json:"workspace_type"`
+	Profile              string          `json:"profile,omitempty"`
+	Label                string          `json:"label,omitempty"`
+	Status               string          `json:"status"`
+	TerminationReason    string          `json:"termination_reason,omitempty"`
+	ExitCode             *int            `json:"exit_code,omitempty"` // nil unless the process exited normally
+	ExitSignal           string          `json:"exit_signal,omitempty"`
+	TerminatedAt         *time.Time      `json:"terminated_at,omitempty"` // nil while the session is live
+	EncryptedCredentials json.RawMessage `json:"encrypted_credentials,omitempty"`
+	SupervisorSocket     string          `json:"supervisor_socket,omitempty"` // set while a supervisor owns the agent
+	ResumedFrom          string          `json:"resumed_from,omitempty"`      // the terminated session this one continues
+	OutputKey            string          `json:"-"`                           // wrapped output data key; set by SetOutputKey only
+	LastActivity         time.Time       `json:"last_activity"`
+	CreatedAt            time.Time       `json:"created_at"`
+	UpdatedAt            time.Time       `json:"updated_at"`
+}
+
+// SessionFilter narrows a session listing. Zero fields match everything.
+type SessionFilter struct {
+	Status        string
+	Label         string
+	CreatedAfter  time.Time // inclusive
+	CreatedBefore time.Time // exclusive
+	Limit         int       // 0 returns all matches
+	Offset        int
+}
+
+// matches reports whether rec passes the filter's conditions.
+func (f SessionFilter) matches(rec SessionRecord) bool {
+	return (f.Status == "" || rec.Status == f.Status) &&
+		(f.Label == "" || rec.Label == f.Label) &&
+		(f.CreatedAfter.IsZero() || !rec.CreatedAt.Before(f.CreatedAfter)) &&
+		(f.CreatedBefore.IsZero() || rec.CreatedAt.Before(f.CreatedBefore))
+}
+
+// where returns the filter's conditions as SQL, with placeholders made by
+// arg from the argument's 1-based position.
+func (f SessionFilter) where(args []any, arg func(n int) string) (string, []any) {
+	var conds []string
+	add := func(cond string, v any) {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(cond, arg(len(args))))
+	}
+	if f.Status != "" {
+		add("status = %s", f.Status)
+	}
+	if f.Label != "" {
+		add("label = %s", f.Label)
+	}
+	if !f.CreatedAfter.IsZero() {
+		add("created_at >= %s", f.CreatedAfter.UTC())
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("created_at < %s", f.CreatedBefore.UTC())
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " AND " + strings.Join(conds, " AND "), args
+}
+
+// page returns the filter's LIMIT and OFFSET clause, or "" for all rows.
+func (f SessionFilter) page() string {
+	if f.Limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, max(f.Offset, 0))
+}
+
+// OutputChunk is a persisted chunk of terminal output.
+type OutputChunk struct {
+	ID        int64     `json:"id"`
+	SessionID string    `json:"session_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      string    `json:"data"`
+}
+
+// RecordingRecord is the header of a session's asciicast recording.
+type RecordingRecord struct {
+	SessionID string
+	UserID    string
+	Header    string // asciicast v2 header line (JSON)
+	StartedAt time.Time
+	DataKey   string // wrapped event data key; set by SetRecordingKey only
+}
+
+// RecordingEvent is one asciicast event: seconds since the recording
+// started, kind ("o" output, "i" input, "r" resize) and data.
+type RecordingEvent struct {
+	Seq     int64
+	Elapsed float64
+	Kind    string
+	Data    string
+}
+
+// ErrNotFound is returned when a requested row does not exist.
+var ErrNotFound = errors.New("not found")
@@ -0,0 +1,417 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/servicenow/claude-terminal-mid-service/internal/config"
+)
+
+// testStore runs the behaviour every Store implementation must share.
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	rec := SessionRecord{
+		SessionID:            "11111111-1111-1111-1111-111111111111",
+		UserID:               "alice",
+		WorkspacePath:        "/tmp/ws/alice/1",
+		WorkspaceType:        "isolated",
+		Profile:              "default",
+		Label:                "ldap cleanup",
+		Status:               "active",
+		EncryptedCredentials: json.RawMessage(`{"anthropicApiKey":"enc"}`),
+		SupervisorSocket:     "/tmp/supervisors/1.sock",
+		ResumedFrom:          "0",
+		LastActivity:         created,
+		CreatedAt:            created,
+	}
+	if err := s.SaveSession(ctx, rec); err != nil {
+		t.Fatalf("SaveSession failed: %v", err)
+	}
+	older := rec
+	older.SessionID = "22222222-2222-2222-2222-222222222222"
+	older.CreatedAt = created.Add(-time.Hour)
+	older.Status = "initializing"
+	older.Label = ""
+	if err := s.SaveSession(ctx, older); err != nil {
+		t.Fatalf("SaveSession failed: %v", err)
+	}
+
+	got, err := s.GetSession(ctx, rec.SessionID)
+	if err != nil {
+		t.Fatalf("GetSession failed: %v", err)
+	}
+	if got.UserID != "alice" || got.Status != "active" || !got.CreatedAt.Equal(created) ||
+		string(got.EncryptedCredentials) != string(rec.EncryptedCredentials) || got.ExitCode != nil ||
+		got.Profile != "default" || got.SupervisorSocket != rec.SupervisorSocket ||
+		got.ResumedFrom != "0" || got.Label != "ldap cleanup" {
+		t.Errorf("GetSession = %+v", got)
+	}
+	if _, err := s.GetSession(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing session, got %v", err)
+	}
+
+	// The output key is set once and survives later saves.
+	for _, try := range []string{"key-1", "key-2"} {
+		if key, err := s.SetOutputKey(ctx, rec.SessionID, try); err != nil || key != "key-1" {
+			t.Errorf("SetOutputKey(%s) = %q, %v; want key-1", try, key, err)
+		}
+	}
+	if err := s.SaveSession(ctx, rec); err != nil {
+		t.Fatalf("SaveSession failed: %v", err)
+	}
+	if got, _ := s.GetSession(ctx, rec.SessionID); got.OutputKey != "key-1" {
+		t.Errorf("OutputKey after SaveSession = %q, want key-1", got.OutputKey)
+	}
+	if _, err := s.SetOutputKey(ctx, "missing", "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound from SetOutputKey for a missing session, got %v", err)
+	}
+
+	sessions, err := s.GetSessionsForUser(ctx, "alice", SessionFilter{})
+	if err != nil || len(sessions) != 2 || sessions[0].SessionID != rec.SessionID {
+		t.Errorf("GetSessionsForUser = %+v, %v; want both, newest first", sessions, err)
+	}
+	if sessions, _ := s.GetSessionsForUser(ctx, "bob", SessionFilter{}); len(sessions) != 0 {
+		t.Errorf("GetSessionsForUser(bob) = %+v", sessions)
+	}
+	for name, tc := range map[string]struct {
+		filter SessionFilter
+		want   []string
+	}{
+		"status":      {SessionFilter{Status: "initializing"}, []string{older.SessionID}},
+		"label":       {SessionFilter{Label: "ldap cleanup"}, []string{rec.SessionID}},
+		"after":       {SessionFilter{CreatedAfter: created}, []string{rec.SessionID}},
+		"before":      {SessionFilter{CreatedBefore: created}, []string{older.SessionID}},
+		"first page":  {SessionFilter{Limit: 1}, []string{rec.SessionID}},
+		"second page": {SessionFilter{Limit: 1, Offset: 1}, []string{older.SessionID}},
+		"past end":    {SessionFilter{Limit: 1, Offset: 2}, nil},
+	} {
+		sessions, err := s.GetSessionsForUser(ctx, "alice", tc.filter)
+		var got []string
+		for _, rec := range sessions {
+			got = append(got, rec.SessionID)
+		}
+		if err != nil || strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("GetSessionsForUser(%s) = %v, %v; want %v", name, got, err, tc.want)
+		}
+	}
+
+	// Output chunks come back oldest first, limited to the most recent.
+	batch := []OutputChunk{{Timestamp: time.Now(), Data: "one"}, {Timestamp: time.Now(), Data: "two"}}
+	if err := s.SaveOutputChunks(ctx, rec.SessionID, batch); err != nil {
+		t.Fatalf("SaveOutputChunks failed: %v", err)
+	}
+	if err := s.SaveOutputChunks(ctx, rec.SessionID, []OutputChunk{{Timestamp: time.Now(), Data: "three"}}); err != nil {
+		t.Fatalf("SaveOutputChunks failed: %v", err)
+	}
+	if err := s.SaveOutputChunks(ctx, rec.SessionID, nil); err != nil {
+		t.Errorf("SaveOutputChunks with an empty batch failed: %v", err)
+	}
+	chunks, err := s.GetOutputChunks(ctx, rec.SessionID, 2)
+	if err != nil || len(chunks) != 2 || chunks[0].Data != "two" || chunks[1].Data != "three" {
+		t.Errorf("GetOutputChunks = %+v, %v", chunks, err)
+	}
+	all, err := s.GetOutputChunksAfter(ctx, rec.SessionID, 0, 2)
+	if err != nil || len(all) != 2 || all[0].Data != "one" || all[1].Data != "two" {
+		t.Fatalf("GetOutputChunksAfter(0) = %+v, %v", all, err)
+	}
+	rest, err := s.GetOutputChunksAfter(ctx, rec.SessionID, all[1].ID, 2)
+	if err != nil || len(rest) != 1 || rest[0].Data != "three" {
+		t.Errorf("GetOutputChunksAfter(%d) = %+v, %v", all[1].ID, rest, err)
+	}
+	if err := s.SaveOutputChunks(ctx, "missing", batch); err == nil {
+		t.Error("Expected SaveOutputChunks to fail for an unknown session")
+	}
+	if chunks, _ := s.GetOutputChunks(ctx, "missing", 10); len(chunks) != 0 {
+		t.Errorf("Expected a failed batch to save nothing, got %+v", chunks)
+	}
+
+	activity := time.Now().Truncate(time.Second)
+	if err := s.UpdateLastActivity(ctx, rec.SessionID, activity); err != nil {
+		t.Fatalf("UpdateLastActivity failed: %v", err)
+	}
+	code := 3
+	if err := s.MarkSessionTerminated(ctx, rec.SessionID, "process_exited", &code, ""); err != nil {
+		t.Fatalf("MarkSessionTerminated failed: %v", err)
+	}
+	got, _ = s.GetSession(ctx, rec.SessionID)
+	if got.Status != "terminated" || got.TerminationReason != "process_exited" || got.ExitCode == nil || *got.ExitCode != 3 ||
+		!got.LastActivity.Equal(activity) || got.TerminatedAt == nil {
+		t.Errorf("after termination GetSession = %+v", got)
+	}
+
+	active, err := s.GetActiveSessions(ctx)
+	if err != nil || len(active) != 1 || active[0].SessionID != older.SessionID {
+		t.Errorf("GetActiveSessions = %+v, %v", active, err)
+	}
+	n, err := s.MarkStaleSessionsTerminated(ctx)
+	if err != nil || n != 1 {
+		t.Errorf("MarkStaleSessionsTerminated = %d, %v; want 1", n, err)
+	}
+	got, _ = s.GetSession(ctx, older.SessionID)
+	if got.Status != "terminated" || got.TerminationReason != "server_shutdown" || got.TerminatedAt == nil {
+		t.Errorf("stale session = %+v", got)
+	}
+	if err := s.UpdateSessionStatus(ctx, older.SessionID, "failed"); err != nil {
+		t.Fatalf("UpdateSessionStatus failed: %v", err)
+	}
+	if got, _ := s.GetSession(ctx, older.SessionID); got.Status != "failed" {
+		t.Errorf("status = %q, want failed", got.Status)
+	}
+
+	// Recordings outlive their session.
+	header := RecordingRecord{SessionID: rec.SessionID, UserID: "alice", Header: `{"version":2}`, StartedAt: created}
+	if err := s.SaveRecording(ctx, header); err != nil {
+		t.Fatalf("SaveRecording failed: %v", err)
+	}
+	if err := s.SaveRecording(ctx, RecordingRecord{SessionID: rec.SessionID, UserID: "mallory", Header: "{}", StartedAt: created}); err != nil {
+		t.Fatalf("second SaveRecording failed: %v", err)
+	}
+	for _, batch := range [][]RecordingEvent{{{Seq: 2, Elapsed: 0.5, Kind: "i", Data: "b"}}, nil, {{Seq: 1, Elapsed: 0.25, Kind: "o", Data: "a"}}} {
+		if err := s.SaveRecordingEvents(ctx, rec.SessionID, batch); err != nil {
+			t.Fatalf("SaveRecordingEvents failed: %v", err)
+		}
+	}
+
+	if err := s.DeleteSession(ctx, rec.SessionID); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	if _, err := s.GetSession(ctx, rec.SessionID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after DeleteSession, got %v", err)
+	}
+	if chunks, _ := s.GetOutputChunks(ctx, rec.SessionID, 10); len(chunks) != 0 {
+		t.Errorf("Expected output deleted with the session, got %+v", chunks)
+	}
+
+	recording, events, err := s.GetRecording(ctx, rec.SessionID)
+	if err != nil {
+		t.Fatalf("GetRecording failed: %v", err)
+	}
+	if recording.UserID != "alice" || recording.Header != header.Header || len(events) != 2 || events[0].Data != "a" || events[1].Kind != "i" {
+		t.Errorf("GetRecording = %+v, %+v", recording, events)
+	}
+	if _, _, err := s.GetRecording(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing recording, got %v", err)
+	}
+}
+
+// testRetention checks purging and output compaction on a Store.
+func testRetention(t *testing.T, s Store) {
+	ctx := context.Background()
+	now := time.Now()
+	for _, id := range []string{"live", "ended", "recent"} {
+		if err := s.SaveSession(ctx, SessionRecord{SessionID: id, UserID: "alice", Status: "active", LastActivity: now, CreatedAt: now}); err != nil {
+			t.Fatalf("SaveSession failed: %v", err)
+		}
+	}
+	s.MarkSessionTerminated(ctx, "ended", "user_terminated", nil, "")
+	s.MarkSessionTerminated(ctx, "recent", "user_terminated", nil, "")
+
+	// Old chunks merge into blobs; the byte cap drops the oldest output.
+	old := now.Add(-2 * time.Hour)
+	var chunks []OutputChunk
+	for _, data := range []string{"aaaa", "bbbb", "cccc", "dddd", "eeee"} {
+		chunks = append(chunks, OutputChunk{Timestamp: old, Data: data})
+	}
+	chunks = append(chunks, OutputChunk{Timestamp: now, Data: "new1"}, OutputChunk{Timestamp: now, Data: "new2"})
+	if err := s.SaveOutputChunks(ctx, "live", chunks); err != nil {
+		t.Fatalf("SaveOutputChunks failed: %v", err)
+	}
+	policy := CompactionPolicy{MaxBytes: 24, MergeBefore: now.Add(-time.Hour), MergeMaxBytes: 8}
+	res, err := s.CompactOutput(ctx, policy)
+	if err != nil {
+		t.Fatalf("CompactOutput failed: %v", err)
+	}
+	if res.Sessions != 1 || res.RemovedChunks != 1 || res.MergedChunks != 2 {
+		t.Errorf("CompactOutput = %+v; want 1 session, 1 removed, 2 merged", res)
+	}
+	got, _ := s.GetOutputChunks(ctx, "live", 100)
+	var parts []string
+	for _, c := range got {
+		parts = append(parts, c.Data)
+	}
+	if strings.Join(parts, ",") != "bbbbcccc,ddddeeee,new1,new2" {
+		t.Errorf("compacted output = %v", parts)
+	}
+	if !got[0].Timestamp.Equal(old) {
+		t.Errorf("merged chunk timestamp = %v, want %v", got[0].Timestamp, old)
+	}
+	// Compaction is idempotent.
+	if res, err := s.CompactOutput(ctx, policy); err != nil || res.Sessions != 0 {
+		t.Errorf("second CompactOutput = %+v, %v; want no change", res, err)
+	}
+	// Once its output is settled, a session is only read again after it
+	// writes more, which here takes it over the byte cap.
+	settled := policy
+	settled.MergeBefore = now.Add(time.Minute)
+	if res, err := s.CompactOutput(ctx, settled); err != nil || res.Scanned != 1 || res.MergedChunks != 1 {
+		t.Errorf("CompactOutput(settled) = %+v, %v; want 1 scanned, 1 merged", res, err)
+	}
+	if res, err := s.CompactOutput(ctx, settled); err != nil || res.Scanned != 0 {
+		t.Errorf("CompactOutput after settling = %+v, %v; want nothing scanned", res, err)
+	}
+	s.SaveOutputChunks(ctx, "live", []OutputChunk{{Timestamp: now, Data: "x"}})
+	if res, err := s.CompactOutput(ctx, settled); err != nil || res.Scanned != 1 || res.RemovedChunks != 1 {
+		t.Errorf("CompactOutput after new output = %+v, %v; want 1 scanned, 1 removed by the cap", res, err)
+	}
+
+	// Recordings of ended sessions are purged, with their events, once they
+	// started before the cutoff; a live session keeps its recording.
+	for _, id := range []string{"live", "ended"} {
+		if err := s.SaveRecording(ctx, RecordingRecord{SessionID: id, UserID: "alice", Header: "{}", StartedAt: old}); err != nil {
+			t.Fatalf("SaveRecording failed: %v", err)
+		}
+		if err := s.SaveRecordingEvents(ctx, id, []RecordingEvent{{Seq: 1, Kind: "o", Data: "x"}}); err != nil {
+			t.Fatalf("SaveRecordingEvents failed: %v", err)
+		}
+	}
+	if n, err := s.PurgeRecordings(ctx, old); err != nil || n != 0 {
+		t.Errorf("PurgeRecordings(past) = %d, %v; want 0", n, err)
+	}
+	if n, err := s.PurgeRecordings(ctx, now); err != nil || n != 1 {
+		t.Errorf("PurgeRecordings = %d, %v; want 1", n, err)
+	}
+	if _, _, err := s.GetRecording(ctx, "ended"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected purged recording to be gone, got %v", err)
+	}
+	if _, events, err := s.GetRecording(ctx, "live"); err != nil || len(events) != 1 {
+		t.Errorf("GetRecording(live) = %v, %v; want the recording kept", events, err)
+	}
+
+	// Only sessions that ended before the cutoff are purged, with their output.
+	n, err := s.PurgeTerminatedSessions(ctx, now.Add(-time.Minute))
+	if err != nil || n != 0 {
+		t.Errorf("PurgeTerminatedSessions(past) = %d, %v; want 0", n, err)
+	}
+	n, err = s.PurgeTerminatedSessions(ctx, time.Now().Add(time.Minute))
+	if err != nil || n < 2 {
+		t.Errorf("PurgeTerminatedSessions = %d, %v; want at least 2", n, err)
+	}
+	for _, id := range []string{"ended", "recent"} {
+		if _, err := s.GetSession(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected purged session %s to be gone, got %v", id, err)
+		}
+	}
+	if _, err := s.GetSession(ctx, "live"); err != nil {
+		t.Errorf("live session was purged: %v", err)
+	}
+}
+
+// testSearch checks SearchOutput on an empty store.
+func testSearch(t *testing.T, s Store) {
+	ctx := context.Background()
+	now := time.Now()
+	for _, rec := range []SessionRecord{
+		{SessionID: "33333333-3333-3333-3333-333333333333", UserID: "alice", Label: "ldap"},
+		{SessionID: "44444444-4444-4444-4444-444444444444", UserID: "bob"},
+	} {
+		rec.Status, rec.LastActivity, rec.CreatedAt = "terminated", now, now
+		if err := s.SaveSession(ctx, rec); err != nil {
+			t.Fatalf("SaveSession failed: %v", err)
+		}
+	}
+	s.SaveOutputChunks(ctx, "33333333-3333-3333-3333-333333333333", []OutputChunk{
+		{Timestamp: now, Data: "starting\r\n"},
+		{Timestamp: now, Data: "\x1b[1mFixed\x1b[0m the LDAP script <now>\r\n"},
+		{Timestamp: now, Data: "bye\r\n"},
+	})
+	s.SaveOutputChunks(ctx, "44444444-4444-4444-4444-444444444444", []OutputChunk{
+		{Timestamp: now, Data: "bob's ldap script\r\n"},
+	})
+
+	hits, err := s.SearchOutput(ctx, SearchQuery{Query: "ldap script", UserID: "alice", Limit: 10})
+	if err != nil || len(hits) != 1 {
+		t.Fatalf("SearchOutput(alice) = %+v, %v; want one hit", hits, err)
+	}
+	hit := hits[0]
+	if hit.SessionID != "33333333-3333-3333-3333-333333333333" || hit.UserID != "alice" || hit.Label != "ldap" ||
+		hit.ChunkIndex != 1 || hit.ChunkID == 0 || hit.Rank <= 0 {
+		t.Errorf("hit = %+v", hit)
+	}
+	if !strings.Contains(hit.Snippet, "<mark>LDAP</mark>") || !strings.Contains(hit.Snippet, "&lt;now") ||
+		strings.Contains(hit.Snippet, "<now>") || strings.Contains(hit.Snippet, "\x1b") {
+		t.Errorf("snippet = %q", hit.Snippet)
+	}
+
+	if hits, err := s.SearchOutput(ctx, SearchQuery{Query: "ldap script", Limit: 10}); err != nil || len(hits) != 2 {
+		t.Errorf("SearchOutput(all users) = %+v, %v; want two hits", hits, err)
+	}
+	if hits, err := s.SearchOutput(ctx, SearchQuery{Query: "ldap script", Limit: 1}); err != nil || len(hits) != 1 {
+		t.Errorf("SearchOutput(limit 1) = %+v, %v", hits, err)
+	}
+	if hits, err := s.SearchOutput(ctx, SearchQuery{Query: "kerberos", Limit: 10}); err != nil || len(hits) != 0 {
+		t.Errorf("SearchOutput(kerberos) = %+v, %v; want none", hits, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+	testRetention(t, NewMemoryStore())
+	testSearch(t, NewMemoryStore())
+}
+
+func TestSQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db", "sessions.db")
+	s, err := NewSQLiteStore(context.Background(), path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	testStore(t, s)
+	s.Close()
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("database file mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	retained, err := NewSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "retention.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	testRetention(t, retained)
+	retained.Close()
+
+	searched, err := NewSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "search.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	testSearch(t, searched)
+	searched.Close()
+
+	// Data survives reopening the file.
+	s, err = NewSQLiteStore(context.Background(), path)
+	if err != nil {
+		t.Fatalf("reopening NewSQLiteStore failed: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.GetSession(context.Background(), "22222222-2222-2222-2222-222222222222"); err != nil {
+		t.Errorf("GetSession after reopen failed: %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+
+	if s, err := Open(ctx, config.DatabaseConfig{}); s != nil || err != nil {
+		t.Errorf("Open with no driver = %v, %v; want nil, nil", s, err)
+	}
+	if s, err := Open(ctx, config.DatabaseConfig{Driver: DriverMemory}); err != nil || s == nil {
+		t.Errorf("Open(memory) = %v, %v", s, err)
+	}
+	if _, err := Open(ctx, config.DatabaseConfig{Driver: "mysql"}); err == nil {
+		t.Error("Expected error for an unknown driver")
+	}
+
+	// A failing backend yields an untyped nil Store.
+	s, err := Open(ctx, config.DatabaseConfig{Driver: DriverSQLite})
+	if err == nil || s != nil {
+		t.Errorf("Open(sqlite) without a path = %v, %v; want nil Store and an error", s, err)
+	}
+}
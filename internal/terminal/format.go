@@ -0,0 +1,253 @@
+package terminal
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Format selects how PTY output is rendered for clients that cannot
+// interpret escape sequences.
+type Format string
+
+const (
+	FormatRaw  Format = "raw"  // output exactly as the agent wrote it
+	FormatText Format = "text" // escape sequences removed, CR/LF and backspaces applied
+	FormatHTML Format = "html" // like text, with colours and styles as <span> elements
+)
+
+// ParseFormat validates a format name; empty selects FormatRaw.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case "":
+		return FormatRaw, nil
+	case FormatRaw, FormatText, FormatHTML:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid format %q: must be raw, text or html", name)
+}
+
+// Converter renders a stream of PTY output chunk by chunk. It is line
+// oriented: carriage returns and backspaces rewrite the current line, and
+// cursor addressing beyond that is ignored, which suits the scrolling output
+// of a CLI rather than full-screen TUIs (see Terminal for those). State,
+// including escape sequences split between chunks, carries over from one
+// Convert call to the next.
+type Converter struct {
+	format Format
+	parser parser
+	cur    attrs
+
+	line     []cell // current line, not yet terminated
+	col      int
+	midLine  bool // the last Convert ended inside a line
+	crBreak  bool // a CR arrived after midLine; starts a new line before more text
+	out      strings.Builder
+	lastHTML attrs // style of the open <span>, when lastOpen
+	lastOpen bool
+}
+
+// NewConverter returns a converter for format.
+func NewConverter(format Format) *Converter {
+	return &Converter{format: format}
+}
+
+// Convert returns data rendered in the converter's format. The partial line
+// at the end of data is flushed, so each result stands on its own; for HTML,
+// every <span> opened in the result is also closed in it.
+func (c *Converter) Convert(data string) string {
+	if c.format == FormatRaw || c.format == "" {
+		return data
+	}
+
+	c.out.Reset()
+	c.parser.write(c, []byte(data))
+	if len(c.line) > 0 {
+		c.flushLine(false)
+		c.midLine = true
+	}
+	c.closeSpan()
+	return c.out.String()
+}
+
+func (c *Converter) print(r rune) {
+	if c.crBreak {
+		c.crBreak = false
+		c.midLine = false
+		c.out.WriteByte('\n')
+	}
+	if runeWidth(r) == 0 && r != '\t' {
+		return
+	}
+	for len(c.line) < c.col {
+		c.line = append(c.line, cell{r: ' '})
+	}
+	if c.col < len(c.line) {
+		c.line[c.col] = cell{r: r, a: c.cur}
+	} else {
+		c.line = append(c.line, cell{r: r, a: c.cur})
+	}
+	c.col++
+}
+
+func (c *Converter) execute(b byte) {
+	switch b {
+	case '\n', '\v', '\f':
+		c.flushLine(true)
+		c.out.WriteByte('\n')
+		c.midLine = false
+		c.crBreak = false
+	case '\r':
+		c.col = 0
+		// The line being overwritten was already sent with an earlier chunk,
+		// so the rewrite has to go on a new line.
+		if len(c.line) == 0 && c.midLine {
+			c.crBreak = true
+		}
+	case '\b':
+		if c.col > 0 {
+			c.col--
+		}
+	case '\t':
+		c.print('\t')
+	}
+}
+
+func (c *Converter) escDispatch(intermediate, final byte) {}
+
+func (c *Converter) csiDispatch(p *parser, final byte) {
+	if p.private != 0 || p.intermediate != 0 {
+		return
+	}
+	switch final {
+	case 'm': // SGR
+		c.cur.sgr(p.params, p.colon)
+	case 'C': // CUF: TUIs use it for horizontal spacing
+		c.col = min(c.col+p.param(0, 1), MaxCols-1)
+	case 'D': // CUB
+		c.col = max(c.col-p.param(0, 1), 0)
+	case 'G': // CHA
+		c.col = max(min(p.param(0, 1), MaxCols)-1, 0)
+	case 'K': // EL
+		switch p.param(0, 0) {
+		case 0:
+			c.line = c.line[:min(c.col, len(c.line))]
+		case 2:
+			c.line = c.line[:0]
+		}
+	}
+}
+
+func (c *Converter) oscDispatch(data []byte) {}
+
+// flushLine writes the current line to the output. Trailing spaces are
+// dropped from completed lines.
+func (c *Converter) flushLine(complete bool) {
+	end := len(c.line)
+	if complete {
+		for end > 0 && c.line[end-1].r == ' ' && c.line[end-1].a.bg == colorDefault {
+			end--
+		}
+	}
+
+	for _, cl := range c.line[:end] {
+		if c.format == FormatHTML {
+			c.openSpan(cl.a)
+			c.out.WriteString(html.EscapeString(string(cl.r)))
+		} else {
+			c.out.WriteRune(cl.r)
+		}
+	}
+	if c.format == FormatHTML {
+		c.closeSpan()
+	}
+
+	c.line = c.line[:0]
+	c.col = 0
+}
+
+func (c *Converter) openSpan(a attrs) {
+	if c.lastOpen && a == c.lastHTML {
+		return
+	}
+	c.closeSpan()
+	if style := a.css(); style != "" {
+		fmt.Fprintf(&c.out, `<span style="%s">`, style)
+		c.lastHTML, c.lastOpen = a, true
+	}
+}
+
+func (c *Converter) closeSpan() {
+	if c.lastOpen {
+		c.out.WriteString("</span>")
+		c.lastOpen = false
+	}
+}
+
+// css returns inline CSS for a, or "" for the default style.
+func (a attrs) css() string {
+	fg, bg := a.fg, a.bg
+	if a.flags&attrReverse != 0 {
+		fg, bg = bg, fg
+	}
+
+	var decls []string
+	if fg != colorDefault {
+		decls = append(decls, "color:"+fg.hex())
+	}
+	if bg != colorDefault {
+		decls = append(decls, "background-color:"+bg.hex())
+	}
+	if a.flags&attrBold != 0 {
+		decls = append(decls, "font-weight:bold")
+	}
+	if a.flags&attrDim != 0 {
+		decls = append(decls, "opacity:0.7")
+	}
+	if a.flags&attrItalic != 0 {
+		decls = append(decls, "font-style:italic")
+	}
+	var lines []string
+	if a.flags&attrUnderline != 0 {
+		lines = append(lines, "underline")
+	}
+	if a.flags&attrStrike != 0 {
+		lines = append(lines, "line-through")
+	}
+	if len(lines) > 0 {
+		decls = append(decls, "text-decoration:"+strings.Join(lines, " "))
+	}
+	if a.flags&attrHidden != 0 {
+		decls = append(decls, "visibility:hidden")
+	}
+	return strings.Join(decls, ";")
+}
+
+// xtermBase are xterm's default RGB values for the 16 ANSI colours.
+var xtermBase = [16]uint32{
+	0x000000, 0xcd0000, 0x00cd00, 0xcdcd00, 0x0000ee, 0xcd00cd, 0x00cdcd, 0xe5e5e5,
+	0x7f7f7f, 0xff0000, 0x00ff00, 0xffff00, 0x5c5cff, 0xff00ff, 0x00ffff, 0xffffff,
+}
+
+// hex returns the colour as #rrggbb using xterm's palette.
+func (c color) hex() string {
+	var rgb uint32
+	switch c & colorKind {
+	case colorRGB:
+		rgb = uint32(c & 0xffffff)
+	case colorPalette:
+		switch n := uint32(c & 0xff); {
+		case n < 16:
+			rgb = xtermBase[n]
+		case n < 232:
+			// 6x6x6 colour cube.
+			levels := [6]uint32{0, 95, 135, 175, 215, 255}
+			n -= 16
+			rgb = levels[n/36]<<16 | levels[n/6%6]<<8 | levels[n%6]
+		default:
+			g := 8 + 10*(n-232)
+			rgb = g<<16 | g<<8 | g
+		}
+	}
+	return fmt.Sprintf("#%06x", rgb)
+}
@@ -0,0 +1,132 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	for in, want := range map[string]Format{"": FormatRaw, "raw": FormatRaw, "TEXT": FormatText, "html": FormatHTML} {
+		got, err := ParseFormat(in)
+		if err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("markdown"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestConvertText(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain", "hello world", "hello world"},
+		{"CRLF", "one\r\ntwo\r\n", "one\ntwo\n"},
+		{"SGR removed", "\x1b[1;31merror:\x1b[0m failed\r\n", "error: failed\n"},
+		{"backspace", "abc\b\bX", "aXc"},
+		{"backspace erase", "abc\b \b\b \bd\r\n", "ad\n"},
+		{"carriage return overwrites", "50%\r100%\r\n", "100%\n"},
+		{"erase to end of line", "Loading...\r\x1b[Kdone\r\n", "done\n"},
+		{"cursor forward is spacing", "a\x1b[3Cb", "a   b"},
+		{"OSC removed", "\x1b]0;title\x07ok\x1b]8;;http://x\x1b\\link\x1b]8;;\x1b\\", "oklink"},
+		{"private modes removed", "\x1b[?25l\x1b[?2004hok\x1b[?25h", "ok"},
+		{"trailing spaces trimmed", "text   \r\n", "text\n"},
+		{"tabs kept", "a\tb", "a\tb"},
+		{"bell dropped", "ding\x07", "ding"},
+		{"unicode", "héllo 世界", "héllo 世界"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewConverter(FormatText).Convert(tt.input); got != tt.want {
+				t.Errorf("Convert(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertCursorMovesClamped(t *testing.T) {
+	// Repeated forward moves must not pad the line beyond the widest screen.
+	c := NewConverter(FormatText)
+	got := c.Convert(strings.Repeat("\x1b[999C", 1000) + "x")
+	if len(got) != MaxCols {
+		t.Errorf("line after repeated CUF is %d bytes, want %d", len(got), MaxCols)
+	}
+
+	c = NewConverter(FormatText)
+	if got := c.Convert("ab\x1b[0Gc\x1b[99999Gd"); len(got) != MaxCols || got[0] != 'c' || got[MaxCols-1] != 'd' {
+		t.Errorf("CHA result %q, want c at column 1 and d at column %d", got, MaxCols)
+	}
+}
+
+func TestConvertAcrossChunks(t *testing.T) {
+	c := NewConverter(FormatText)
+	chunks := []string{"\x1b[3", "1mre", "d\x1b[0m\r", "\n\xe4\xb8", "\x96\xe7\x95\x8c\r\n"}
+	var got strings.Builder
+	for _, chunk := range chunks {
+		got.WriteString(c.Convert(chunk))
+	}
+	if got.String() != "red\n世界\n" {
+		t.Errorf("converted = %q, want %q", got.String(), "red\n世界\n")
+	}
+
+	// A CR rewrite of a line sent in an earlier chunk starts a new line
+	// rather than being appended to it.
+	c = NewConverter(FormatText)
+	first, second := c.Convert("Working |"), c.Convert("\rWorking /")
+	if first != "Working |" || second != "\nWorking /" {
+		t.Errorf("spinner chunks = %q, %q", first, second)
+	}
+}
+
+func TestConvertHTML(t *testing.T) {
+	c := NewConverter(FormatHTML)
+
+	got := c.Convert("<b>&</b> \x1b[1;31merror\x1b[0m \x1b[38;5;21mblue\x1b[m\r\n")
+	want := `&lt;b&gt;&amp;&lt;/b&gt; <span style="color:#cd0000;font-weight:bold">error</span> <span style="color:#0000ff">blue</span>` + "\n"
+	if got != want {
+		t.Errorf("Convert = %q, want %q", got, want)
+	}
+
+	// Styles stay open across chunks but every chunk is balanced HTML.
+	first := c.Convert("\x1b[4;48;2;1;2;3mab")
+	second := c.Convert("cd\x1b[m\r\n")
+	if first != `<span style="background-color:#010203;text-decoration:underline">ab</span>` {
+		t.Errorf("first chunk = %q", first)
+	}
+	if second != `<span style="background-color:#010203;text-decoration:underline">cd</span>`+"\n" {
+		t.Errorf("second chunk = %q", second)
+	}
+
+	// Reverse video swaps the colours.
+	if got := NewConverter(FormatHTML).Convert("\x1b[7;32;47mx"); got != `<span style="color:#e5e5e5;background-color:#00cd00">x</span>` {
+		t.Errorf("reverse = %q", got)
+	}
+}
+
+func TestConvertRaw(t *testing.T) {
+	in := "\x1b[31mred\x1b[0m\r\n"
+	if got := NewConverter(FormatRaw).Convert(in); got != in {
+		t.Errorf("raw Convert = %q, want input unchanged", got)
+	}
+}
+
+func TestColorHex(t *testing.T) {
+	tests := map[color]string{
+		colorPalette | 1:    "#cd0000",
+		colorPalette | 16:   "#000000",
+		colorPalette | 196:  "#ff0000",
+		colorPalette | 231:  "#ffffff",
+		colorPalette | 232:  "#080808",
+		colorPalette | 255:  "#eeeeee",
+		colorRGB | 0x123456: "#123456",
+	}
+	for c, want := range tests {
+		if got := c.hex(); got != want {
+			t.Errorf("hex(%x) = %s, want %s", uint32(c), got, want)
+		}
+	}
+}
@@ -0,0 +1,533 @@
+package terminal
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on untrusted input held by the parser between bytes.
+const (
+	maxParams   = 32
+	maxParamVal = 65535
+	maxOSC      = 4096
+)
+
+type parserState int
+
+const (
+	stateGround parserState = iota
+	stateEscape
+	stateEscapeIntermediate
+	stateCSI
+	stateOSC
+	stateString // DCS, SOS, PM and APC payloads, which are ignored
+)
+
+type parser struct {
+	state parserState
+
+	// pending holds an incomplete UTF-8 sequence split across writes.
+	pending []byte
+
+	params       []int
+	colon        []bool // params[i] was introduced by ':' (a sub-parameter)
+	open         bool   // a parameter has been started
+	private      byte   // '<', '=', '>' or '?' prefix
+	intermediate byte
+	ignore       bool // malformed sequence, dispatch nothing
+
+	osc    []byte
+	strEsc bool // ESC seen inside a string, possibly the start of ST
+}
+
+// handler receives the actions decoded by a parser.
+type handler interface {
+	print(r rune)
+	execute(b byte)
+	escDispatch(intermediate, final byte)
+	csiDispatch(p *parser, final byte)
+	oscDispatch(data []byte)
+}
+
+// Write feeds PTY output into the terminal. It never fails; malformed or
+// unsupported sequences are consumed and ignored the way a terminal would.
+func (t *Terminal) Write(p []byte) (int, error) {
+	t.parser.write(t, p)
+	return len(p), nil
+}
+
+// write decodes data, which may end in the middle of a UTF-8 character or an
+// escape sequence; the remainder is completed by the next call.
+func (p *parser) write(h handler, data []byte) {
+	if len(p.pending) > 0 {
+		data = append(p.pending, data...)
+		p.pending = nil
+	}
+
+	for i := 0; i < len(data); {
+		b := data[i]
+		if b >= 0x80 && p.state == stateGround {
+			if !utf8.FullRune(data[i:]) {
+				p.pending = append([]byte(nil), data[i:]...)
+				break
+			}
+			r, size := utf8.DecodeRune(data[i:])
+			h.print(r) // invalid bytes print as U+FFFD
+			i += size
+			continue
+		}
+		p.step(h, b)
+		i++
+	}
+}
+
+func (p *parser) step(h handler, b byte) {
+	if p.state == stateOSC || p.state == stateString {
+		p.stepString(h, b)
+		return
+	}
+
+	// C0 controls act immediately, even in the middle of a sequence.
+	if b < 0x20 || b == 0x7f {
+		switch b {
+		case 0x18, 0x1a: // CAN, SUB abort the sequence
+			p.state = stateGround
+		case 0x1b:
+			p.state = stateEscape
+			p.intermediate = 0
+		case 0x7f:
+		default:
+			h.execute(b)
+		}
+		return
+	}
+
+	switch p.state {
+	case stateGround:
+		h.print(rune(b))
+	case stateEscape:
+		p.escape(h, b)
+	case stateEscapeIntermediate:
+		p.state = stateGround
+		if b < 0x80 {
+			h.escDispatch(p.intermediate, b)
+		}
+	case stateCSI:
+		p.csiByte(h, b)
+	}
+}
+
+// execute handles a C0 control character.
+func (t *Terminal) execute(b byte) {
+	switch b {
+	case '\b':
+		if t.x > 0 {
+			t.x--
+		}
+		t.wrapNext = false
+	case '\t':
+		t.tab(1)
+	case '\n', '\v', '\f':
+		t.lineFeed()
+		t.wrapNext = false
+	case '\r':
+		t.x = 0
+		t.wrapNext = false
+	case 0x0e: // SO
+		t.activeG = 1
+	case 0x0f: // SI
+		t.activeG = 0
+	}
+}
+
+func (p *parser) escape(h handler, b byte) {
+	switch {
+	case b == '[':
+		p.params = p.params[:0]
+		p.colon = p.colon[:0]
+		p.open = false
+		p.private = 0
+		p.intermediate = 0
+		p.ignore = false
+		p.state = stateCSI
+	case b == ']':
+		p.osc = p.osc[:0]
+		p.strEsc = false
+		p.state = stateOSC
+	case b == 'P' || b == 'X' || b == '^' || b == '_':
+		p.strEsc = false
+		p.state = stateString
+	case b >= 0x20 && b <= 0x2f:
+		p.intermediate = b
+		p.state = stateEscapeIntermediate
+	default:
+		p.state = stateGround
+		if b < 0x80 {
+			h.escDispatch(0, b)
+		}
+	}
+}
+
+func (t *Terminal) escDispatch(intermediate, final byte) {
+	switch intermediate {
+	case 0:
+		switch final {
+		case '7': // DECSC
+			t.saveCursor()
+		case '8': // DECRC
+			t.restoreCursor()
+		case 'D': // IND
+			t.lineFeed()
+			t.wrapNext = false
+		case 'E': // NEL
+			t.x = 0
+			t.lineFeed()
+			t.wrapNext = false
+		case 'M': // RI
+			t.reverseIndex()
+			t.wrapNext = false
+		case 'H': // HTS
+			t.tabs[t.x] = true
+		case 'c': // RIS
+			t.reset()
+		}
+	case '(', ')':
+		// Designate G0/G1: "0" is DEC special graphics, anything else ASCII.
+		t.charsets[intermediate-'('] = final == '0'
+	case '#':
+		if final == '8' { // DECALN screen alignment test
+			for _, l := range t.lines {
+				for i := range l {
+					l[i] = cell{r: 'E'}
+				}
+			}
+		}
+	}
+}
+
+func (p *parser) csiByte(h handler, b byte) {
+	switch {
+	case b >= '0' && b <= '9':
+		if !p.open {
+			p.addParam(false)
+		}
+		if last := len(p.params) - 1; last >= 0 {
+			p.params[last] = min(p.params[last]*10+int(b-'0'), maxParamVal)
+		}
+	case b == ';' || b == ':':
+		if !p.open {
+			p.addParam(false)
+		}
+		p.addParam(b == ':')
+	case b >= '<' && b <= '?':
+		if p.open || p.private != 0 {
+			p.ignore = true
+		}
+		p.private = b
+	case b >= 0x20 && b <= 0x2f:
+		p.intermediate = b
+	case b >= 0x40 && b <= 0x7e:
+		p.state = stateGround
+		if !p.ignore {
+			h.csiDispatch(p, b)
+		}
+	default:
+		p.state = stateGround
+	}
+}
+
+func (p *parser) addParam(colon bool) {
+	p.open = true
+	if len(p.params) >= maxParams {
+		p.ignore = true
+		return
+	}
+	p.params = append(p.params, 0)
+	p.colon = append(p.colon, colon)
+}
+
+// param returns parameter i, or def when it is missing or zero.
+func (p *parser) param(i, def int) int {
+	if i < len(p.params) && p.params[i] != 0 {
+		return p.params[i]
+	}
+	return def
+}
+
+func (t *Terminal) csiDispatch(p *parser, final byte) {
+	if p.private == '?' && p.intermediate == 0 {
+		switch final {
+		case 'h':
+			t.setPrivateModes(p.params, true)
+		case 'l':
+			t.setPrivateModes(p.params, false)
+		}
+		return
+	}
+	if p.private != 0 || p.intermediate != 0 {
+		// Queries and xterm extensions (cursor style, key modifiers, ...)
+		// do not change the screen.
+		return
+	}
+
+	n := p.param(0, 1)
+	switch final {
+	case '@': // ICH
+		t.insertCells(n)
+	case 'A': // CUU
+		t.moveRel(-n)
+	case 'B', 'e': // CUD, VPR
+		t.moveRel(n)
+	case 'C', 'a': // CUF, HPR
+		t.setX(t.x + n)
+	case 'D': // CUB
+		t.setX(t.x - n)
+	case 'E': // CNL
+		t.moveRel(n)
+		t.x = 0
+	case 'F': // CPL
+		t.moveRel(-n)
+		t.x = 0
+	case 'G', '`': // CHA, HPA
+		t.setX(n - 1)
+	case 'H', 'f': // CUP, HVP
+		t.moveTo(p.param(1, 1)-1, n-1)
+	case 'I': // CHT
+		t.tab(n)
+	case 'J': // ED
+		t.eraseDisplay(p.param(0, 0))
+	case 'K': // EL
+		t.eraseLine(p.param(0, 0))
+	case 'L': // IL
+		t.insertLines(n)
+	case 'M': // DL
+		t.deleteLines(n)
+	case 'P': // DCH
+		t.deleteCells(n)
+	case 'S': // SU
+		t.scrollUp(n)
+	case 'T': // SD
+		t.scrollDown(n)
+	case 'X': // ECH
+		t.eraseCells(t.x, t.x+n)
+	case 'Z': // CBT
+		t.backTab(n)
+	case 'b': // REP
+		if t.lastRune != 0 {
+			for range min(n, t.cols*t.rows) {
+				t.print(t.lastRune)
+			}
+		}
+	case 'd': // VPA
+		t.moveTo(t.x, n-1)
+	case 'g': // TBC
+		switch p.param(0, 0) {
+		case 0:
+			t.tabs[t.x] = false
+		case 3:
+			clear(t.tabs)
+		}
+	case 'h', 'l': // SM, RM
+		for _, mode := range p.params {
+			if mode == 4 { // IRM
+				t.insertMode = final == 'h'
+			}
+		}
+	case 'm': // SGR
+		t.cur.sgr(p.params, p.colon)
+	case 'r': // DECSTBM
+		top, bottom := p.param(0, 1)-1, min(p.param(1, t.rows), t.rows)-1
+		if top < bottom {
+			t.top, t.bottom = top, bottom
+			t.moveTo(0, 0)
+		}
+	case 's': // SCOSC
+		t.saveCursor()
+	case 'u': // SCORC
+		t.restoreCursor()
+	}
+}
+
+func (t *Terminal) setX(x int) {
+	t.x = max(0, min(x, t.cols-1))
+	t.wrapNext = false
+}
+
+func (t *Terminal) setPrivateModes(modes []int, on bool) {
+	for _, mode := range modes {
+		switch mode {
+		case 6: // DECOM
+			t.originMode = on
+			t.moveTo(0, 0)
+		case 7: // DECAWM
+			t.autowrap = on
+			if !on {
+				t.wrapNext = false
+			}
+		case 25: // DECTCEM
+			t.cursorVisible = on
+		case 47, 1047:
+			t.setAltScreen(on, false)
+		case 1049:
+			t.setAltScreen(on, true)
+		}
+	}
+}
+
+// sgr applies Select Graphic Rendition parameters. colon[i] marks params[i]
+// as a sub-parameter of the one before it.
+func (a *attrs) sgr(params []int, colon []bool) {
+	if len(params) == 0 {
+		*a = attrs{}
+		return
+	}
+
+	for i := 0; i < len(params); {
+		v := params[i]
+		next := i + 1
+		for next < len(params) && colon[next] {
+			next++
+		}
+		sub := params[i+1 : next]
+
+		switch {
+		case v == 0:
+			*a = attrs{}
+		case v == 1:
+			a.flags |= attrBold
+		case v == 2:
+			a.flags |= attrDim
+		case v == 3:
+			a.flags |= attrItalic
+		case v == 4:
+			// "4:0" is the sub-parameter form of "no underline".
+			if len(sub) > 0 && sub[0] == 0 {
+				a.flags &^= attrUnderline
+			} else {
+				a.flags |= attrUnderline
+			}
+		case v == 5 || v == 6:
+			a.flags |= attrBlink
+		case v == 7:
+			a.flags |= attrReverse
+		case v == 8:
+			a.flags |= attrHidden
+		case v == 9:
+			a.flags |= attrStrike
+		case v == 21:
+			a.flags |= attrUnderline // double underline
+		case v == 22:
+			a.flags &^= attrBold | attrDim
+		case v == 23:
+			a.flags &^= attrItalic
+		case v == 24:
+			a.flags &^= attrUnderline
+		case v == 25:
+			a.flags &^= attrBlink
+		case v == 27:
+			a.flags &^= attrReverse
+		case v == 28:
+			a.flags &^= attrHidden
+		case v == 29:
+			a.flags &^= attrStrike
+		case v >= 30 && v <= 37:
+			a.fg = colorPalette | color(v-30)
+		case v == 39:
+			a.fg = colorDefault
+		case v >= 40 && v <= 47:
+			a.bg = colorPalette | color(v-40)
+		case v == 49:
+			a.bg = colorDefault
+		case v >= 90 && v <= 97:
+			a.fg = colorPalette | color(v-90+8)
+		case v >= 100 && v <= 107:
+			a.bg = colorPalette | color(v-100+8)
+		case v == 38 || v == 48:
+			var c color
+			var ok bool
+			if len(sub) > 0 {
+				c, _, ok = extendedColor(sub, true)
+			} else {
+				var used int
+				c, used, ok = extendedColor(params[next:], false)
+				next += used
+			}
+			if ok && v == 38 {
+				a.fg = c
+			} else if ok {
+				a.bg = c
+			}
+		}
+
+		i = next
+	}
+}
+
+// extendedColor parses the arguments of SGR 38/48: "5;n" for the 256-colour
+// palette or "2;r;g;b" for direct colour. The colon form may carry a colour
+// space id before r:g:b. It returns the number of arguments consumed.
+func extendedColor(args []int, colon bool) (color, int, bool) {
+	if len(args) == 0 {
+		return 0, 0, false
+	}
+	switch args[0] {
+	case 5:
+		if len(args) < 2 {
+			return 0, len(args), false
+		}
+		return colorPalette | color(args[1]&0xff), 2, true
+	case 2:
+		rgb := args[1:]
+		if colon && len(rgb) >= 4 {
+			rgb = rgb[1:]
+		}
+		if len(rgb) < 3 {
+			return 0, len(args), false
+		}
+		c := colorRGB | color(rgb[0]&0xff)<<16 | color(rgb[1]&0xff)<<8 | color(rgb[2]&0xff)
+		return c, 4, true
+	}
+	return 0, 1, false
+}
+
+// stepString consumes OSC and other string sequences, terminated by BEL or
+// ST (ESC \).
+func (p *parser) stepString(h handler, b byte) {
+	if p.strEsc {
+		p.strEsc = false
+		if b == '\\' {
+			if p.state == stateOSC {
+				h.oscDispatch(p.osc)
+			}
+			p.state = stateGround
+			return
+		}
+		// Any other ESC sequence cancels the string and starts afresh.
+		p.state = stateEscape
+		p.step(h, b)
+		return
+	}
+
+	switch {
+	case b == 0x1b:
+		p.strEsc = true
+	case b == 0x07:
+		if p.state == stateOSC {
+			h.oscDispatch(p.osc)
+		}
+		p.state = stateGround
+	case b == 0x18 || b == 0x1a:
+		p.state = stateGround
+	case p.state == stateOSC && b >= 0x20 && len(p.osc) < maxOSC:
+		p.osc = append(p.osc, b)
+	}
+}
+
+func (t *Terminal) oscDispatch(data []byte) {
+	code, text, _ := bytes.Cut(data, []byte(";"))
+	switch string(code) {
+	case "0", "2": // icon name and title, title
+		t.title = strings.ToValidUTF8(string(text), "�")
+	}
+}
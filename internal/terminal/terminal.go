@@ -0,0 +1,684 @@
+// Package terminal implements a server-side VT100/xterm emulator that keeps
+// the screen grid and scrollback for a PTY's output, so clients can be served
+// a rendered snapshot instead of raw escape sequences.
+package terminal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Default geometry, matching the size sessions' PTYs start with.
+const (
+	DefaultCols = 80
+	DefaultRows = 24
+)
+
+// Size limits accepted by Resize, bounding the memory a screen can use.
+const (
+	MaxCols = 1000
+	MaxRows = 1000
+)
+
+// DefaultScrollback is the number of scrolled-off lines kept when the caller
+// does not configure a limit.
+const DefaultScrollback = 1000
+
+// Attribute flags.
+const (
+	attrBold uint16 = 1 << iota
+	attrDim
+	attrItalic
+	attrUnderline
+	attrBlink
+	attrReverse
+	attrHidden
+	attrStrike
+)
+
+// color is colorDefault, a palette index tagged with colorPalette, or a 24-bit
+// RGB value tagged with colorRGB.
+type color uint32
+
+const (
+	colorDefault color = 0
+	colorPalette color = 1 << 24
+	colorRGB     color = 2 << 24
+	colorKind    color = 0xff << 24
+)
+
+type attrs struct {
+	fg, bg color
+	flags  uint16
+}
+
+// wideTail marks the second column of a double-width character.
+const wideTail rune = -1
+
+type cell struct {
+	r rune // 0 is a blank cell
+	a attrs
+}
+
+type line []cell
+
+type savedCursor struct {
+	x, y     int
+	a        attrs
+	origin   bool
+	charsets [2]bool
+	activeG  int
+}
+
+// Terminal is a VT100/xterm screen model. It is not safe for concurrent use;
+// callers serialize Write, Resize and Snapshot.
+type Terminal struct {
+	cols, rows int
+
+	lines      []line // active screen
+	primary    []line // primary screen saved while the alternate screen is shown
+	scrollback []line
+	maxScroll  int
+
+	x, y     int
+	wrapNext bool // cursor is past the last column; the next character wraps
+	cur      attrs
+	saved    savedCursor
+
+	top, bottom   int // scroll region, inclusive
+	autowrap      bool
+	originMode    bool
+	insertMode    bool
+	cursorVisible bool
+	tabs          []bool
+	charsets      [2]bool // G0/G1 use DEC special graphics
+	activeG       int
+	title         string
+	lastRune      rune
+
+	parser parser
+}
+
+// New returns a terminal of the given size keeping up to scrollback lines of
+// history (DefaultScrollback when <= 0).
+func New(cols, rows, scrollback int) *Terminal {
+	if cols <= 0 || cols > MaxCols {
+		cols = DefaultCols
+	}
+	if rows <= 0 || rows > MaxRows {
+		rows = DefaultRows
+	}
+	if scrollback <= 0 {
+		scrollback = DefaultScrollback
+	}
+	t := &Terminal{cols: cols, rows: rows, maxScroll: scrollback}
+	t.reset()
+	return t
+}
+
+// reset restores power-on state (RIS), keeping size and scrollback.
+func (t *Terminal) reset() {
+	t.lines = t.blankLines(t.rows)
+	t.primary = nil
+	t.x, t.y = 0, 0
+	t.wrapNext = false
+	t.cur = attrs{}
+	t.saved = savedCursor{}
+	t.top, t.bottom = 0, t.rows-1
+	t.autowrap = true
+	t.originMode = false
+	t.insertMode = false
+	t.cursorVisible = true
+	t.charsets = [2]bool{}
+	t.activeG = 0
+	t.title = ""
+	t.resetTabs()
+	t.parser = parser{}
+}
+
+func (t *Terminal) resetTabs() {
+	t.tabs = make([]bool, t.cols)
+	for i := 8; i < t.cols; i += 8 {
+		t.tabs[i] = true
+	}
+}
+
+// Size returns the terminal dimensions.
+func (t *Terminal) Size() (cols, rows int) {
+	return t.cols, t.rows
+}
+
+// Resize changes the screen size, clamped to MaxCols x MaxRows. Lines are
+// truncated or padded; when the screen shrinks below the cursor, lines
+// scrolled off the top go to scrollback so the cursor row stays visible.
+func (t *Terminal) Resize(cols, rows int) {
+	if cols <= 0 || rows <= 0 {
+		return
+	}
+	cols, rows = min(cols, MaxCols), min(rows, MaxRows)
+	if cols == t.cols && rows == t.rows {
+		return
+	}
+
+	if rows < t.rows && t.y >= rows {
+		shift := t.y - rows + 1
+		if t.primary == nil {
+			t.pushScrollback(t.lines[:shift])
+		}
+		t.lines = t.lines[shift:]
+		t.y -= shift
+	}
+
+	t.lines = resizeLines(t.lines, cols, rows)
+	if t.primary != nil {
+		t.primary = resizeLines(t.primary, cols, rows)
+	}
+
+	t.cols, t.rows = cols, rows
+	t.top, t.bottom = 0, rows-1
+	t.x = min(t.x, cols-1)
+	t.y = min(t.y, rows-1)
+	t.saved.x = min(t.saved.x, cols-1)
+	t.saved.y = min(t.saved.y, rows-1)
+	t.wrapNext = false
+	t.resetTabs()
+}
+
+func resizeLines(lines []line, cols, rows int) []line {
+	out := make([]line, rows)
+	for i := range out {
+		l := make(line, cols)
+		if i < len(lines) {
+			copy(l, lines[i])
+			// Don't leave half of a wide character at the new right edge.
+			if cols < len(lines[i]) && lines[i][cols].r == wideTail {
+				l[cols-1] = cell{}
+			}
+		}
+		out[i] = l
+	}
+	return out
+}
+
+func (t *Terminal) blankLines(n int) []line {
+	lines := make([]line, n)
+	for i := range lines {
+		lines[i] = t.blankLine()
+	}
+	return lines
+}
+
+// blankLine returns an erased line. Erased cells take the current background
+// colour (xterm's background colour erase).
+func (t *Terminal) blankLine() line {
+	l := make(line, t.cols)
+	if t.cur.bg != colorDefault {
+		for i := range l {
+			l[i].a.bg = t.cur.bg
+		}
+	}
+	return l
+}
+
+func (t *Terminal) blankCell() cell {
+	return cell{a: attrs{bg: t.cur.bg}}
+}
+
+func (t *Terminal) pushScrollback(lines []line) {
+	for _, l := range lines {
+		t.scrollback = append(t.scrollback, append(line(nil), l...))
+	}
+	if over := len(t.scrollback) - t.maxScroll; over > 0 {
+		t.scrollback = append([]line(nil), t.scrollback[over:]...)
+	}
+}
+
+// print writes one character at the cursor.
+func (t *Terminal) print(r rune) {
+	if t.charsets[t.activeG] && r >= 0x60 && r <= 0x7e {
+		r = decGraphics[r-0x60]
+	}
+
+	w := runeWidth(r)
+	if w == 0 {
+		// Combining marks and other zero-width characters are dropped.
+		return
+	}
+
+	if t.wrapNext {
+		t.wrapNext = false
+		if t.autowrap {
+			t.x = 0
+			t.lineFeed()
+		}
+	}
+	if w == 2 && t.x == t.cols-1 {
+		// A wide character never straddles the right margin.
+		if !t.autowrap {
+			return
+		}
+		t.setCell(t.x, t.y, t.blankCell())
+		t.x = 0
+		t.lineFeed()
+	}
+
+	if t.insertMode {
+		t.insertCells(w)
+	}
+
+	t.setCell(t.x, t.y, cell{r: r, a: t.cur})
+	if w == 2 {
+		t.setCell(t.x+1, t.y, cell{r: wideTail, a: t.cur})
+	}
+	t.lastRune = r
+
+	t.x += w
+	if t.x >= t.cols {
+		t.x = t.cols - 1
+		t.wrapNext = true
+	}
+}
+
+// setCell writes c, blanking the other half of any wide character it splits.
+func (t *Terminal) setCell(x, y int, c cell) {
+	l := t.lines[y]
+	if l[x].r == wideTail && x > 0 && c.r != wideTail {
+		l[x-1] = t.blankCell()
+	}
+	if x+1 < len(l) && l[x+1].r == wideTail && c.r != wideTail {
+		l[x+1] = t.blankCell()
+	}
+	l[x] = c
+}
+
+func (t *Terminal) lineFeed() {
+	switch {
+	case t.y == t.bottom:
+		t.scrollUp(1)
+	case t.y < t.rows-1:
+		t.y++
+	}
+}
+
+func (t *Terminal) reverseIndex() {
+	switch {
+	case t.y == t.top:
+		t.scrollDown(1)
+	case t.y > 0:
+		t.y--
+	}
+}
+
+// scrollUp moves the scroll region up n lines. Lines leaving the top of a
+// full-width region on the primary screen are kept as scrollback.
+func (t *Terminal) scrollUp(n int) {
+	t.scrollRegionUp(t.top, n, t.top == 0 && t.primary == nil)
+}
+
+func (t *Terminal) scrollRegionUp(top, n int, keep bool) {
+	n = min(n, t.bottom-top+1)
+	if keep {
+		t.pushScrollback(t.lines[top : top+n])
+	}
+	copy(t.lines[top:], t.lines[top+n:t.bottom+1])
+	for i := t.bottom - n + 1; i <= t.bottom; i++ {
+		t.lines[i] = t.blankLine()
+	}
+}
+
+func (t *Terminal) scrollDown(n int) {
+	t.scrollRegionDown(t.top, n)
+}
+
+func (t *Terminal) scrollRegionDown(top, n int) {
+	n = min(n, t.bottom-top+1)
+	copy(t.lines[top+n:t.bottom+1], t.lines[top:t.bottom+1-n])
+	for i := top; i < top+n; i++ {
+		t.lines[i] = t.blankLine()
+	}
+}
+
+func (t *Terminal) insertLines(n int) {
+	if t.y < t.top || t.y > t.bottom {
+		return
+	}
+	t.scrollRegionDown(t.y, n)
+	t.x = 0
+}
+
+func (t *Terminal) deleteLines(n int) {
+	if t.y < t.top || t.y > t.bottom {
+		return
+	}
+	// Deleted lines are not scrollback, even at the top of the screen.
+	t.scrollRegionUp(t.y, n, false)
+	t.x = 0
+}
+
+func (t *Terminal) insertCells(n int) {
+	l := t.lines[t.y]
+	n = min(n, t.cols-t.x)
+	copy(l[t.x+n:], l[t.x:t.cols-n])
+	for i := t.x; i < t.x+n; i++ {
+		l[i] = t.blankCell()
+	}
+}
+
+func (t *Terminal) deleteCells(n int) {
+	l := t.lines[t.y]
+	n = min(n, t.cols-t.x)
+	copy(l[t.x:], l[t.x+n:])
+	for i := t.cols - n; i < t.cols; i++ {
+		l[i] = t.blankCell()
+	}
+}
+
+func (t *Terminal) eraseCells(from, to int) {
+	l := t.lines[t.y]
+	for i := max(from, 0); i < min(to, t.cols); i++ {
+		l[i] = t.blankCell()
+	}
+}
+
+// eraseDisplay implements ED: 0 below, 1 above, 2 all, 3 all plus scrollback.
+func (t *Terminal) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		t.eraseCells(t.x, t.cols)
+		for i := t.y + 1; i < t.rows; i++ {
+			t.lines[i] = t.blankLine()
+		}
+	case 1:
+		t.eraseCells(0, t.x+1)
+		for i := 0; i < t.y; i++ {
+			t.lines[i] = t.blankLine()
+		}
+	case 2, 3:
+		for i := range t.lines {
+			t.lines[i] = t.blankLine()
+		}
+		if mode == 3 {
+			t.scrollback = nil
+		}
+	}
+}
+
+// eraseLine implements EL: 0 right of cursor, 1 left, 2 whole line.
+func (t *Terminal) eraseLine(mode int) {
+	switch mode {
+	case 0:
+		t.eraseCells(t.x, t.cols)
+	case 1:
+		t.eraseCells(0, t.x+1)
+	case 2:
+		t.eraseCells(0, t.cols)
+	}
+}
+
+// moveTo positions the cursor, honouring origin mode for the row.
+func (t *Terminal) moveTo(x, y int) {
+	minY, maxY := 0, t.rows-1
+	if t.originMode {
+		y += t.top
+		minY, maxY = t.top, t.bottom
+	}
+	t.x = max(0, min(x, t.cols-1))
+	t.y = max(minY, min(y, maxY))
+	t.wrapNext = false
+}
+
+// moveRel moves the cursor vertically, stopping at the scroll margins when
+// starting inside them.
+func (t *Terminal) moveRel(dy int) {
+	y := t.y + dy
+	if t.y >= t.top && t.y <= t.bottom {
+		y = max(t.top, min(y, t.bottom))
+	}
+	t.y = max(0, min(y, t.rows-1))
+	t.wrapNext = false
+}
+
+func (t *Terminal) tab(n int) {
+	for ; n > 0 && t.x < t.cols-1; n-- {
+		t.x++
+		for t.x < t.cols-1 && !t.tabs[t.x] {
+			t.x++
+		}
+	}
+	t.wrapNext = false
+}
+
+func (t *Terminal) backTab(n int) {
+	for ; n > 0 && t.x > 0; n-- {
+		t.x--
+		for t.x > 0 && !t.tabs[t.x] {
+			t.x--
+		}
+	}
+	t.wrapNext = false
+}
+
+func (t *Terminal) saveCursor() {
+	t.saved = savedCursor{x: t.x, y: t.y, a: t.cur, origin: t.originMode, charsets: t.charsets, activeG: t.activeG}
+}
+
+func (t *Terminal) restoreCursor() {
+	t.x, t.y = min(t.saved.x, t.cols-1), min(t.saved.y, t.rows-1)
+	t.cur = t.saved.a
+	t.originMode = t.saved.origin
+	t.charsets = t.saved.charsets
+	t.activeG = t.saved.activeG
+	t.wrapNext = false
+}
+
+// setAltScreen switches between the primary and alternate screens. The
+// alternate screen starts blank and keeps no scrollback.
+func (t *Terminal) setAltScreen(on, saveCursor bool) {
+	if on == (t.primary != nil) {
+		return
+	}
+	if on {
+		if saveCursor {
+			t.saveCursor()
+		}
+		t.primary = t.lines
+		t.lines = t.blankLines(t.rows)
+	} else {
+		t.lines = t.primary
+		t.primary = nil
+		if saveCursor {
+			t.restoreCursor()
+		}
+	}
+	t.top, t.bottom = 0, t.rows-1
+	t.wrapNext = false
+}
+
+// Style describes the rendition of a run of text. Colours are empty for the
+// default, a palette index ("0"-"255"), or "#rrggbb".
+type Style struct {
+	FG        string `json:"fg,omitempty"`
+	BG        string `json:"bg,omitempty"`
+	Bold      bool   `json:"bold,omitempty"`
+	Dim       bool   `json:"dim,omitempty"`
+	Italic    bool   `json:"italic,omitempty"`
+	Underline bool   `json:"underline,omitempty"`
+	Blink     bool   `json:"blink,omitempty"`
+	Reverse   bool   `json:"reverse,omitempty"`
+	Hidden    bool   `json:"hidden,omitempty"`
+	Strike    bool   `json:"strike,omitempty"`
+}
+
+// Run is a span of consecutive cells with the same style.
+type Run struct {
+	Text string `json:"text"`
+	Style
+}
+
+// Snapshot is a rendered copy of the screen.
+type Snapshot struct {
+	Cols          int      `json:"cols"`
+	Rows          int      `json:"rows"`
+	CursorX       int      `json:"cursorX"`
+	CursorY       int      `json:"cursorY"`
+	CursorVisible bool     `json:"cursorVisible"`
+	AltScreen     bool     `json:"altScreen"`
+	Title         string   `json:"title,omitempty"`
+	Lines         []string `json:"lines"`                // visible rows, trailing spaces trimmed
+	Scrollback    []string `json:"scrollback,omitempty"` // oldest first
+	Runs          [][]Run  `json:"runs,omitempty"`       // per visible row, when attributes are requested
+}
+
+// SnapshotOptions selects optional parts of a Snapshot.
+type SnapshotOptions struct {
+	Attributes bool // include styled runs for each visible row
+	Scrollback int  // number of most recent scrollback lines to include
+}
+
+// Snapshot renders the current screen.
+func (t *Terminal) Snapshot(opts SnapshotOptions) Snapshot {
+	snap := Snapshot{
+		Cols:          t.cols,
+		Rows:          t.rows,
+		CursorX:       t.x,
+		CursorY:       t.y,
+		CursorVisible: t.cursorVisible,
+		AltScreen:     t.primary != nil,
+		Title:         t.title,
+		Lines:         make([]string, len(t.lines)),
+	}
+	for i, l := range t.lines {
+		snap.Lines[i] = l.text()
+	}
+
+	if n := min(opts.Scrollback, len(t.scrollback)); n > 0 {
+		snap.Scrollback = make([]string, n)
+		for i, l := range t.scrollback[len(t.scrollback)-n:] {
+			snap.Scrollback[i] = l.text()
+		}
+	}
+
+	if opts.Attributes {
+		snap.Runs = make([][]Run, len(t.lines))
+		for i, l := range t.lines {
+			snap.Runs[i] = l.runs()
+		}
+	}
+
+	return snap
+}
+
+// Text returns the visible screen as text with trailing blank lines removed.
+func (s Snapshot) Text() string {
+	end := len(s.Lines)
+	for end > 0 && s.Lines[end-1] == "" {
+		end--
+	}
+	return strings.Join(s.Lines[:end], "\n")
+}
+
+func (l line) text() string {
+	var b strings.Builder
+	for _, c := range l {
+		switch c.r {
+		case wideTail:
+		case 0:
+			b.WriteByte(' ')
+		default:
+			b.WriteRune(c.r)
+		}
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+func (l line) runs() []Run {
+	// Trailing blank cells with default attributes carry no information.
+	end := len(l)
+	for end > 0 && l[end-1].r == 0 && l[end-1].a == (attrs{}) {
+		end--
+	}
+
+	var runs []Run
+	var b strings.Builder
+	var current attrs
+	for i, c := range l[:end] {
+		if i > 0 && c.a != current {
+			runs = append(runs, Run{Text: b.String(), Style: current.style()})
+			b.Reset()
+		}
+		current = c.a
+		switch c.r {
+		case wideTail:
+		case 0:
+			b.WriteByte(' ')
+		default:
+			b.WriteRune(c.r)
+		}
+	}
+	if end > 0 {
+		runs = append(runs, Run{Text: b.String(), Style: current.style()})
+	}
+	return runs
+}
+
+func (a attrs) style() Style {
+	return Style{
+		FG:        a.fg.String(),
+		BG:        a.bg.String(),
+		Bold:      a.flags&attrBold != 0,
+		Dim:       a.flags&attrDim != 0,
+		Italic:    a.flags&attrItalic != 0,
+		Underline: a.flags&attrUnderline != 0,
+		Blink:     a.flags&attrBlink != 0,
+		Reverse:   a.flags&attrReverse != 0,
+		Hidden:    a.flags&attrHidden != 0,
+		Strike:    a.flags&attrStrike != 0,
+	}
+}
+
+func (c color) String() string {
+	switch c & colorKind {
+	case colorPalette:
+		return strconv.Itoa(int(c & 0xff))
+	case colorRGB:
+		return fmt.Sprintf("#%06x", uint32(c&0xffffff))
+	default:
+		return ""
+	}
+}
+
+// decGraphics maps 0x60-0x7e to the DEC special graphics (line drawing) set.
+var decGraphics = []rune("◆▒␉␌␍␊°±␤␋┘┐┌└┼⎺⎻─⎼⎽├┤┴┬│≤≥π≠£·")
+
+// runeWidth returns the number of columns r occupies.
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x20 || (r >= 0x7f && r < 0xa0):
+		return 0
+	case r == 0x200b || r == 0x200c || r == 0x200d || r == 0xfeff:
+		return 0
+	case unicode.In(r, unicode.Mn, unicode.Me):
+		return 0
+	case isWide(r):
+		return 2
+	}
+	return 1
+}
+
+// isWide reports East Asian wide/fullwidth characters and emoji.
+func isWide(r rune) bool {
+	return r >= 0x1100 && (r <= 0x115f ||
+		r == 0x2329 || r == 0x232a ||
+		(r >= 0x2e80 && r <= 0xa4cf && r != 0x303f) ||
+		(r >= 0xac00 && r <= 0xd7a3) ||
+		(r >= 0xf900 && r <= 0xfaff) ||
+		(r >= 0xfe30 && r <= 0xfe6f) ||
+		(r >= 0xff00 && r <= 0xff60) ||
+		(r >= 0xffe0 && r <= 0xffe6) ||
+		(r >= 0x1f300 && r <= 0x1f64f) ||
+		(r >= 0x1f680 && r <= 0x1f6ff) ||
+		(r >= 0x1f900 && r <= 0x1faff) ||
+		(r >= 0x20000 && r <= 0x3fffd))
+}
@@ -0,0 +1,275 @@
+package terminal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// feed writes s to a new terminal of the given size.
+func feed(cols, rows int, s string) *Terminal {
+	t := New(cols, rows, 0)
+	t.Write([]byte(s))
+	return t
+}
+
+func screenText(t *Terminal) string {
+	return t.Snapshot(SnapshotOptions{}).Text()
+}
+
+func TestScreenRendering(t *testing.T) {
+	tests := []struct {
+		name  string
+		cols  int
+		input string
+		want  string
+	}{
+		{"plain lines", 20, "hello\r\nworld", "hello\nworld"},
+		{"carriage return overwrites", 20, "hello\rj", "jello"},
+		{"backspace", 20, "abc\b\bX", "aXc"},
+		{"tab stops", 20, "a\tb", "a       b"},
+		{"autowrap", 5, "abcdefg", "abcde\nfg"},
+		{"pending wrap then CR", 5, "abcde\r\nx", "abcde\nx"},
+		{"cursor position", 10, "\x1b[2;3Hx\x1b[1;1Hy", "y\n  x"},
+		{"cursor movement", 10, "abc\x1b[2D\x1b[BX\x1b[AY", "abY\n X"},
+		{"erase line right", 10, "abcdef\x1b[3G\x1b[K", "ab"},
+		{"erase line left", 10, "abcdef\x1b[3G\x1b[1K", "   def"},
+		{"erase display", 10, "abc\r\ndef\x1b[2J", ""},
+		{"erase below", 10, "abc\r\ndef\r\nghi\x1b[2;2H\x1b[J", "abc\nd"},
+		{"insert chars", 10, "abcd\x1b[1G\x1b[2@", "  abcd"},
+		{"delete chars", 10, "abcdef\x1b[2G\x1b[2P", "adef"},
+		{"erase chars", 10, "abcdef\x1b[2G\x1b[2X", "a  def"},
+		{"repeat", 10, "a\x1b[3b", "aaaa"},
+		{"insert line", 10, "one\r\ntwo\x1b[1;1H\x1b[L", "\none\ntwo"},
+		{"delete line", 10, "one\r\ntwo\r\nthree\x1b[1;1H\x1b[M", "two\nthree"},
+		{"wide characters", 10, "世界!", "世界!"},
+		{"wide character wraps", 5, "abcd世", "abcd\n世"},
+		{"DEC line drawing", 10, "\x1b(0lqk\x1b(Bx", "┌─┐x"},
+		{"utf8", 10, "héllo", "héllo"},
+		{"OSC title is not printed", 10, "\x1b]0;title\x07ok\x1b]2;t\x1b\\", "ok"},
+		{"DCS is ignored", 10, "\x1bP+q544e\x1b\\ok", "ok"},
+		{"unknown CSI ignored", 10, "\x1b[>4;2m\x1b[?2004h\x1b[5 qok", "ok"},
+		{"SGR not printed", 10, "\x1b[1;31mred\x1b[0m", "red"},
+		{"CAN aborts sequence", 10, "\x1b[3\x18ok", "ok"},
+		{"reset", 10, "junk\x1bcok", "ok"},
+		{"save restore cursor", 10, "\x1b7abc\x1b8X", "Xbc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := feed(tt.cols, 5, tt.input)
+			if got := screenText(term); got != tt.want {
+				t.Errorf("screen = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitWrites(t *testing.T) {
+	input := "\x1b[1;31mhé世\x1b]0;title\x07界\x1b[0m"
+	want := feed(20, 5, input).Snapshot(SnapshotOptions{Attributes: true})
+
+	// Every split point, including inside UTF-8 and escape sequences, must
+	// produce the same screen.
+	for i := 1; i < len(input); i++ {
+		term := New(20, 5, 0)
+		term.Write([]byte(input[:i]))
+		term.Write([]byte(input[i:]))
+		got := term.Snapshot(SnapshotOptions{Attributes: true})
+
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(want)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("split at %d: got %s, want %s", i, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestScrollback(t *testing.T) {
+	term := New(10, 3, 2)
+	term.Write([]byte("1\r\n2\r\n3\r\n4\r\n5\r\n6"))
+
+	snap := term.Snapshot(SnapshotOptions{Scrollback: 10})
+	if got := strings.Join(snap.Lines, ","); got != "4,5,6" {
+		t.Errorf("Lines = %q, want 4,5,6", got)
+	}
+	// Only the two most recent lines are kept.
+	if got := strings.Join(snap.Scrollback, ","); got != "2,3" {
+		t.Errorf("Scrollback = %q, want 2,3", got)
+	}
+
+	if snap := term.Snapshot(SnapshotOptions{Scrollback: 1}); len(snap.Scrollback) != 1 || snap.Scrollback[0] != "3" {
+		t.Errorf("Scrollback limited to 1 = %q, want [3]", snap.Scrollback)
+	}
+
+	term.Write([]byte("\x1b[3J"))
+	if snap := term.Snapshot(SnapshotOptions{Scrollback: 10}); len(snap.Scrollback) != 0 {
+		t.Errorf("Expected ED 3 to clear scrollback, got %q", snap.Scrollback)
+	}
+}
+
+func TestScrollRegion(t *testing.T) {
+	// Status line at the bottom stays put while the region above scrolls.
+	term := New(10, 4, 0)
+	term.Write([]byte("\x1b[4;1Hstatus\x1b[1;3r\x1b[1;1Ha\r\nb\r\nc\r\nd"))
+
+	snap := term.Snapshot(SnapshotOptions{Scrollback: 10})
+	if got := strings.Join(snap.Lines, ","); got != "b,c,d,status" {
+		t.Errorf("Lines = %q, want b,c,d,status", got)
+	}
+	if got := strings.Join(snap.Scrollback, ","); got != "a" {
+		t.Errorf("Scrollback = %q, want a", got)
+	}
+
+	// Reverse index at the top of the region scrolls it down.
+	term.Write([]byte("\x1b[1;1H\x1bMz"))
+	if got := screenText(term); got != "z\nb\nc\nstatus" {
+		t.Errorf("screen after RI = %q", got)
+	}
+}
+
+func TestAltScreen(t *testing.T) {
+	term := New(10, 3, 0)
+	term.Write([]byte("shell$ \x1b[?1049h\x1b[Hfullscreen"))
+
+	snap := term.Snapshot(SnapshotOptions{})
+	if !snap.AltScreen || snap.Text() != "fullscreen" {
+		t.Errorf("alt screen = %v %q, want fullscreen", snap.AltScreen, snap.Text())
+	}
+
+	// Scrolling on the alternate screen keeps no history.
+	term.Write([]byte("\r\n\r\n\r\n\r\n"))
+	if snap := term.Snapshot(SnapshotOptions{Scrollback: 10}); len(snap.Scrollback) != 0 {
+		t.Errorf("Expected no scrollback from alt screen, got %q", snap.Scrollback)
+	}
+
+	// Leaving restores the primary screen and cursor.
+	term.Write([]byte("\x1b[?1049lok"))
+	snap = term.Snapshot(SnapshotOptions{})
+	if snap.AltScreen || snap.Text() != "shell$ ok" {
+		t.Errorf("primary screen = %v %q, want shell$ ok", snap.AltScreen, snap.Text())
+	}
+}
+
+func TestCursorState(t *testing.T) {
+	term := feed(10, 5, "\x1b[3;4H\x1b[?25l")
+	snap := term.Snapshot(SnapshotOptions{})
+	if snap.CursorX != 3 || snap.CursorY != 2 || snap.CursorVisible {
+		t.Errorf("cursor = (%d,%d) visible=%v, want (3,2) hidden", snap.CursorX, snap.CursorY, snap.CursorVisible)
+	}
+
+	// Out-of-range positions clamp to the screen.
+	term.Write([]byte("\x1b[99;99H\x1b[?25h"))
+	snap = term.Snapshot(SnapshotOptions{})
+	if snap.CursorX != 9 || snap.CursorY != 4 || !snap.CursorVisible {
+		t.Errorf("cursor = (%d,%d) visible=%v, want (9,4) visible", snap.CursorX, snap.CursorY, snap.CursorVisible)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	term := feed(10, 2, "\x1b]0;first\x07\x1b]2;second\x1b\\")
+	if got := term.Snapshot(SnapshotOptions{}).Title; got != "second" {
+		t.Errorf("Title = %q, want second", got)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	term := feed(20, 2, "plain \x1b[1;31mbold red\x1b[0m \x1b[38;5;208;48;2;1;2;3mx\x1b[38:2::255:0:16;4;7my")
+	runs := term.Snapshot(SnapshotOptions{Attributes: true}).Runs[0]
+
+	want := []Run{
+		{Text: "plain "},
+		{Text: "bold red", Style: Style{FG: "1", Bold: true}},
+		{Text: " "},
+		{Text: "x", Style: Style{FG: "208", BG: "#010203"}},
+		{Text: "y", Style: Style{FG: "#ff0010", BG: "#010203", Underline: true, Reverse: true}},
+	}
+	if len(runs) != len(want) {
+		t.Fatalf("runs = %+v, want %+v", runs, want)
+	}
+	for i := range want {
+		if runs[i] != want[i] {
+			t.Errorf("run %d = %+v, want %+v", i, runs[i], want[i])
+		}
+	}
+
+	// Without the option no runs are rendered.
+	if runs := term.Snapshot(SnapshotOptions{}).Runs; runs != nil {
+		t.Errorf("Expected no runs without Attributes, got %+v", runs)
+	}
+}
+
+func TestSGRReset(t *testing.T) {
+	term := feed(20, 1, "\x1b[1;3;4;91;44ma\x1b[22;23;24;39;49mb\x1b[97mc\x1b[mb")
+	runs := term.Snapshot(SnapshotOptions{Attributes: true}).Runs[0]
+
+	want := []Run{
+		{Text: "a", Style: Style{FG: "9", BG: "4", Bold: true, Italic: true, Underline: true}},
+		{Text: "b"},
+		{Text: "c", Style: Style{FG: "15"}},
+		{Text: "b"},
+	}
+	if len(runs) != len(want) {
+		t.Fatalf("runs = %+v, want %+v", runs, want)
+	}
+	for i := range want {
+		if runs[i] != want[i] {
+			t.Errorf("run %d = %+v, want %+v", i, runs[i], want[i])
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	term := New(10, 4, 0)
+	term.Write([]byte("1\r\n2\r\n3\r\n4 long"))
+
+	// Shrinking below the cursor pushes the top rows into scrollback.
+	term.Resize(6, 2)
+	snap := term.Snapshot(SnapshotOptions{Scrollback: 10})
+	if snap.Cols != 6 || snap.Rows != 2 {
+		t.Errorf("size = %dx%d, want 6x2", snap.Cols, snap.Rows)
+	}
+	if got := strings.Join(snap.Lines, ","); got != "3,4 long" {
+		t.Errorf("Lines = %q, want 3,4 long", got)
+	}
+	if got := strings.Join(snap.Scrollback, ","); got != "1,2" {
+		t.Errorf("Scrollback = %q, want 1,2", got)
+	}
+	if snap.CursorY != 1 || snap.CursorX != 5 {
+		t.Errorf("cursor = (%d,%d), want (5,1)", snap.CursorX, snap.CursorY)
+	}
+
+	// Growing adds blank rows and the new width is usable.
+	term.Resize(20, 3)
+	term.Write([]byte("\x1b[3;1H" + strings.Repeat("x", 20)))
+	if got := term.Snapshot(SnapshotOptions{}).Lines[2]; got != strings.Repeat("x", 20) {
+		t.Errorf("row after grow = %q", got)
+	}
+
+	// Invalid sizes are ignored.
+	term.Resize(0, -1)
+	if cols, rows := term.Size(); cols != 20 || rows != 3 {
+		t.Errorf("size = %dx%d after invalid resize, want 20x3", cols, rows)
+	}
+}
+
+func TestMalformedInput(t *testing.T) {
+	term := New(10, 3, 0)
+	inputs := []string{
+		"\x1b[" + strings.Repeat("9", 100) + "A",
+		"\x1b[" + strings.Repeat("1;", 100) + "m",
+		"\x1b]" + strings.Repeat("x", 10000),
+		"\x07\x1b\\\xff\xfe\x9b",
+		"\x1b[99999;99999r\x1b[0;0r\x1b[5;2r",
+		"\x1b[99999@\x1b[99999P\x1b[99999L\x1b[99999M\x1b[99999S\x1b[99999T",
+	}
+	for _, in := range inputs {
+		term.Write([]byte(in))
+	}
+	// The unterminated OSC swallowed nothing after its BEL; the screen is
+	// still usable.
+	term.Write([]byte("\x1bc\x1b[1;1Hok"))
+	if got := screenText(term); got != "ok" {
+		t.Errorf("screen = %q, want ok", got)
+	}
+}